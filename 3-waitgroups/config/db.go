@@ -1,17 +1,14 @@
 package config
 
 import (
-	"exercise3/models"
 	"fmt"
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
-	"log"
 	"os"
 )
 
-var DB *gorm.DB
-
-func ConnectDatabase() {
+// ConnectDatabase opens the Postgres connection described by the DB_* env variables
+func ConnectDatabase() (*gorm.DB, error) {
 	dsn := fmt.Sprintf("host=%s user=%s password=%s dbname=%s port=%s sslmode=disable",
 		os.Getenv("DB_HOST"), os.Getenv("DB_USER"), os.Getenv("DB_PASSWORD"), os.Getenv("DB_NAME"), os.Getenv("DB_PORT"),
 	)
@@ -19,14 +16,8 @@ func ConnectDatabase() {
 	database, err := gorm.Open(postgres.Open(dsn), &gorm.Config{})
 
 	if err != nil {
-		log.Fatal("db connection error", err)
-	}
-
-	err = database.AutoMigrate(&models.CrawlPage{}, &models.Element{})
-
-	if err != nil {
-		log.Fatal("migration error", err)
+		return nil, fmt.Errorf("db connection error: %w", err)
 	}
 
-	DB = database
+	return database, nil
 }
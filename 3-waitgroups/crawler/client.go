@@ -0,0 +1,186 @@
+package crawler
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"golang.org/x/net/http/httpguts"
+	"net/http"
+	"net/http/cookiejar"
+	neturl "net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultMaxRedirects matches net/http, a profile's MaxRedirects of 0 means this many
+const DefaultMaxRedirects = 10
+
+// maxCachedClients bounds the client cache, profiles come from requests and every one keeps idle connections
+const maxCachedClients = 32
+
+// ErrRedirectPolicy marks a redirect the profile refused, retrying it can't help
+var ErrRedirectPolicy = errors.New("redirect refused by client profile")
+
+// ClientProfile configures the HTTP client of a crawl, the zero value is the shared default client.
+// Crawls with an equal profile share one client: its connection pool and, with Cookies, its cookie jar.
+type ClientProfile struct {
+	// UserAgent replaces the global UserAgent, robots.txt is matched against it too
+	UserAgent string            `json:"user_agent,omitempty"`
+	Headers   map[string]string `json:"headers,omitempty"`
+
+	// Cookies keeps the cookies the crawled sites set and sends them back
+	Cookies bool `json:"cookies,omitempty"`
+	// Proxy is an http://, https:// or socks5:// URL
+	Proxy              string `json:"proxy,omitempty"`
+	InsecureSkipVerify bool   `json:"insecure_skip_verify,omitempty"`
+
+	// MaxRedirects of 0 follows DefaultMaxRedirects hops, a negative value doesn't follow redirects at all
+	MaxRedirects int `json:"max_redirects,omitempty"`
+	// SameHostRedirects refuses redirects that leave the host of the requested URL
+	SameHostRedirects bool `json:"same_host_redirects,omitempty"`
+}
+
+func (p ClientProfile) Validate() error {
+	for name, value := range p.Headers {
+		if !httpguts.ValidHeaderFieldName(name) || !httpguts.ValidHeaderFieldValue(value) {
+			return fmt.Errorf("invalid header %q", name)
+		}
+	}
+	if !httpguts.ValidHeaderFieldValue(p.UserAgent) {
+		return fmt.Errorf("invalid user agent")
+	}
+
+	if p.Proxy != "" {
+		proxy, err := neturl.Parse(p.Proxy)
+		if err != nil || proxy.Host == "" {
+			return fmt.Errorf("invalid proxy url %q", p.Proxy)
+		}
+		switch proxy.Scheme {
+		case "http", "https", "socks5":
+		default:
+			return fmt.Errorf("unsupported proxy scheme %q, use http, https or socks5", proxy.Scheme)
+		}
+	}
+	return nil
+}
+
+// isDefault reports whether the profile changes nothing about the transport or the redirects
+func (p ClientProfile) isDefault() bool {
+	return !p.Cookies && p.Proxy == "" && !p.InsecureSkipVerify && p.MaxRedirects == 0 && !p.SameHostRedirects
+}
+
+// userAgent is the User-Agent the profile's requests send
+func (p ClientProfile) userAgent() string {
+	if p.UserAgent == "" {
+		return UserAgent
+	}
+	return p.UserAgent
+}
+
+// setHeaders adds the user agent and the extra headers to a request, the extra headers win
+func (p ClientProfile) setHeaders(request *http.Request) {
+	request.Header.Set("User-Agent", p.userAgent())
+
+	for name, value := range p.Headers {
+		request.Header.Set(name, value)
+	}
+}
+
+func (p ClientProfile) checkRedirect(request *http.Request, via []*http.Request) error {
+	hops := p.MaxRedirects
+	if hops == 0 {
+		hops = DefaultMaxRedirects
+	}
+	if hops < 0 {
+		// the redirect response itself becomes the result
+		return http.ErrUseLastResponse
+	}
+	if len(via) > hops {
+		return fmt.Errorf("%w: stopped after %d redirects", ErrRedirectPolicy, hops)
+	}
+	if p.SameHostRedirects && !strings.EqualFold(request.URL.Host, via[0].URL.Host) {
+		return fmt.Errorf("%w: redirect to another host %s", ErrRedirectPolicy, request.URL.Host)
+	}
+	return nil
+}
+
+// profileClient is the HTTP client of a profile with the robots.txt cache fetched through it,
+// a host only reachable through the proxy or with an unverified certificate still gets its rules
+type profileClient struct {
+	*http.Client
+	robots *RobotsCache
+}
+
+// clients caches one client per profile, so its connections are reused across the pages and crawls using it
+var clients = struct {
+	sync.Mutex
+	byProfile map[string]*profileClient
+}{byProfile: make(map[string]*profileClient)}
+
+// clientFor returns the cached client of the profile, building it on first use
+func clientFor(p ClientProfile) (*profileClient, error) {
+	if err := p.Validate(); err != nil {
+		return nil, err
+	}
+	if p.isDefault() {
+		return &profileClient{Client: http.DefaultClient, robots: DefaultRobots}, nil
+	}
+
+	// headers are set per request, they don't need a client of their own
+	p.UserAgent, p.Headers = "", nil
+	raw, err := json.Marshal(p)
+	if err != nil {
+		return nil, err
+	}
+	key := string(raw)
+
+	clients.Lock()
+	defer clients.Unlock()
+
+	if client, ok := clients.byProfile[key]; ok {
+		return client, nil
+	}
+
+	client, err := newClient(p)
+	if err != nil {
+		return nil, err
+	}
+
+	// evicting any entry is fine, the next crawl with that profile builds a fresh client
+	if len(clients.byProfile) >= maxCachedClients {
+		for old, c := range clients.byProfile {
+			c.CloseIdleConnections()
+			delete(clients.byProfile, old)
+			break
+		}
+	}
+	clients.byProfile[key] = client
+	return client, nil
+}
+
+func newClient(p ClientProfile) (*profileClient, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if p.Proxy != "" {
+		proxy, _ := neturl.Parse(p.Proxy)
+		transport.Proxy = http.ProxyURL(proxy)
+	}
+	if p.InsecureSkipVerify {
+		transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+	}
+
+	client := &http.Client{Transport: transport, CheckRedirect: p.checkRedirect}
+	if p.Cookies {
+		jar, err := cookiejar.New(nil)
+		if err != nil {
+			return nil, err
+		}
+		client.Jar = jar
+	}
+
+	robots := NewRobotsCache(DefaultRobots.TTL)
+	robots.Client = &http.Client{Transport: transport, Timeout: 10 * time.Second}
+
+	return &profileClient{Client: client, robots: robots}, nil
+}
@@ -0,0 +1,316 @@
+package crawler
+
+import (
+	"context"
+	"errors"
+	"exercise3/internal/testsite"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// echoSite answers every page with the request headers and cookies it got, /login sets a session cookie
+func echoSite(t *testing.T) *httptest.Server {
+	t.Helper()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/login", func(w http.ResponseWriter, r *http.Request) {
+		http.SetCookie(w, &http.Cookie{Name: "session", Value: "s3cret", Path: "/"})
+		testsite.WriteHTML(w, `<html><body><p>logged in</p></body></html>`)
+	})
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/robots.txt" {
+			http.NotFound(w, r)
+			return
+		}
+		session := "none"
+		if cookie, err := r.Cookie("session"); err == nil {
+			session = cookie.Value
+		}
+		testsite.WriteHTML(w, fmt.Sprintf(`<html><body><p>ua=%s</p><p>token=%s</p><p>session=%s</p></body></html>`,
+			r.UserAgent(), r.Header.Get("X-Crawl-Token"), session))
+	})
+
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return server
+}
+
+// paragraphs joins the content of the page's p elements
+func paragraphs(result PageResult) string {
+	var texts []string
+	for _, e := range result.Elements {
+		if e.ElementType == "p" {
+			texts = append(texts, e.Content)
+		}
+	}
+	return strings.Join(texts, " ")
+}
+
+func TestClientProfileHeaders(t *testing.T) {
+	site := echoSite(t)
+
+	result, err := Fetch(context.Background(), site.URL+"/", testOptions())
+	if err != nil {
+		t.Fatalf("Fetch: %v", err)
+	}
+	if got, want := paragraphs(result), "ua="+UserAgent+" token= session=none"; got != want {
+		t.Errorf("default profile sent %q, want %q", got, want)
+	}
+
+	opts := testOptions()
+	opts.Client = ClientProfile{UserAgent: "custom-bot/2.0", Headers: map[string]string{"X-Crawl-Token": "abc"}}
+	result, err = Fetch(context.Background(), site.URL+"/", opts)
+	if err != nil {
+		t.Fatalf("Fetch: %v", err)
+	}
+	if got, want := paragraphs(result), "ua=custom-bot/2.0 token=abc session=none"; got != want {
+		t.Errorf("custom profile sent %q, want %q", got, want)
+	}
+}
+
+func TestClientProfileCookies(t *testing.T) {
+	tests := []struct {
+		cookies bool
+		want    string
+	}{
+		{false, "none"},
+		{true, "s3cret"},
+	}
+
+	for _, tt := range tests {
+		site := echoSite(t)
+		opts := testOptions()
+		opts.Client = ClientProfile{Cookies: tt.cookies}
+
+		if _, err := Fetch(context.Background(), site.URL+"/login", opts); err != nil {
+			t.Fatalf("Fetch /login: %v", err)
+		}
+		result, err := Fetch(context.Background(), site.URL+"/account", opts)
+		if err != nil {
+			t.Fatalf("Fetch /account: %v", err)
+		}
+		if got := paragraphs(result); !strings.HasSuffix(got, " session="+tt.want) {
+			t.Errorf("cookies %v: page saw %q, want session %q", tt.cookies, got, tt.want)
+		}
+	}
+}
+
+func TestClientProfileRedirects(t *testing.T) {
+	site := newFixtureSite(t)
+
+	opts := testOptions()
+	opts.Client.MaxRedirects = 2
+	result, err := Fetch(context.Background(), site.URL+"/loop", opts)
+	if !errors.Is(err, ErrRedirectPolicy) || result.Status != StatusFetchError {
+		t.Fatalf("redirect loop: got status %q, err %v", result.Status, err)
+	}
+	// a refused redirect is refused again on the next attempt, it isn't retried
+	if result.Attempts != 1 || !strings.Contains(result.Error, "stopped after 2 redirects") {
+		t.Errorf("got %d attempts, error %q", result.Attempts, result.Error)
+	}
+
+	// not following redirects makes the redirect itself the result
+	opts.Client.MaxRedirects = -1
+	result, err = Fetch(context.Background(), site.URL+"/old", opts)
+	if err == nil || result.Status != StatusHTTPError || result.StatusCode != http.StatusMovedPermanently {
+		t.Errorf("no redirects: got status %q, code %d, err %v", result.Status, result.StatusCode, err)
+	}
+}
+
+func TestClientProfileSameHostRedirects(t *testing.T) {
+	other := newFixtureSite(t)
+	site := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/robots.txt":
+			http.NotFound(w, r)
+		case "/away":
+			http.Redirect(w, r, other.URL+"/a", http.StatusFound)
+		default:
+			http.Redirect(w, r, "/away", http.StatusFound)
+		}
+	}))
+	t.Cleanup(site.Close)
+
+	opts := testOptions()
+	result, err := Fetch(context.Background(), site.URL+"/start", opts)
+	if err != nil || result.Status != StatusSuccess {
+		t.Fatalf("default profile: got status %q, err %v", result.Status, err)
+	}
+
+	// the hop within the host is fine, leaving it isn't
+	opts.Client.SameHostRedirects = true
+	result, err = Fetch(context.Background(), site.URL+"/start", opts)
+	if !errors.Is(err, ErrRedirectPolicy) || result.Status != StatusFetchError || result.Attempts != 1 {
+		t.Errorf("got status %q after %d attempts, err %v", result.Status, result.Attempts, err)
+	}
+}
+
+func TestClientProfileProxy(t *testing.T) {
+	var mu sync.Mutex
+	var proxied []string
+
+	// the proxy answers for every host itself, the crawled host doesn't exist
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		proxied = append(proxied, r.URL.String())
+		mu.Unlock()
+
+		if r.URL.Path == "/robots.txt" {
+			fmt.Fprint(w, "User-agent: *\nDisallow: /private\n")
+			return
+		}
+		testsite.WriteHTML(w, `<html><body><p>via proxy</p></body></html>`)
+	}))
+	t.Cleanup(proxy.Close)
+
+	opts := testOptions()
+	opts.Client.Proxy = proxy.URL
+
+	result, err := Fetch(context.Background(), "http://crawl.invalid/page", opts)
+	if err != nil || paragraphs(result) != "via proxy" {
+		t.Fatalf("got %q, err %v", paragraphs(result), err)
+	}
+	// robots.txt comes through the proxy as well
+	if result, _ := Fetch(context.Background(), "http://crawl.invalid/private/page", opts); result.Status != StatusBlockedByRobots {
+		t.Errorf("/private: status %q, want %q", result.Status, StatusBlockedByRobots)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if want := []string{"http://crawl.invalid/robots.txt", "http://crawl.invalid/page"}; strings.Join(proxied, " ") != strings.Join(want, " ") {
+		t.Errorf("proxy saw %v, want %v", proxied, want)
+	}
+}
+
+func TestClientProfileProxyCrawlDelay(t *testing.T) {
+	var mu sync.Mutex
+	var pages []time.Time
+
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/robots.txt" {
+			fmt.Fprint(w, "User-agent: *\nCrawl-delay: 1\n")
+			return
+		}
+		mu.Lock()
+		pages = append(pages, time.Now())
+		mu.Unlock()
+		testsite.WriteHTML(w, `<html><body><p>via proxy</p></body></html>`)
+	}))
+	t.Cleanup(proxy.Close)
+
+	opts := testOptions()
+	opts.Client.Proxy = proxy.URL
+
+	// the Crawl-delay only exists behind the proxy, the limiter has to read robots.txt through it
+	results := Crawl(context.Background(), []string{"http://slow.invalid/1", "http://slow.invalid/2"}, opts)
+	for _, r := range results {
+		if r.Status != StatusSuccess {
+			t.Fatalf("%s: status %q, error %q", r.URL, r.Status, r.Error)
+		}
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(pages) != 2 || pages[1].Sub(pages[0]) < 900*time.Millisecond {
+		t.Errorf("pages fetched at %v, want them 1s apart", pages)
+	}
+}
+
+func TestClientProfileUserAgentRobots(t *testing.T) {
+	site := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/robots.txt" {
+			fmt.Fprint(w, "User-agent: *\nAllow: /\n\nUser-agent: pickybot\nDisallow: /\n")
+			return
+		}
+		testsite.WriteHTML(w, `<html><body><p>page</p></body></html>`)
+	}))
+	t.Cleanup(site.Close)
+
+	opts := testOptions()
+	if result, err := Fetch(context.Background(), site.URL+"/page", opts); err != nil || result.Status != StatusSuccess {
+		t.Errorf("default user agent: status %q, err %v", result.Status, err)
+	}
+
+	// robots.txt is matched against the user agent the requests send
+	opts.Client.UserAgent = "PickyBot/2.0"
+	if result, _ := Fetch(context.Background(), site.URL+"/page", opts); result.Status != StatusBlockedByRobots {
+		t.Errorf("profile user agent: status %q, want %q", result.Status, StatusBlockedByRobots)
+	}
+}
+
+func TestClientProfileInsecureSkipVerify(t *testing.T) {
+	site := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		testsite.WriteHTML(w, `<html><body><p>self-signed</p></body></html>`)
+	}))
+	t.Cleanup(site.Close)
+
+	// the certificate isn't trusted, not even robots.txt can be fetched and the host is disallowed
+	result, err := Fetch(context.Background(), site.URL+"/", testOptions())
+	if err == nil || result.Status != StatusBlockedByRobots {
+		t.Errorf("default profile: got status %q, err %v", result.Status, err)
+	}
+
+	opts := testOptions()
+	opts.Client.InsecureSkipVerify = true
+	result, err = Fetch(context.Background(), site.URL+"/", opts)
+	if err != nil || paragraphs(result) != "self-signed" {
+		t.Errorf("insecure profile: got status %q, err %v", result.Status, err)
+	}
+}
+
+func TestClientForSharesClients(t *testing.T) {
+	base := ClientProfile{Cookies: true, SameHostRedirects: true}
+	withHeaders := base
+	withHeaders.UserAgent = "other-bot"
+	withHeaders.Headers = map[string]string{"Accept-Language": "tr"}
+
+	a, err := clientFor(base)
+	if err != nil {
+		t.Fatalf("clientFor: %v", err)
+	}
+	b, _ := clientFor(withHeaders)
+	if a != b {
+		t.Error("profiles differing only in headers should share a client")
+	}
+
+	c, _ := clientFor(ClientProfile{Cookies: true})
+	if c == a {
+		t.Error("different profiles should get different clients")
+	}
+
+	d, _ := clientFor(ClientProfile{UserAgent: "other-bot"})
+	if d.Client != http.DefaultClient || d.robots != DefaultRobots {
+		t.Error("a headers-only profile should use the default client")
+	}
+}
+
+func TestClientProfileValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		profile ClientProfile
+		valid   bool
+	}{
+		{"zero", ClientProfile{}, true},
+		{"full", ClientProfile{UserAgent: "bot/1.0", Headers: map[string]string{"X-Token": "abc"}, Proxy: "socks5://127.0.0.1:1080", Cookies: true}, true},
+		{"bad header name", ClientProfile{Headers: map[string]string{"Bad Header": "x"}}, false},
+		{"header injection", ClientProfile{Headers: map[string]string{"X-Token": "a\r\nHost: evil"}}, false},
+		{"bad user agent", ClientProfile{UserAgent: "bot\n"}, false},
+		{"proxy without host", ClientProfile{Proxy: "http://"}, false},
+		{"unsupported proxy", ClientProfile{Proxy: "ftp://proxy:21"}, false},
+	}
+
+	for _, tt := range tests {
+		err := tt.profile.Validate()
+		if (err == nil) != tt.valid {
+			t.Errorf("%s: Validate() = %v, want valid %v", tt.name, err, tt.valid)
+		}
+		if _, err := clientFor(tt.profile); (err == nil) != tt.valid {
+			t.Errorf("%s: clientFor() = %v, want valid %v", tt.name, err, tt.valid)
+		}
+	}
+}
@@ -1,20 +1,166 @@
 package crawler
 
 import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"exercise3/models"
+	"exercise3/simhash"
+	"fmt"
 	"github.com/PuerkitoBio/goquery"
+	"golang.org/x/net/html/charset"
 	"io"
 	"log"
+	"mime"
 	"net/http"
+	neturl "net/url"
 	"strings"
-	"sync"
+	"time"
 )
 
-func Fetch(url string) (PageResult, error) {
+const (
+	DefaultFetchTimeout = 30 * time.Second
+	DefaultMaxBodySize  = 10 << 20
+)
+
+// FetchTimeout bounds a single page fetch, the job's own deadline comes from the caller's context
+var FetchTimeout = DefaultFetchTimeout
+
+var (
+	ErrBodyTooLarge = errors.New("response body too large")
+	ErrNotHTML      = errors.New("unsupported content type")
+)
+
+func Fetch(ctx context.Context, url string, opts Options) (PageResult, error) {
+	page, err := download(ctx, url, opts)
+	if err != nil {
+		return page.result, err
+	}
+
+	if err := page.parse(opts.Extraction); err != nil {
+		return page.result, err
+	}
+	return page.result, nil
+}
+
+// downloaded is a page whose body is read but not parsed yet, the pipeline parses it in its own stage
+type downloaded struct {
+	result PageResult
+	body   []byte
+
+	// url is the final url after redirects, links resolve against it
+	url *neturl.URL
+}
+
+func (d *downloaded) parse(spec ExtractionSpec) error {
+	// a 304 has no body, the stored version stays current
+	if d.result.Status == StatusNotModified {
+		return nil
+	}
+
+	if err := parsePage(&d.result, d.body, d.url, spec); err != nil {
+		d.result.fail(StatusParseError, err)
+		pagesFailed.With(StatusParseError).Inc()
+		return err
+	}
+
+	// the body isn't needed anymore, don't keep it alive while the page waits for the database
+	d.body = nil
+	return nil
+}
+
+// download checks robots.txt and fetches the body, retrying transient failures
+func download(ctx context.Context, url string, opts Options) (page downloaded, err error) {
+	page.result = PageResult{URL: url}
+
+	defer func() {
+		if err != nil {
+			pagesFailed.With(page.result.Status).Inc()
+		} else {
+			pagesFetched.Inc()
+		}
+	}()
+
+	// when the caller gave up, the page is reported as canceled rather than as a fetch error
+	defer func() {
+		if err != nil && ctx.Err() != nil {
+			err = ctx.Err()
+			page.result.fail(StatusCanceled, err)
+		}
+	}()
+
+	if err := ctx.Err(); err != nil {
+		return page, err
+	}
+
+	client, err := clientFor(opts.Client)
+	if err != nil {
+		page.result.fail(StatusFetchError, err)
+		return page, err
+	}
+
+	if !client.robots.Allowed(ctx, opts.Client.userAgent(), url) {
+		page.result.fail(StatusBlockedByRobots, ErrBlockedByRobots)
+		return page, ErrBlockedByRobots
+	}
+
+	for {
+		page.result.Attempts++
+
+		retryAfter, retry, err := fetchOnce(ctx, client, url, opts, &page)
+		if err == nil || !retry || page.result.Attempts >= opts.Retry.attempts() {
+			return page, err
+		}
+
+		wait := opts.Retry.delay(page.result.Attempts, retryAfter)
+		log.Println("Retrying URL:", url, "attempt", page.result.Attempts, "in", wait, err)
+
+		if err := retryWait(ctx, wait); err != nil {
+			return page, err
+		}
+	}
+}
+
+// fetchOnce makes a single attempt, it reports whether a failure is worth retrying
+// and how long the server asked us to wait with Retry-After
+func fetchOnce(ctx context.Context, client *profileClient, url string, opts Options, page *downloaded) (time.Duration, bool, error) {
+	result := &page.result
+	result.StatusCode = 0
+
+	fetchCtx, cancel := context.WithTimeout(ctx, FetchTimeout)
+	defer cancel()
+
+	request, err := http.NewRequestWithContext(fetchCtx, http.MethodGet, url, nil)
+	if err != nil {
+		result.fail(StatusFetchError, err)
+		return 0, false, err
+	}
+	opts.Client.setHeaders(request)
+
+	validator, conditional := opts.Validators[url]
+	if conditional {
+		if validator.ETag != "" {
+			request.Header.Set("If-None-Match", validator.ETag)
+		}
+		if validator.LastModified != "" {
+			request.Header.Set("If-Modified-Since", validator.LastModified)
+		}
+	}
+
+	fetchesInFlight.Inc()
+	start := time.Now()
+	defer func() {
+		fetchesInFlight.Dec()
+		fetchDuration.Observe(time.Since(start).Seconds())
+	}()
+
 	// HTTP isteği
-	response, err := http.Get(url)
+	response, err := client.Do(request)
 	if err != nil {
-		return PageResult{}, err
+		result.fail(StatusFetchError, err)
+		return 0, !errors.Is(err, ErrRedirectPolicy), err
 	}
 
 	// in normal conditions defer func allows only func calls but there i want to catch the errors which comes from http body's built-in closer func
@@ -25,59 +171,146 @@ func Fetch(url string) (PageResult, error) {
 		}
 	}(response.Body)
 
-	doc, err := goquery.NewDocumentFromReader(response.Body)
+	result.FetchedAt = time.Now()
+	result.StatusCode = response.StatusCode
+	result.ETag = response.Header.Get("ETag")
+	result.LastModified = response.Header.Get("Last-Modified")
 
-	if err != nil {
-		return PageResult{}, err
+	if response.StatusCode == http.StatusNotModified && conditional {
+		// a 304 may leave out validators that didn't change
+		if result.ETag == "" {
+			result.ETag = validator.ETag
+		}
+		if result.LastModified == "" {
+			result.LastModified = validator.LastModified
+		}
+		result.Status = StatusNotModified
+		result.Error = ""
+		result.ContentType = validator.ContentType
+		result.BytesSaved = validator.Bytes
+		return 0, false, nil
 	}
 
-	var elements []models.Element
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		err = fmt.Errorf("unexpected HTTP status: %s", response.Status)
+		result.fail(StatusHTTPError, err)
 
-	doc.Find("h1,h2,h3,p,a,img").Each(func(i int, s *goquery.Selection) {
-		tag := goquery.NodeName(s)
-		text := strings.TrimSpace(s.Text())
-		attr := ""
+		retryAfter := parseRetryAfter(response.Header.Get("Retry-After"), time.Now())
+		return retryAfter, opts.Retry.retryableStatus(response.StatusCode), err
+	}
 
-		if tag == "a" {
-			attr, _ = s.Attr("href")
-		} else if tag == "img" {
-			attr, _ = s.Attr("src")
-		}
+	result.ContentType = response.Header.Get("Content-Type")
 
-		elements = append(elements, models.Element{
-			ElementType: tag,
-			Content:     text,
-			Attribute:   attr,
-			Position:    i,
-		})
-	})
+	// a declared length over the cap fails before a single byte is read
+	limit := opts.maxBodySize()
+	if response.ContentLength > limit {
+		err = fmt.Errorf("%w: %d bytes declared, limit is %d", ErrBodyTooLarge, response.ContentLength, limit)
+		result.fail(StatusTooLarge, err)
+		return 0, false, err
+	}
+
+	body, err := io.ReadAll(io.LimitReader(response.Body, limit+1))
+	result.Bytes = int64(len(body))
+	bytesDownloaded.Add(uint64(len(body)))
 
-	return PageResult{
-		URL:      url,
-		Elements: elements,
-	}, nil
+	// a body cut off by the network is a transient failure, not a broken page
+	if err != nil {
+		result.fail(StatusFetchError, err)
+		return 0, true, err
+	}
+	if int64(len(body)) > limit {
+		err = fmt.Errorf("%w: limit is %d bytes", ErrBodyTooLarge, limit)
+		result.fail(StatusTooLarge, err)
+		return 0, false, err
+	}
+
+	if !isHTML(result.ContentType, body) {
+		err = fmt.Errorf("%w: %q", ErrNotHTML, result.ContentType)
+		result.fail(StatusSkipped, err)
+		return 0, false, err
+	}
+
+	page.body = body
+	page.url = response.Request.URL
+
+	return 0, false, nil
 }
 
-func Crawl(urls []string) []PageResult {
-	var wg sync.WaitGroup
-	results := make([]PageResult, len(urls))
+// parsePage decodes the body to UTF-8, using the Content-Type charset, a BOM or a <meta charset>,
+// and fills the elements, metadata, content hash and SimHash of the result
+func parsePage(result *PageResult, body []byte, pageURL *neturl.URL, spec ExtractionSpec) error {
+	reader, err := charset.NewReader(bytes.NewReader(body), result.ContentType)
+	if err != nil {
+		return err
+	}
 
-	for i, url := range urls {
-		wg.Add(1)
+	doc, err := goquery.NewDocumentFromReader(reader)
+	if err != nil {
+		return err
+	}
+
+	// the raw body is hashed together with the rules, re-crawls store a new version when either changed
+	result.ExtractionHash = spec.Hash()
+	hash := sha256.New()
+	hash.Write(body)
+	hash.Write([]byte(result.ExtractionHash))
+
+	result.Status = StatusSuccess
+	result.Error = ""
+	result.ContentHash = hex.EncodeToString(hash.Sum(nil))
+
+	base := documentBase(pageURL, doc.Find("base[href]").First().AttrOr("href", ""))
+	result.Elements = Extract(doc, spec, base)
+	result.SimHash = simhash.Fingerprint(elementText(result.Elements))
+
+	meta := ExtractMetadata(doc, base)
+	result.Meta = &meta
+
+	return nil
+}
+
+// elementText joins the extracted text, it is what near-duplicate pages are compared on
+func elementText(elements []models.Element) string {
+	var text strings.Builder
+	for _, e := range elements {
+		text.WriteString(e.Content)
+		text.WriteByte(' ')
+	}
+	return text.String()
+}
 
-		go func(i int, url string) {
-			defer wg.Done()
+// isHTML trusts the Content-Type header and sniffs the body only when the header is missing
+func isHTML(contentType string, body []byte) bool {
+	if contentType == "" {
+		contentType = http.DetectContentType(body)
+	}
 
-			res, err := Fetch(url)
-			if err == nil {
-				results[i] = res
-			} else {
-				log.Println("Error fetching URL:", url, err)
-			}
-		}(i, url)
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
 	}
+	return mediaType == "text/html" || mediaType == "application/xhtml+xml"
+}
 
-	wg.Wait()
+func Crawl(ctx context.Context, urls []string, opts Options) []PageResult {
+	results := make([]PageResult, len(urls))
+
+	DefaultPool.Each(withProfile(ctx, opts.Client), urls, func(ctx context.Context, i int, url string) {
+		// failed fetches keep their status and error so callers can report them
+		res, err := Fetch(ctx, url, opts)
+		if err != nil {
+			log.Println("Error fetching URL:", url, err)
+		}
+		results[i] = res
+	})
+
+	// urls still queued when the context ended were never fetched
+	for i, url := range urls {
+		if results[i].Status == "" {
+			results[i] = PageResult{URL: url}
+			results[i].fail(StatusCanceled, ctx.Err())
+		}
+	}
 
 	return results
 }
@@ -0,0 +1,318 @@
+package crawler
+
+import (
+	"context"
+	"errors"
+	"exercise3/models"
+	neturl "net/url"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf16"
+)
+
+func TestFetchExtractsElements(t *testing.T) {
+	site := newFixtureSite(t)
+
+	result, err := Fetch(context.Background(), site.URL+"/", testOptions())
+	if err != nil {
+		t.Fatalf("Fetch: %v", err)
+	}
+	if result.Status != StatusSuccess || result.StatusCode != 200 || result.Attempts != 1 {
+		t.Fatalf("got status %q, code %d, %d attempts", result.Status, result.StatusCode, result.Attempts)
+	}
+	if result.ContentHash == "" || result.FetchedAt.IsZero() {
+		t.Error("content hash and fetch time should be set")
+	}
+
+	want := []models.Element{
+		{ElementType: "h1", Content: "Home"},
+		{ElementType: "p", Content: "Welcome", Position: 1},
+		{ElementType: "a", Content: "Go to A", Position: 2, Attribute: "/a", ResolvedURL: site.URL + "/a"},
+		{ElementType: "img", Position: 3, Attribute: "/logo.png", ResolvedURL: site.URL + "/logo.png"},
+	}
+	if len(result.Elements) != len(want) {
+		t.Fatalf("got %d elements, want %d: %+v", len(result.Elements), len(want), result.Elements)
+	}
+	for i, w := range want {
+		got := result.Elements[i]
+		if got.ElementType != w.ElementType || got.Content != w.Content || got.Position != w.Position ||
+			got.Attribute != w.Attribute || got.ResolvedURL != w.ResolvedURL {
+			t.Errorf("element %d = %+v, want %+v", i, got, w)
+		}
+	}
+
+	meta := result.Meta
+	if meta == nil || meta.Title != "Home" || meta.Language != "en" || meta.Description != "The fixture home page" {
+		t.Errorf("Meta = %+v", meta)
+	}
+}
+
+func TestFetchLinkCycle(t *testing.T) {
+	site := newFixtureSite(t)
+
+	links := make(map[string][]string)
+	for _, path := range []string{"/", "/a", "/b"} {
+		result, err := Fetch(context.Background(), site.URL+path, testOptions())
+		if err != nil {
+			t.Fatalf("Fetch %s: %v", path, err)
+		}
+		for _, e := range result.Elements {
+			if e.ElementType == "a" {
+				links[path] = append(links[path], strings.TrimPrefix(e.ResolvedURL, site.URL))
+			}
+		}
+	}
+
+	// relative hrefs, fragments and self links all resolve to the normalized page urls
+	want := map[string]string{
+		"/":  "/a",
+		"/a": "/b /",
+		"/b": "/a /b",
+	}
+	for path, w := range want {
+		if got := strings.Join(links[path], " "); got != w {
+			t.Errorf("links of %s = %q, want %q", path, got, w)
+		}
+	}
+}
+
+func TestFetchRedirects(t *testing.T) {
+	site := newFixtureSite(t)
+
+	result, err := Fetch(context.Background(), site.URL+"/old", testOptions())
+	if err != nil {
+		t.Fatalf("Fetch: %v", err)
+	}
+	if result.URL != site.URL+"/old" || result.Status != StatusSuccess {
+		t.Errorf("got %q with status %q, want the requested url with success", result.URL, result.Status)
+	}
+	if len(result.Elements) != 2 || result.Elements[1].ResolvedURL != site.URL+"/new/child" {
+		t.Errorf("links should resolve against the redirect target: %+v", result.Elements)
+	}
+
+	opts := testOptions()
+	opts.Retry.MaxAttempts = 1
+	result, err = Fetch(context.Background(), site.URL+"/loop", opts)
+	if err == nil || result.Status != StatusFetchError {
+		t.Fatalf("redirect loop: got status %q, err %v", result.Status, err)
+	}
+	if !strings.Contains(result.Error, "stopped after 10 redirects") {
+		t.Errorf("Error = %q", result.Error)
+	}
+}
+
+func TestFetchServerErrors(t *testing.T) {
+	site := newFixtureSite(t)
+
+	tests := []struct {
+		path      string
+		status    string
+		code      int
+		attempts  int
+		lastError string
+	}{
+		// 500 is retryable, every attempt is spent
+		{"/error", StatusHTTPError, 500, 3, "500 Internal Server Error"},
+		{"/missing", StatusHTTPError, 404, 1, "404 Not Found"},
+		// the failure of the first attempt stays visible after the retry succeeded
+		{"/flaky", StatusSuccess, 200, 2, "503 Service Unavailable"},
+	}
+
+	for _, tt := range tests {
+		result, _ := Fetch(context.Background(), site.URL+tt.path, testOptions())
+
+		if result.Status != tt.status || result.StatusCode != tt.code || result.Attempts != tt.attempts {
+			t.Errorf("%s: got status %q, code %d, %d attempts, want %q, %d, %d",
+				tt.path, result.Status, result.StatusCode, result.Attempts, tt.status, tt.code, tt.attempts)
+		}
+		if !strings.Contains(result.LastError, tt.lastError) {
+			t.Errorf("%s: LastError = %q, want it to mention %q", tt.path, result.LastError, tt.lastError)
+		}
+		if hits := site.Hits(tt.path); hits != tt.attempts {
+			t.Errorf("%s: requested %d times, want %d", tt.path, hits, tt.attempts)
+		}
+	}
+}
+
+func TestFetchSlowPage(t *testing.T) {
+	site := newFixtureSite(t)
+
+	fetchTimeout := FetchTimeout
+	FetchTimeout = 50 * time.Millisecond
+	t.Cleanup(func() { FetchTimeout = fetchTimeout })
+
+	// the per-fetch timeout is a fetch error, worth retrying
+	opts := testOptions()
+	opts.Retry.MaxAttempts = 2
+	result, err := Fetch(context.Background(), site.URL+"/slow", opts)
+	if !errors.Is(err, context.DeadlineExceeded) || result.Status != StatusFetchError || result.Attempts != 2 {
+		t.Errorf("fetch timeout: got status %q after %d attempts, err %v", result.Status, result.Attempts, err)
+	}
+
+	// the caller giving up is a cancellation
+	FetchTimeout = fetchTimeout
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	result, err = Fetch(ctx, site.URL+"/slow", opts)
+	if !errors.Is(err, context.DeadlineExceeded) || result.Status != StatusCanceled {
+		t.Errorf("job deadline: got status %q, err %v", result.Status, err)
+	}
+}
+
+func TestFetchHugeBodies(t *testing.T) {
+	site := newFixtureSite(t)
+	opts := testOptions()
+
+	// a declared length over the limit fails before the body is read
+	result, err := Fetch(context.Background(), site.URL+"/huge", opts)
+	if !errors.Is(err, ErrBodyTooLarge) || result.Status != StatusTooLarge || result.Bytes != 0 {
+		t.Errorf("/huge: got status %q, %d bytes, err %v", result.Status, result.Bytes, err)
+	}
+
+	// without a length the body is read up to one byte past the limit
+	result, err = Fetch(context.Background(), site.URL+"/huge-stream", opts)
+	if !errors.Is(err, ErrBodyTooLarge) || result.Status != StatusTooLarge || result.Bytes != opts.MaxBodySize+1 {
+		t.Errorf("/huge-stream: got status %q, %d bytes, err %v", result.Status, result.Bytes, err)
+	}
+	if result.Attempts != 1 {
+		t.Errorf("too large bodies shouldn't be retried, got %d attempts", result.Attempts)
+	}
+}
+
+func TestFetchNonHTML(t *testing.T) {
+	site := newFixtureSite(t)
+
+	tests := []struct {
+		path        string
+		status      string
+		contentType string
+	}{
+		{"/data.json", StatusSkipped, "application/json"},
+		{"/logo.png", StatusSkipped, ""},
+		{"/untyped", StatusSuccess, ""},
+	}
+
+	for _, tt := range tests {
+		result, err := Fetch(context.Background(), site.URL+tt.path, testOptions())
+
+		if result.Status != tt.status || result.ContentType != tt.contentType {
+			t.Errorf("%s: got status %q, content type %q, want %q, %q",
+				tt.path, result.Status, result.ContentType, tt.status, tt.contentType)
+		}
+		if tt.status == StatusSkipped && (!errors.Is(err, ErrNotHTML) || len(result.Elements) != 0) {
+			t.Errorf("%s: err = %v with %d elements, want ErrNotHTML and none", tt.path, err, len(result.Elements))
+		}
+	}
+}
+
+func TestCrawlReportsEveryURL(t *testing.T) {
+	site := newFixtureSite(t)
+
+	tests := []struct {
+		url    string
+		status string
+	}{
+		{site.URL + "/", StatusSuccess},
+		{site.URL + "/a", StatusSuccess},
+		{site.URL + "/b", StatusSuccess},
+		{site.URL + "/a", StatusSuccess},
+		{site.URL + "/old", StatusSuccess},
+		{site.URL + "/error", StatusHTTPError},
+		{site.URL + "/huge", StatusTooLarge},
+		{site.URL + "/data.json", StatusSkipped},
+		{site.URL + "/private/page", StatusBlockedByRobots},
+		// robots.txt can't be read from a host that refuses connections, that disallows the whole host
+		{"http://127.0.0.1:1/refused", StatusBlockedByRobots},
+	}
+
+	urls := make([]string, len(tests))
+	for i, tt := range tests {
+		urls[i] = tt.url
+	}
+
+	results := Crawl(context.Background(), urls, testOptions())
+	if len(results) != len(tests) {
+		t.Fatalf("got %d results for %d urls", len(results), len(tests))
+	}
+	for i, tt := range tests {
+		r := results[i]
+		if r.URL != tt.url || r.Status != tt.status {
+			t.Errorf("result %d: got %s %q, want %s %q", i, r.URL, r.Status, tt.url, tt.status)
+		}
+		if (r.Status == StatusSuccess) != (r.Error == "") {
+			t.Errorf("result %d: status %q with error %q", i, r.Status, r.Error)
+		}
+	}
+	if hits := site.Hits("/private/page"); hits != 0 {
+		t.Errorf("a page blocked by robots.txt was requested %d times", hits)
+	}
+}
+
+func TestCrawlCanceled(t *testing.T) {
+	site := newFixtureSite(t)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	results := Crawl(ctx, []string{site.URL + "/slow", site.URL + "/a", site.URL + "/slow?again"}, testOptions())
+
+	if took := time.Since(start); took > time.Second {
+		t.Errorf("Crawl took %s after its context ended", took)
+	}
+
+	want := []string{StatusCanceled, StatusSuccess, StatusCanceled}
+	for i, status := range want {
+		if results[i].Status != status {
+			t.Errorf("result %d: status %q, want %q (%s)", i, results[i].Status, status, results[i].Error)
+		}
+	}
+}
+
+func TestPageResultFailWithoutError(t *testing.T) {
+	var r PageResult
+	r.fail(StatusCanceled, context.Background().Err())
+	if r.Status != StatusCanceled || r.Error != "" || r.LastError != "" {
+		t.Errorf("result = %+v", r)
+	}
+}
+
+func TestParsePageCharsets(t *testing.T) {
+	utf16le := func(s string) string {
+		b := []byte{0xff, 0xfe}
+		for _, r := range utf16.Encode([]rune(s)) {
+			b = append(b, byte(r), byte(r>>8))
+		}
+		return string(b)
+	}
+
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+		want        string
+	}{
+		{"utf-8 without hints", "text/html", "<p>café</p>", "café"},
+		{"content-type charset", "text/html; charset=ISO-8859-1", "<p>caf\xe9</p>", "café"},
+		{"meta charset", "text/html", "<meta charset=\"windows-1252\"><p>caf\xe9</p>", "café"},
+		{"meta http-equiv", "text/html", `<meta http-equiv="Content-Type" content="text/html; charset=shift_jis"><p>` + "\x93\xfa\x96\x7b" + `</p>`, "日本"},
+		{"content-type beats meta", "text/html; charset=utf-8", `<meta charset="iso-8859-1"><p>café</p>`, "café"},
+		{"utf-8 bom beats content-type", "text/html; charset=iso-8859-1", "\xef\xbb\xbf<p>café</p>", "café"},
+		{"utf-16 bom", "text/html", utf16le("<p>café</p>"), "café"},
+	}
+
+	pageURL, _ := neturl.Parse("http://example.com/")
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := PageResult{ContentType: tt.contentType}
+			if err := parsePage(&result, []byte(tt.body), pageURL, DefaultOptions().Extraction); err != nil {
+				t.Fatalf("parsePage: %v", err)
+			}
+			if len(result.Elements) != 1 || result.Elements[0].Content != tt.want {
+				t.Errorf("elements = %+v, want the paragraph %q", result.Elements, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,102 @@
+package crawler
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"exercise3/models"
+	"fmt"
+	"github.com/PuerkitoBio/goquery"
+	"github.com/andybalholm/cascadia"
+	"net/url"
+	"strings"
+)
+
+// ExtractionRule picks elements with a CSS selector. Name becomes the element's Field,
+// every listed attribute that is present is captured and the first one is also kept as Attribute.
+type ExtractionRule struct {
+	Name       string   `json:"name"`
+	Selector   string   `json:"selector"`
+	Attributes []string `json:"attributes"`
+}
+
+// ExtractionSpec is the set of rules applied to every page of a crawl
+type ExtractionSpec struct {
+	Rules []ExtractionRule `json:"rules"`
+}
+
+// DefaultExtraction is the behavior the crawler always had: headings, paragraphs, links and images
+var DefaultExtraction = ExtractionSpec{Rules: []ExtractionRule{{
+	Selector:   "h1,h2,h3,p,a,img",
+	Attributes: []string{"href", "src"},
+}}}
+
+// Profiles are the named specs a crawl request can pick instead of sending its own rules
+var Profiles = map[string]ExtractionSpec{
+	"default": DefaultExtraction,
+}
+
+func (s ExtractionSpec) Validate() error {
+	if len(s.Rules) == 0 {
+		return fmt.Errorf("extraction spec has no rules")
+	}
+
+	for _, rule := range s.Rules {
+		if _, err := cascadia.ParseGroup(rule.Selector); err != nil {
+			return fmt.Errorf("invalid selector %q: %w", rule.Selector, err)
+		}
+	}
+	return nil
+}
+
+// Hash identifies the rules, pages extracted with other rules are stored as a new version
+func (s ExtractionSpec) Hash() string {
+	raw, err := json.Marshal(s)
+	if err != nil {
+		return ""
+	}
+	hash := sha256.Sum256(raw)
+	return hex.EncodeToString(hash[:])
+}
+
+// Extract applies the rules in order, Position keeps counting across rules.
+// Link attributes are resolved against base into ResolvedURL, the raw value stays in Attribute.
+func Extract(doc *goquery.Document, spec ExtractionSpec, base *url.URL) []models.Element {
+	if len(spec.Rules) == 0 {
+		spec = DefaultExtraction
+	}
+
+	var elements []models.Element
+
+	for _, rule := range spec.Rules {
+		doc.Find(rule.Selector).Each(func(_ int, s *goquery.Selection) {
+			element := models.Element{
+				Field:       rule.Name,
+				ElementType: goquery.NodeName(s),
+				Content:     strings.TrimSpace(s.Text()),
+				Position:    len(elements),
+			}
+
+			for _, name := range rule.Attributes {
+				value, ok := s.Attr(name)
+				if !ok {
+					continue
+				}
+
+				if element.Attributes == nil {
+					element.Attribute = value
+					element.Attributes = models.JSONMap{}
+
+					if urlAttributes[name] {
+						element.ResolvedURL, _ = ResolveURL(base, value)
+					}
+				}
+				element.Attributes[name] = value
+			}
+
+			elements = append(elements, element)
+		})
+	}
+
+	return elements
+}
@@ -0,0 +1,95 @@
+package crawler
+
+import (
+	"exercise3/internal/testsite"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestMain lifts the per-host rate limit, every fixture site is a single local host
+func TestMain(m *testing.M) {
+	UseLimiter(NewHostLimiter(Limit{Rate: 1000, Burst: 1000}, nil))
+
+	os.Exit(m.Run())
+}
+
+const fixtureHugeSize = 1 << 20
+
+// newFixtureSite adds the pages a crawler has to cope with to the shared test site:
+// redirects, redirect loops, a flaky page, huge bodies and content without a type
+func newFixtureSite(t *testing.T) *testsite.Site {
+	t.Helper()
+
+	site := testsite.New(t)
+
+	site.Handle("/{$}", func(w http.ResponseWriter, r *http.Request) {
+		testsite.WriteHTML(w, `<html lang="en"><head><title>Home</title><meta name="description" content="The fixture home page"></head>
+			<body><h1>Home</h1><p>Welcome</p><a href="/a">Go to A</a><img src="/logo.png"></body></html>`)
+	})
+
+	// relative links of a redirected page resolve against where it landed
+	site.Handle("/old", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/new/", http.StatusMovedPermanently)
+	})
+	site.Handle("/new/", func(w http.ResponseWriter, r *http.Request) {
+		testsite.WriteHTML(w, `<html><body><h1>Moved</h1><a href="child">Child</a></body></html>`)
+	})
+	site.Handle("/loop", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/loop", http.StatusFound)
+	})
+
+	site.Handle("/missing", func(w http.ResponseWriter, r *http.Request) {
+		http.NotFound(w, r)
+	})
+	// /flaky fails once and works from the second request on
+	site.Handle("/flaky", func(w http.ResponseWriter, r *http.Request) {
+		if site.Hits("/flaky") == 1 {
+			http.Error(w, "try again", http.StatusServiceUnavailable)
+			return
+		}
+		testsite.WriteHTML(w, `<html><body><p>recovered</p></body></html>`)
+	})
+
+	// /huge declares its length, /huge-stream doesn't and has to be cut off while reading
+	site.Handle("/huge", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.Header().Set("Content-Length", fmt.Sprint(fixtureHugeSize))
+		w.Write([]byte(strings.Repeat("x", fixtureHugeSize)))
+	})
+	site.Handle("/huge-stream", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		for i := 0; i < fixtureHugeSize/1024; i++ {
+			w.Write([]byte(strings.Repeat("x", 1024)))
+			w.(http.Flusher).Flush()
+		}
+	})
+
+	site.Handle("/logo.png", func(w http.ResponseWriter, r *http.Request) {
+		// no Content-Type, the crawler has to sniff the body
+		w.Header()["Content-Type"] = nil
+		w.Write([]byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR"))
+	})
+	site.Handle("/untyped", func(w http.ResponseWriter, r *http.Request) {
+		w.Header()["Content-Type"] = nil
+		fmt.Fprint(w, `<!DOCTYPE html><html><body><p>sniffed</p></body></html>`)
+	})
+
+	return site
+}
+
+// testOptions retries fast, so the error fixtures don't slow the suite down
+func testOptions() Options {
+	opts := DefaultOptions()
+	opts.Retry = RetryPolicy{
+		MaxAttempts:     3,
+		BaseDelay:       time.Millisecond,
+		MaxDelay:        5 * time.Millisecond,
+		RetryableStatus: DefaultRetry.RetryableStatus,
+	}
+	opts.MaxBodySize = 64 << 10
+	return opts
+}
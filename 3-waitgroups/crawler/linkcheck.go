@@ -0,0 +1,375 @@
+package crawler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultLinkTimeout  = 10 * time.Second
+	DefaultLinkCacheTTL = 10 * time.Minute
+
+	// maxCachedLinks is when the cache starts dropping expired checks
+	maxCachedLinks = 10000
+)
+
+// Outcome of a link check
+const (
+	LinkOK      = "ok"
+	LinkBroken  = "broken"
+	LinkTimeout = "timeout"
+	LinkError   = "error"
+
+	// robots.txt keeps the crawler away from the target, it was not checked
+	LinkBlocked = StatusBlockedByRobots
+	// the check ended with the job, it says nothing about the target
+	LinkCanceled = StatusCanceled
+)
+
+// DefaultLinkChecker is shared by every link check in the process, so a target linked from many pages is checked once per TTL
+var DefaultLinkChecker = NewLinkChecker(DefaultLinkCacheTTL)
+
+// LinkCheck is the outcome of requesting a link target. Method is the request that gave the answer,
+// Redirects the hops that led from URL to FinalURL.
+type LinkCheck struct {
+	URL        string        `json:"url"`
+	Status     string        `json:"status"`
+	StatusCode int           `json:"status_code,omitempty"`
+	Method     string        `json:"method,omitempty"`
+	Redirects  []Redirect    `json:"redirects,omitempty"`
+	FinalURL   string        `json:"final_url,omitempty"`
+	Error      string        `json:"error,omitempty"`
+	Duration   time.Duration `json:"duration"`
+	CheckedAt  time.Time     `json:"checked_at"`
+}
+
+// Redirect is one hop of a redirect chain, URL answered with StatusCode and pointed on
+type Redirect struct {
+	URL        string `json:"url"`
+	StatusCode int    `json:"status_code"`
+}
+
+// Broken reports whether the target failed, blocked and canceled checks don't count
+func (l LinkCheck) Broken() bool {
+	return l.Status == LinkBroken || l.Status == LinkTimeout || l.Status == LinkError
+}
+
+func (l *LinkCheck) fail(status string, err error) {
+	l.Status = status
+	if err != nil {
+		l.Error = err.Error()
+	}
+}
+
+// PageLink is a link of a page with the check of its target, Type is the element it came from
+type PageLink struct {
+	Type string `json:"type"`
+	Text string `json:"text,omitempty"`
+	LinkCheck
+}
+
+// LinkReport is the broken link report of one page, Status and Error are those of the page's own crawl
+// and Links counts the distinct targets it links to
+type LinkReport struct {
+	URL    string     `json:"url"`
+	Status string     `json:"status"`
+	Error  string     `json:"error,omitempty"`
+	Links  int        `json:"links"`
+	Broken []PageLink `json:"broken"`
+}
+
+// LinkChecker checks link targets with HEAD, falling back to GET, and keeps the checks for TTL
+type LinkChecker struct {
+	TTL time.Duration
+	// Timeout bounds one check, redirects and the GET fallback included
+	Timeout time.Duration
+	// Pool applies its global and per-host limits to the checks, DefaultPool when nil
+	Pool *Pool
+
+	now func() time.Time
+
+	mu      sync.Mutex
+	entries map[string]*linkEntry
+}
+
+type linkEntry struct {
+	ready   chan struct{}
+	check   LinkCheck
+	expires time.Time
+}
+
+func NewLinkChecker(ttl time.Duration) *LinkChecker {
+	return &LinkChecker{
+		TTL:     ttl,
+		Timeout: DefaultLinkTimeout,
+		now:     time.Now,
+		entries: make(map[string]*linkEntry),
+	}
+}
+
+// Report checks the a and img targets of the pages and lists the broken ones per page, in page order
+func (c *LinkChecker) Report(ctx context.Context, pages []PageResult, profile ClientProfile) []LinkReport {
+	var targets []string
+	for _, page := range pages {
+		for _, e := range page.Elements {
+			if isLink(e.ElementType) && e.ResolvedURL != "" {
+				targets = append(targets, e.ResolvedURL)
+			}
+		}
+	}
+	checks := c.Check(ctx, targets, profile)
+
+	reports := make([]LinkReport, len(pages))
+	for i, page := range pages {
+		report := LinkReport{URL: page.URL, Status: page.Status, Error: page.Error, Broken: []PageLink{}}
+
+		// a target linked twice from the page is reported once, with the first element pointing at it
+		seen := make(map[string]bool)
+		for _, e := range page.Elements {
+			if !isLink(e.ElementType) || e.ResolvedURL == "" || seen[e.ResolvedURL] {
+				continue
+			}
+			seen[e.ResolvedURL] = true
+			report.Links++
+
+			if check := checks[e.ResolvedURL]; check.Broken() {
+				report.Broken = append(report.Broken, PageLink{Type: e.ElementType, Text: e.Content, LinkCheck: check})
+			}
+		}
+		reports[i] = report
+	}
+	return reports
+}
+
+func isLink(elementType string) bool {
+	return elementType == "a" || elementType == "img"
+}
+
+// Check checks every url once and returns the checks by URL. The urls missing from the cache are
+// requested in parallel under the pool limits, concurrent callers wait for a check already running.
+// Once ctx is done the urls not checked yet come back as LinkCanceled.
+func (c *LinkChecker) Check(ctx context.Context, urls []string, profile ClientProfile) map[string]LinkCheck {
+	checks := make(map[string]LinkCheck, len(urls))
+
+	client, err := clientFor(profile)
+	if err != nil {
+		for _, url := range urls {
+			check := LinkCheck{URL: url}
+			check.fail(LinkError, err)
+			checks[url] = check
+		}
+		return checks
+	}
+
+	// the profile changes what a target answers, its checks are cached apart
+	raw, _ := json.Marshal(profile)
+	prefix := string(raw) + " "
+
+	entries := make(map[string]*linkEntry)
+	var missing []string
+	for _, url := range urls {
+		if _, ok := entries[url]; ok {
+			continue
+		}
+		entry, owner := c.entry(prefix + url)
+		entries[url] = entry
+		if owner {
+			missing = append(missing, url)
+		}
+	}
+
+	pool := c.Pool
+	if pool == nil {
+		pool = DefaultPool
+	}
+	checked := make([]bool, len(missing))
+	pool.Each(withProfile(ctx, profile), missing, func(ctx context.Context, i int, url string) {
+		c.finish(prefix+url, entries[url], c.check(ctx, client, profile, url))
+		checked[i] = true
+	})
+
+	// the pool drops the urls still queued when ctx ends
+	for i, url := range missing {
+		if !checked[i] {
+			check := LinkCheck{URL: url, CheckedAt: c.now()}
+			check.fail(LinkCanceled, ctx.Err())
+			c.finish(prefix+url, entries[url], check)
+		}
+	}
+
+	for url, entry := range entries {
+		select {
+		case <-entry.ready:
+			checks[url] = entry.check
+		case <-ctx.Done():
+			check := LinkCheck{URL: url, CheckedAt: c.now()}
+			check.fail(LinkCanceled, ctx.Err())
+			checks[url] = check
+		}
+	}
+	return checks
+}
+
+// entry returns the cached check of key, or a new entry the caller has to finish when it is the owner
+func (c *LinkChecker) entry(key string) (*linkEntry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := c.now()
+	if entry, ok := c.entries[key]; ok {
+		select {
+		case <-entry.ready:
+			if now.Before(entry.expires) {
+				return entry, false
+			}
+		default:
+			return entry, false
+		}
+	}
+
+	if len(c.entries) >= maxCachedLinks {
+		for old, entry := range c.entries {
+			select {
+			case <-entry.ready:
+				if !now.Before(entry.expires) {
+					delete(c.entries, old)
+				}
+			default:
+			}
+		}
+	}
+
+	entry := &linkEntry{ready: make(chan struct{})}
+	c.entries[key] = entry
+	return entry, true
+}
+
+// finish hands the check to the callers waiting on the entry, a canceled check isn't kept
+func (c *LinkChecker) finish(key string, entry *linkEntry, check LinkCheck) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry.check = check
+	entry.expires = c.now().Add(c.TTL)
+	if check.Status == LinkCanceled && c.entries[key] == entry {
+		delete(c.entries, key)
+	}
+	close(entry.ready)
+}
+
+// check requests the target with HEAD and, when that doesn't answer with success, with GET:
+// plenty of servers refuse or mishandle HEAD. A target that timed out isn't asked twice.
+func (c *LinkChecker) check(ctx context.Context, client *profileClient, profile ClientProfile, url string) LinkCheck {
+	start := c.now()
+	check := LinkCheck{URL: url}
+
+	// a host whose robots.txt can't be loaded is checked anyway, it is most likely down and the link broken
+	if !client.robots.Allowed(ctx, profile.userAgent(), url) && !client.robots.unreachable(ctx, url) {
+		check.fail(LinkBlocked, ErrBlockedByRobots)
+	} else {
+		timeout := c.Timeout
+		if timeout <= 0 {
+			timeout = DefaultLinkTimeout
+		}
+		checkCtx, cancel := context.WithTimeout(ctx, timeout)
+		defer cancel()
+
+		check = follow(ctx, checkCtx, client, profile, http.MethodHead, url)
+		if check.Status == LinkBroken || check.Status == LinkError {
+			check = follow(ctx, checkCtx, client, profile, http.MethodGet, url)
+		}
+	}
+
+	check.CheckedAt = c.now()
+	check.Duration = check.CheckedAt.Sub(start)
+	linkChecks.With(check.Status).Inc()
+	return check
+}
+
+// follow requests url with method and follows the redirects itself, so it can record every hop.
+// The profile's redirect policy applies, a negative limit makes the redirect the answer.
+func follow(ctx, checkCtx context.Context, client *profileClient, profile ClientProfile, method, url string) LinkCheck {
+	check := LinkCheck{URL: url, Method: method}
+
+	hops := profile.MaxRedirects
+	if hops == 0 {
+		hops = DefaultMaxRedirects
+	}
+	noFollow := &http.Client{
+		Transport: client.Transport,
+		Jar:       client.Jar,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	target := url
+	for {
+		request, err := http.NewRequestWithContext(checkCtx, method, target, nil)
+		if err != nil {
+			check.fail(LinkError, err)
+			return check
+		}
+		profile.setHeaders(request)
+
+		response, err := noFollow.Do(request)
+		if err != nil {
+			check.fail(linkFailure(ctx, err), err)
+			return check
+		}
+		// only the status matters, the body of a GET is never read
+		response.Body.Close()
+		check.StatusCode = response.StatusCode
+
+		location := response.Header.Get("Location")
+		if response.StatusCode < 300 || response.StatusCode > 399 || location == "" || hops < 0 {
+			break
+		}
+		if len(check.Redirects) >= hops {
+			check.fail(LinkError, fmt.Errorf("%w: stopped after %d redirects", ErrRedirectPolicy, hops))
+			return check
+		}
+
+		next, err := response.Request.URL.Parse(location)
+		if err != nil {
+			check.fail(LinkError, fmt.Errorf("invalid redirect location %q: %w", location, err))
+			return check
+		}
+		if profile.SameHostRedirects && !strings.EqualFold(next.Host, request.URL.Host) {
+			check.fail(LinkError, fmt.Errorf("%w: redirect to another host %s", ErrRedirectPolicy, next.Host))
+			return check
+		}
+		check.Redirects = append(check.Redirects, Redirect{URL: target, StatusCode: response.StatusCode})
+		target = next.String()
+	}
+
+	if target != url {
+		check.FinalURL = target
+	}
+	if check.StatusCode >= 400 {
+		check.fail(LinkBroken, fmt.Errorf("unexpected HTTP status: %d %s", check.StatusCode, http.StatusText(check.StatusCode)))
+		return check
+	}
+	check.Status = LinkOK
+	return check
+}
+
+// linkFailure tells a target that didn't answer in time from one that failed, and both from a job that ended
+func linkFailure(ctx context.Context, err error) string {
+	if ctx.Err() != nil {
+		return LinkCanceled
+	}
+
+	var netErr net.Error
+	if errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout()) {
+		return LinkTimeout
+	}
+	return LinkError
+}
@@ -0,0 +1,219 @@
+package crawler
+
+import (
+	"context"
+	"exercise3/internal/testsite"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// linkSite serves /page, whose links and image cover what a link check runs into, and counts the requests per path
+type linkSite struct {
+	*httptest.Server
+
+	mu   sync.Mutex
+	hits map[string]int
+}
+
+func newLinkSite(t *testing.T) *linkSite {
+	t.Helper()
+
+	site := &linkSite{hits: make(map[string]int)}
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/page", func(w http.ResponseWriter, r *http.Request) {
+		testsite.WriteHTML(w, `<html><body>
+			<a href="/ok">Fine</a><a href="/missing">Gone</a><a href="/missing#again">Gone again</a>
+			<a href="/moved">Moved</a><a href="/moved-away">Moved away</a><a href="/no-head">No HEAD</a>
+			<a href="/slow">Slow</a><a href="/private">Private</a><a href="http://127.0.0.1:1/">Refused</a>
+			<img src="/logo.png"></body></html>`)
+	})
+	mux.HandleFunc("/robots.txt", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("User-agent: *\nDisallow: /private\n"))
+	})
+	mux.HandleFunc("/ok", func(w http.ResponseWriter, r *http.Request) {
+		site.hit(r)
+	})
+	mux.HandleFunc("/moved", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/moved-again", http.StatusMovedPermanently)
+	})
+	mux.HandleFunc("/moved-again", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/ok", http.StatusFound)
+	})
+	mux.HandleFunc("/moved-away", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/missing", http.StatusMovedPermanently)
+	})
+	mux.HandleFunc("/no-head", func(w http.ResponseWriter, r *http.Request) {
+		site.hit(r)
+		if r.Method == http.MethodHead {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+	})
+	mux.HandleFunc("/slow", func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-time.After(2 * time.Second):
+		case <-r.Context().Done():
+		}
+	})
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		site.hit(r)
+		http.NotFound(w, r)
+	})
+
+	site.Server = httptest.NewServer(mux)
+	t.Cleanup(site.Close)
+	return site
+}
+
+func (s *linkSite) hit(r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.hits[r.Method+" "+r.URL.Path]++
+}
+
+func (s *linkSite) count(request string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.hits[request]
+}
+
+func TestLinkCheckerReport(t *testing.T) {
+	site := newLinkSite(t)
+	page, err := Fetch(context.Background(), site.URL+"/page", testOptions())
+	if err != nil {
+		t.Fatalf("Fetch: %v", err)
+	}
+
+	checker := NewLinkChecker(time.Minute)
+	checker.Timeout = 200 * time.Millisecond
+	reports := checker.Report(context.Background(), []PageResult{page}, ClientProfile{})
+
+	report := reports[0]
+	if report.URL != site.URL+"/page" || report.Status != StatusSuccess || report.Links != 9 {
+		t.Errorf("report = %+v, want 9 distinct links", report)
+	}
+
+	broken := make(map[string]PageLink)
+	for _, link := range report.Broken {
+		broken[link.URL] = link
+	}
+	want := map[string]string{
+		site.URL + "/missing":    LinkBroken,
+		site.URL + "/moved-away": LinkBroken,
+		site.URL + "/logo.png":   LinkBroken,
+		site.URL + "/slow":       LinkTimeout,
+		"http://127.0.0.1:1/":    LinkError,
+	}
+	if len(broken) != len(want) {
+		t.Errorf("broken links = %+v", report.Broken)
+	}
+	for url, status := range want {
+		if broken[url].Status != status {
+			t.Errorf("%s: %+v, want %s", url, broken[url], status)
+		}
+	}
+
+	missing := broken[site.URL+"/missing"]
+	if missing.Type != "a" || missing.Text != "Gone" || missing.StatusCode != http.StatusNotFound || missing.Method != http.MethodGet {
+		t.Errorf("/missing = %+v", missing)
+	}
+	if logo := broken[site.URL+"/logo.png"]; logo.Type != "img" {
+		t.Errorf("/logo.png came from %q", logo.Type)
+	}
+	away := broken[site.URL+"/moved-away"]
+	if len(away.Redirects) != 1 || away.Redirects[0].StatusCode != http.StatusMovedPermanently || away.FinalURL != site.URL+"/missing" {
+		t.Errorf("/moved-away = %+v", away)
+	}
+
+	// robots.txt keeps the checker away from /private, it isn't broken either
+	if site.count("HEAD /private") != 0 || site.count("GET /private") != 0 {
+		t.Errorf("/private was requested")
+	}
+
+	// HEAD was refused, GET had the final word
+	if site.count("HEAD /no-head") != 1 || site.count("GET /no-head") != 1 {
+		t.Errorf("/no-head got %d HEAD and %d GET", site.count("HEAD /no-head"), site.count("GET /no-head"))
+	}
+}
+
+func TestLinkCheckerRedirectChain(t *testing.T) {
+	site := newLinkSite(t)
+	checker := NewLinkChecker(time.Minute)
+
+	check := checker.Check(context.Background(), []string{site.URL + "/moved"}, ClientProfile{})[site.URL+"/moved"]
+	if check.Status != LinkOK || check.Method != http.MethodHead || check.StatusCode != http.StatusOK ||
+		check.FinalURL != site.URL+"/ok" || len(check.Redirects) != 2 ||
+		check.Redirects[0] != (Redirect{URL: site.URL + "/moved", StatusCode: http.StatusMovedPermanently}) ||
+		check.Redirects[1] != (Redirect{URL: site.URL + "/moved-again", StatusCode: http.StatusFound}) {
+		t.Errorf("check = %+v", check)
+	}
+
+	// the profile's redirect limit applies to the checks too
+	limited := checker.Check(context.Background(), []string{site.URL + "/moved"}, ClientProfile{MaxRedirects: 1})[site.URL+"/moved"]
+	if limited.Status != LinkError || len(limited.Redirects) != 1 {
+		t.Errorf("with one redirect allowed: %+v", limited)
+	}
+
+	// and so does keeping to the host
+	away := httptest.NewServer(http.RedirectHandler(site.URL+"/ok", http.StatusFound))
+	t.Cleanup(away.Close)
+
+	profile := ClientProfile{SameHostRedirects: true}
+	checks := checker.Check(context.Background(), []string{site.URL + "/moved", away.URL + "/link"}, profile)
+	if check := checks[site.URL+"/moved"]; check.Status != LinkOK || len(check.Redirects) != 2 {
+		t.Errorf("redirects within the host: %+v", check)
+	}
+	if check := checks[away.URL+"/link"]; check.Status != LinkError || !strings.Contains(check.Error, ErrRedirectPolicy.Error()) {
+		t.Errorf("redirect to another host: %+v", check)
+	}
+}
+
+func TestLinkCheckerCache(t *testing.T) {
+	site := newLinkSite(t)
+	checker := NewLinkChecker(time.Minute)
+	now := time.Now()
+	checker.now = func() time.Time { return now }
+
+	urls := []string{site.URL + "/ok", site.URL + "/ok"}
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if check := checker.Check(context.Background(), urls, ClientProfile{})[site.URL+"/ok"]; check.Status != LinkOK {
+				t.Errorf("check = %+v", check)
+			}
+		}()
+	}
+	wg.Wait()
+	if hits := site.count("HEAD /ok"); hits != 1 {
+		t.Errorf("concurrent checks sent %d requests, want 1", hits)
+	}
+
+	now = now.Add(2 * time.Minute)
+	checker.Check(context.Background(), urls, ClientProfile{})
+	if hits := site.count("HEAD /ok"); hits != 2 {
+		t.Errorf("an expired check wasn't repeated, %d requests", hits)
+	}
+}
+
+func TestLinkCheckerCanceled(t *testing.T) {
+	site := newLinkSite(t)
+	checker := NewLinkChecker(time.Minute)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	check := checker.Check(ctx, []string{site.URL + "/ok"}, ClientProfile{})[site.URL+"/ok"]
+	if check.Status != LinkCanceled || check.Broken() {
+		t.Errorf("check = %+v", check)
+	}
+
+	// a canceled check isn't cached
+	if check := checker.Check(context.Background(), []string{site.URL + "/ok"}, ClientProfile{})[site.URL+"/ok"]; check.Status != LinkOK {
+		t.Errorf("after cancellation: %+v", check)
+	}
+}
@@ -0,0 +1,66 @@
+package crawler
+
+import (
+	"encoding/json"
+	"exercise3/models"
+	"github.com/PuerkitoBio/goquery"
+	"net/url"
+	"strings"
+)
+
+// ExtractMetadata reads the page-level metadata from the head of the document,
+// the canonical link is resolved against base
+func ExtractMetadata(doc *goquery.Document, base *url.URL) models.PageMeta {
+	meta := models.PageMeta{
+		Title:    strings.TrimSpace(doc.Find("title").First().Text()),
+		Language: strings.TrimSpace(doc.Find("html").First().AttrOr("lang", "")),
+	}
+
+	if canonical, ok := doc.Find(`link[rel~="canonical"]`).First().Attr("href"); ok {
+		if resolved, ok := ResolveURL(base, canonical); ok {
+			meta.Canonical = resolved
+		} else {
+			meta.Canonical = strings.TrimSpace(canonical)
+		}
+	}
+
+	doc.Find("meta").Each(func(_ int, s *goquery.Selection) {
+		content, ok := s.Attr("content")
+		if !ok {
+			return
+		}
+		content = strings.TrimSpace(content)
+
+		// OpenGraph uses property, Twitter cards use name, but sites mix them up
+		key := strings.ToLower(s.AttrOr("property", s.AttrOr("name", "")))
+
+		switch {
+		case key == "description":
+			meta.Description = content
+		case key == "keywords":
+			meta.Keywords = content
+		case strings.HasPrefix(key, "og:"):
+			if meta.OpenGraph == nil {
+				meta.OpenGraph = models.JSONMap{}
+			}
+			meta.OpenGraph[key] = content
+		case strings.HasPrefix(key, "twitter:"):
+			if meta.Twitter == nil {
+				meta.Twitter = models.JSONMap{}
+			}
+			meta.Twitter[key] = content
+		case strings.EqualFold(s.AttrOr("http-equiv", ""), "content-language") && meta.Language == "":
+			meta.Language = content
+		}
+	})
+
+	// blocks that aren't valid JSON are dropped, storing them would break the jsonb column
+	doc.Find(`script[type="application/ld+json"]`).Each(func(_ int, s *goquery.Selection) {
+		block := strings.TrimSpace(s.Text())
+		if json.Valid([]byte(block)) {
+			meta.JSONLD = append(meta.JSONLD, json.RawMessage(block))
+		}
+	})
+
+	return meta
+}
@@ -0,0 +1,96 @@
+package crawler
+
+import (
+	"exercise3/models"
+	"github.com/PuerkitoBio/goquery"
+	neturl "net/url"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestExtractMetadata(t *testing.T) {
+	base, _ := neturl.Parse("https://example.com/blog/post?id=1")
+
+	tests := []struct {
+		name string
+		html string
+		want models.PageMeta
+	}{
+		{
+			name: "every kind",
+			html: `<html lang=" en-GB "><head>
+<title> A post </title><title>Second title</title>
+<meta name="description" content=" About crawling ">
+<meta name="keywords" content="crawler, robots">
+<link rel="alternate canonical" href="../post">
+<meta property="og:title" content="A post">
+<meta property="OG:Image" content="https://example.com/cover.png">
+<meta name="twitter:card" content="summary">
+<script type="application/ld+json">{"@type": "Article", "headline": "A post"}</script>
+</head><body><p>Hi</p></body></html>`,
+			want: models.PageMeta{
+				Title:       "A post",
+				Description: "About crawling",
+				Keywords:    "crawler, robots",
+				Canonical:   "https://example.com/post",
+				Language:    "en-GB",
+				OpenGraph:   models.JSONMap{"og:title": "A post", "og:image": "https://example.com/cover.png"},
+				Twitter:     models.JSONMap{"twitter:card": "summary"},
+				JSONLD:      models.JSONList{[]byte(`{"@type": "Article", "headline": "A post"}`)},
+			},
+		},
+		{
+			// sites mix up name and property, the key decides where a tag goes
+			name: "mixed up attributes",
+			html: `<html><head>
+<meta name="og:type" content="article">
+<meta property="twitter:site" content="@example">
+<meta name="robots">
+</head></html>`,
+			want: models.PageMeta{
+				OpenGraph: models.JSONMap{"og:type": "article"},
+				Twitter:   models.JSONMap{"twitter:site": "@example"},
+			},
+		},
+		{
+			name: "content-language header",
+			html: `<html><head><meta http-equiv="Content-Language" content="de"></head></html>`,
+			want: models.PageMeta{Language: "de"},
+		},
+		{
+			name: "lang attribute wins",
+			html: `<html lang="fr"><head><meta http-equiv="content-language" content="de"></head></html>`,
+			want: models.PageMeta{Language: "fr"},
+		},
+		{
+			name: "canonical that isn't crawlable",
+			html: `<html><head><link rel="canonical" href=" mailto:editor@example.com "></head></html>`,
+			want: models.PageMeta{Canonical: "mailto:editor@example.com"},
+		},
+		{
+			name: "broken json-ld",
+			html: `<html><head>
+<script type="application/ld+json">{"@type": "Article",}</script>
+<script type="application/ld+json">[{"@type": "Person"}]</script>
+<script type="application/json">{"ignored": true}</script>
+</head></html>`,
+			want: models.PageMeta{JSONLD: models.JSONList{[]byte(`[{"@type": "Person"}]`)}},
+		},
+		{
+			name: "no metadata",
+			html: `<p>just text</p>`,
+			want: models.PageMeta{},
+		},
+	}
+
+	for _, tt := range tests {
+		doc, err := goquery.NewDocumentFromReader(strings.NewReader(tt.html))
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if got := ExtractMetadata(doc, base); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s:\ngot  %+v\nwant %+v", tt.name, got, tt.want)
+		}
+	}
+}
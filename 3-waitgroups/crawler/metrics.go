@@ -0,0 +1,17 @@
+package crawler
+
+import "exercise3/metrics"
+
+// metrics of the crawler, served on /metrics
+var (
+	pagesFetched    = metrics.NewCounter("crawler_pages_fetched_total", "Pages downloaded, including 304 answers to conditional fetches.")
+	pagesFailed     = metrics.NewCounterVec("crawler_pages_failed_total", "Pages that could not be downloaded or parsed, by status.", "status")
+	bytesDownloaded = metrics.NewCounter("crawler_bytes_downloaded_total", "Response body bytes read, retries included.")
+	fetchDuration   = metrics.NewHistogram("crawler_fetch_duration_seconds", "Duration of single fetch attempts until the body is read.", nil)
+	fetchesInFlight = metrics.NewGauge("crawler_fetches_in_flight", "HTTP requests currently running.")
+	linkChecks      = metrics.NewCounterVec("crawler_link_checks_total", "Link targets checked, cached checks excluded, by outcome.", "status")
+
+	_ = metrics.NewGaugeFunc("crawler_queue_depth", "URLs waiting for a slot in the shared pool.", func() float64 {
+		return float64(DefaultPool.queued.Load())
+	})
+)
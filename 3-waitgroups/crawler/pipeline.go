@@ -0,0 +1,160 @@
+package crawler
+
+import (
+	"context"
+	"log"
+	"runtime"
+	"sync"
+	"time"
+)
+
+// Persister stores a batch of finished pages. It fills PageID and Version,
+// and marks the pages it couldn't store with their own status and error.
+type Persister func(ctx context.Context, batch []*PageResult)
+
+// Pipeline runs a crawl as three stages connected by bounded channels:
+// fetch, parse and persist. When the database is slow the persist stage stops
+// reading, the channels fill up and the fetchers block, so work never piles up in memory.
+type Pipeline struct {
+	// Fetchers is the number of fetch goroutines of a job, the shared pool limits still apply
+	Fetchers int
+	Parsers  int
+	Writers  int
+
+	// BatchSize pages are written in one transaction, a partial batch waits at most FlushInterval
+	BatchSize     int
+	FlushInterval time.Duration
+}
+
+// DefaultPipeline is the stage sizing used by crawl requests
+var DefaultPipeline = Pipeline{
+	Fetchers:      DefaultWorkers,
+	Parsers:       runtime.NumCPU(),
+	Writers:       1,
+	BatchSize:     50,
+	FlushInterval: time.Second,
+}
+
+// stageItem carries a page and its index in the job's url list between the stages
+type stageItem struct {
+	index int
+	page  downloaded
+}
+
+// Run crawls urls through the stages and returns the results in url order once every page is persisted.
+// onDone, when set, is called for each page right after its batch is written.
+func (p Pipeline) Run(ctx context.Context, urls []string, opts Options, persist Persister, onDone func(PageResult)) []PageResult {
+	results := make([]PageResult, len(urls))
+	buffer := max(p.BatchSize, 1)
+
+	parseQueue := make(chan stageItem, buffer)
+	persistQueue := make(chan stageItem, buffer)
+
+	// fetch: the goroutine keeps its pool slot while the parse queue is full, that is the backpressure
+	go func() {
+		defer close(parseQueue)
+
+		DefaultPool.EachN(withProfile(ctx, opts.Client), urls, p.Fetchers, func(ctx context.Context, i int, url string) {
+			page, err := download(ctx, url, opts)
+			if err != nil {
+				log.Println("Error fetching URL:", url, err)
+			}
+			parseQueue <- stageItem{index: i, page: page}
+		})
+	}()
+
+	// parse: only successful downloads have a body, failures pass through to be recorded
+	var parsers sync.WaitGroup
+	for w := 0; w < max(p.Parsers, 1); w++ {
+		parsers.Add(1)
+		go func() {
+			defer parsers.Done()
+			for item := range parseQueue {
+				if item.page.body != nil {
+					if err := item.page.parse(opts.Extraction); err != nil {
+						log.Println("Error parsing URL:", item.page.result.URL, err)
+					}
+				}
+				persistQueue <- item
+			}
+		}()
+	}
+	go func() {
+		parsers.Wait()
+		close(persistQueue)
+	}()
+
+	// persist: each writer batches on its own, results are written back by index
+	var writers sync.WaitGroup
+	for w := 0; w < max(p.Writers, 1); w++ {
+		writers.Add(1)
+		go func() {
+			defer writers.Done()
+			p.batch(persistQueue, func(items []stageItem) {
+				batch := make([]*PageResult, len(items))
+				for i := range items {
+					batch[i] = &items[i].page.result
+				}
+				persist(ctx, batch)
+
+				for _, item := range items {
+					results[item.index] = item.page.result
+					if onDone != nil {
+						onDone(item.page.result)
+					}
+				}
+			})
+		}()
+	}
+	writers.Wait()
+
+	// urls still queued when the context ended were never fetched
+	for i, url := range urls {
+		if results[i].Status == "" {
+			results[i] = PageResult{URL: url}
+			results[i].fail(StatusCanceled, ctx.Err())
+			if onDone != nil {
+				onDone(results[i])
+			}
+		}
+	}
+
+	return results
+}
+
+// batch groups the queue into batches of BatchSize, flushing early when the queue goes quiet
+func (p Pipeline) batch(queue <-chan stageItem, flush func([]stageItem)) {
+	size := max(p.BatchSize, 1)
+	interval := p.FlushInterval
+	if interval <= 0 {
+		interval = time.Second
+	}
+
+	items := make([]stageItem, 0, size)
+	timer := time.NewTimer(interval)
+	defer timer.Stop()
+
+	for {
+		select {
+		case item, ok := <-queue:
+			if !ok {
+				if len(items) > 0 {
+					flush(items)
+				}
+				return
+			}
+
+			items = append(items, item)
+			if len(items) >= size {
+				flush(items)
+				items = make([]stageItem, 0, size)
+			}
+		case <-timer.C:
+			if len(items) > 0 {
+				flush(items)
+				items = make([]stageItem, 0, size)
+			}
+			timer.Reset(interval)
+		}
+	}
+}
@@ -0,0 +1,46 @@
+package crawler
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestPipelineBackpressure(t *testing.T) {
+	site := newFixtureSite(t)
+
+	urls := make([]string, 30)
+	for i := range urls {
+		urls[i] = fmt.Sprintf("%s/a?page=%d", site.URL, i)
+	}
+
+	// the writer is stuck until release is closed, like a database that stopped answering
+	release := make(chan struct{})
+	persist := func(ctx context.Context, batch []*PageResult) {
+		<-release
+	}
+
+	pipeline := Pipeline{Fetchers: 1, Parsers: 1, Writers: 1, BatchSize: 1, FlushInterval: time.Millisecond}
+	done := make(chan []PageResult)
+	go func() {
+		done <- pipeline.Run(context.Background(), urls, testOptions(), persist, nil)
+	}()
+
+	// a page in every stage and in every channel, the fetchers block after that
+	time.Sleep(300 * time.Millisecond)
+	if hits := site.Hits("/a"); hits == 0 || hits > 8 {
+		t.Errorf("%d pages fetched while the writer is blocked, want a handful", hits)
+	}
+
+	close(release)
+	results := <-done
+	if hits := site.Hits("/a"); hits != len(urls) {
+		t.Errorf("%d pages fetched, want %d", hits, len(urls))
+	}
+	for i, r := range results {
+		if r.URL != urls[i] || r.Status != StatusSuccess {
+			t.Errorf("result %d = %s %q, want %s succeeded", i, r.URL, r.Status, urls[i])
+		}
+	}
+}
@@ -0,0 +1,297 @@
+package crawler
+
+import (
+	"context"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	DefaultWorkers = 32
+	DefaultPerHost = 4
+)
+
+// DefaultPool is shared by every crawl job in the process so the limits hold globally
+var DefaultPool = NewPool(DefaultWorkers, DefaultPerHost)
+
+func init() {
+	DefaultPool.Limiter = DefaultLimiter
+}
+
+// Pool bounds how many fetches run at once, globally and per host
+type Pool struct {
+	slots   chan struct{}
+	perHost int
+
+	// Limiter, when set, paces requests to each host before they take a global slot
+	Limiter *HostLimiter
+
+	mu    sync.Mutex
+	hosts map[string]*hostSlots
+
+	queued    atomic.Int64
+	active    atomic.Int64
+	completed atomic.Int64
+}
+
+// hostSlots is the semaphore of a single host, refs counts goroutines holding or waiting on it
+type hostSlots struct {
+	sem  chan struct{}
+	refs int
+}
+
+// PoolStats is a snapshot of the pool's queueing metrics
+type PoolStats struct {
+	Workers   int            `json:"workers"`
+	PerHost   int            `json:"per_host"`
+	Queued    int64          `json:"queued"`
+	Active    int64          `json:"active"`
+	Completed int64          `json:"completed"`
+	Hosts     map[string]int `json:"hosts"`
+}
+
+func NewPool(workers, perHost int) *Pool {
+	if workers < 1 {
+		workers = 1
+	}
+	if perHost < 1 {
+		perHost = 1
+	}
+
+	return &Pool{
+		slots:   make(chan struct{}, workers),
+		perHost: perHost,
+		hosts:   make(map[string]*hostSlots),
+	}
+}
+
+func (p *Pool) Size() int {
+	return cap(p.slots)
+}
+
+// Each calls fn for every url and returns when all of them are done.
+// A job never starts more goroutines than the pool size, whatever the url count.
+// Once ctx is done the urls still waiting are dropped without calling fn.
+// The ctx fn gets carries the url's slots, retries hand them back while they wait.
+func (p *Pool) Each(ctx context.Context, urls []string, fn func(ctx context.Context, i int, url string)) {
+	p.EachN(ctx, urls, p.Size(), fn)
+}
+
+// EachN is Each with at most workers goroutines, the global and per-host limits still apply
+func (p *Pool) EachN(ctx context.Context, urls []string, workers int, fn func(ctx context.Context, i int, url string)) {
+	queue := newHostQueue(urls)
+	workers = min(len(urls), p.Size(), max(workers, 1))
+
+	p.queued.Add(int64(len(urls)))
+
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				i, ok := queue.pop()
+				if !ok {
+					return
+				}
+
+				s, err := p.acquire(ctx, urls[i])
+				if err != nil {
+					continue
+				}
+				fn(context.WithValue(ctx, slotKey{}, s), i, urls[i])
+				s.release()
+			}
+		}()
+	}
+
+	wg.Wait()
+}
+
+// slot is what a url holds while fn runs: a slot of its host and a global one
+type slot struct {
+	pool *Pool
+	url  string
+	host string
+	h    *hostSlots
+	held bool
+}
+
+type slotKey struct{}
+
+func (p *Pool) acquire(ctx context.Context, rawURL string) (*slot, error) {
+	host := hostOf(rawURL)
+	s := &slot{pool: p, url: rawURL, host: host, h: p.host(host)}
+	defer p.queued.Add(-1)
+
+	if err := s.take(ctx); err != nil {
+		p.unref(host)
+		return nil, err
+	}
+	return s, nil
+}
+
+// take gets the host slot and the host's rate limit before the global slot,
+// so a busy or slow host never holds global slots while waiting
+func (s *slot) take(ctx context.Context) error {
+	select {
+	case s.h.sem <- struct{}{}:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	err := ctx.Err()
+	if s.pool.Limiter != nil {
+		err = s.pool.Limiter.Wait(ctx, s.url)
+	}
+	if err == nil {
+		select {
+		case s.pool.slots <- struct{}{}:
+		case <-ctx.Done():
+			err = ctx.Err()
+		}
+	}
+	if err != nil {
+		<-s.h.sem
+		return err
+	}
+
+	s.held = true
+	s.pool.active.Add(1)
+	return nil
+}
+
+// put hands both slots back, the host stays referenced until release
+func (s *slot) put() {
+	if !s.held {
+		return
+	}
+	s.held = false
+	s.pool.active.Add(-1)
+
+	<-s.pool.slots
+	<-s.h.sem
+}
+
+func (s *slot) release() {
+	s.put()
+	s.pool.completed.Add(1)
+	s.pool.unref(s.host)
+}
+
+// retryWait sleeps d before another attempt at a url. Inside a pool the url's slots go to other urls meanwhile,
+// taking them back waits for the host's rate limit again, so retries keep to it like first attempts.
+func retryWait(ctx context.Context, d time.Duration) error {
+	s, ok := ctx.Value(slotKey{}).(*slot)
+	if !ok {
+		return sleep(ctx, d)
+	}
+
+	s.put()
+	if err := sleep(ctx, d); err != nil {
+		return err
+	}
+	return s.take(ctx)
+}
+
+func (p *Pool) host(host string) *hostSlots {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	h, ok := p.hosts[host]
+	if !ok {
+		h = &hostSlots{sem: make(chan struct{}, p.perHost)}
+		p.hosts[host] = h
+	}
+	h.refs++
+
+	return h
+}
+
+// unref drops idle hosts so the map doesn't grow with every domain ever crawled
+func (p *Pool) unref(host string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	h := p.hosts[host]
+	h.refs--
+	if h.refs == 0 {
+		delete(p.hosts, host)
+	}
+}
+
+func (p *Pool) Stats() PoolStats {
+	p.mu.Lock()
+	hosts := make(map[string]int, len(p.hosts))
+	for name, h := range p.hosts {
+		hosts[name] = len(h.sem)
+	}
+	p.mu.Unlock()
+
+	return PoolStats{
+		Workers:   p.Size(),
+		PerHost:   p.perHost,
+		Queued:    p.queued.Load(),
+		Active:    p.active.Load(),
+		Completed: p.completed.Load(),
+		Hosts:     hosts,
+	}
+}
+
+// hostQueue hands out url indexes round-robin across hosts, so a job's workers
+// keep serving other hosts while one of them is rate limited
+type hostQueue struct {
+	mu     sync.Mutex
+	hosts  [][]int
+	cursor int
+}
+
+func newHostQueue(urls []string) *hostQueue {
+	q := &hostQueue{}
+	index := make(map[string]int)
+
+	for i, u := range urls {
+		host := hostOf(u)
+		n, ok := index[host]
+		if !ok {
+			n = len(q.hosts)
+			index[host] = n
+			q.hosts = append(q.hosts, nil)
+		}
+		q.hosts[n] = append(q.hosts[n], i)
+	}
+	return q
+}
+
+func (q *hostQueue) pop() (int, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if len(q.hosts) == 0 {
+		return 0, false
+	}
+	if q.cursor >= len(q.hosts) {
+		q.cursor = 0
+	}
+
+	pending := q.hosts[q.cursor]
+	i := pending[0]
+	if len(pending) == 1 {
+		q.hosts = append(q.hosts[:q.cursor], q.hosts[q.cursor+1:]...)
+	} else {
+		q.hosts[q.cursor] = pending[1:]
+		q.cursor++
+	}
+	return i, true
+}
+
+func hostOf(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Host == "" {
+		return rawURL
+	}
+	return strings.ToLower(u.Host)
+}
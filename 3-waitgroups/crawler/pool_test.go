@@ -0,0 +1,96 @@
+package crawler
+
+import (
+	"context"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestHostQueueRoundRobin(t *testing.T) {
+	tests := []struct {
+		name string
+		urls []string
+		want []int
+	}{
+		{"empty", nil, nil},
+		{"one host keeps its order", []string{"http://a/1", "http://a/2", "http://a/3"}, []int{0, 1, 2}},
+		{"hosts take turns", []string{"http://a/1", "http://a/2", "http://a/3", "http://b/1", "http://b/2", "http://c/1"}, []int{0, 3, 5, 1, 4, 2}},
+		{"host names ignore case", []string{"http://A/1", "http://b/1", "http://a/2"}, []int{0, 1, 2}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			queue := newHostQueue(tt.urls)
+			var got []int
+			for {
+				i, ok := queue.pop()
+				if !ok {
+					break
+				}
+				got = append(got, i)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("order = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPoolLimits(t *testing.T) {
+	pool := NewPool(3, 2)
+
+	var urls []string
+	for i := 0; i < 8; i++ {
+		urls = append(urls, "http://busy.test/", "http://quiet.test/")
+	}
+
+	var mu sync.Mutex
+	running := make(map[string]int)
+	peakHost := make(map[string]int)
+	peak, total := 0, 0
+	pool.Each(context.Background(), urls, func(ctx context.Context, i int, url string) {
+		host := hostOf(url)
+		mu.Lock()
+		running[host]++
+		total++
+		peakHost[host] = max(peakHost[host], running[host])
+		peak = max(peak, running["busy.test"]+running["quiet.test"])
+		mu.Unlock()
+
+		time.Sleep(5 * time.Millisecond)
+
+		mu.Lock()
+		running[host]--
+		mu.Unlock()
+	})
+
+	if total != len(urls) {
+		t.Errorf("%d of %d urls done", total, len(urls))
+	}
+	if peak > 3 || peakHost["busy.test"] > 2 || peakHost["quiet.test"] > 2 {
+		t.Errorf("%d fetches at once, per host %v, want at most 3 and 2", peak, peakHost)
+	}
+	// a busy host leaves the other host room to run
+	if peakHost["quiet.test"] < 1 || peak < 2 {
+		t.Errorf("peak %d, per host %v", peak, peakHost)
+	}
+	if stats := pool.Stats(); stats.Active != 0 || stats.Completed != int64(len(urls)) || len(stats.Hosts) != 0 {
+		t.Errorf("stats after the run = %+v", stats)
+	}
+}
+
+func TestPoolCanceled(t *testing.T) {
+	pool := NewPool(1, 1)
+	ctx, cancel := context.WithCancel(context.Background())
+
+	called := 0
+	pool.Each(ctx, []string{"http://a/1", "http://a/2", "http://a/3"}, func(ctx context.Context, i int, url string) {
+		called++
+		cancel()
+	})
+	if called != 1 {
+		t.Errorf("fn was called %d times, the urls after the cancellation should be dropped", called)
+	}
+}
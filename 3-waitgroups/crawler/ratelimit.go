@@ -0,0 +1,224 @@
+package crawler
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultRate  = 2.0
+	DefaultBurst = 2
+
+	// bucketSweepInterval is how often new hosts trigger dropping the buckets that filled up again
+	bucketSweepInterval = time.Minute
+)
+
+// DefaultLimiter is shared by every crawl job in the process, so two jobs hitting the same host split its budget
+var DefaultLimiter = NewHostLimiter(Limit{Rate: DefaultRate, Burst: DefaultBurst}, nil)
+
+func init() {
+	DefaultLimiter.Robots = DefaultRobots
+}
+
+// Limit is a token bucket setting, Rate is requests per second
+type Limit struct {
+	Rate  float64
+	Burst int
+}
+
+// HostLimiter keeps one token bucket per host
+type HostLimiter struct {
+	defaults  Limit
+	overrides map[string]Limit
+
+	// Robots, when set, slows a host down to its robots.txt Crawl-delay.
+	// A crawl with a client profile of its own reads it through the profile, see withProfile.
+	Robots *RobotsCache
+
+	mu      sync.Mutex
+	buckets map[string]*bucket
+	swept   time.Time
+}
+
+type bucket struct {
+	mu     sync.Mutex
+	limit  Limit
+	tokens float64
+	last   time.Time
+
+	// dropped is set once the bucket left the map, its callers take a token from the new one
+	dropped bool
+}
+
+func NewHostLimiter(defaults Limit, overrides map[string]Limit) *HostLimiter {
+	return &HostLimiter{
+		defaults:  defaults,
+		overrides: overrides,
+		buckets:   make(map[string]*bucket),
+	}
+}
+
+// UseLimiter makes l the limiter of DefaultPool and DefaultLimiter, slowed down to the Crawl-delays of DefaultRobots
+func UseLimiter(l *HostLimiter) {
+	l.Robots = DefaultRobots
+	DefaultLimiter = l
+	DefaultPool.Limiter = l
+}
+
+// profileKey carries the robots.txt cache and user agent of a crawl's client profile
+type profileKey struct{}
+
+type profileRobots struct {
+	robots    *RobotsCache
+	userAgent string
+}
+
+// withProfile makes the limiter read Crawl-delays the way the profile sees robots.txt:
+// fetched through its client, a proxied crawl never asks the host directly, and for its user agent
+func withProfile(ctx context.Context, profile ClientProfile) context.Context {
+	client, err := clientFor(profile)
+	if err != nil {
+		return ctx
+	}
+	return context.WithValue(ctx, profileKey{}, profileRobots{robots: client.robots, userAgent: profile.userAgent()})
+}
+
+// Wait blocks until a request to the url's host is allowed or ctx is done
+func (l *HostLimiter) Wait(ctx context.Context, rawURL string) error {
+	if d := l.reserve(ctx, rawURL); d > 0 {
+		return sleep(ctx, d)
+	}
+	return ctx.Err()
+}
+
+// reserve takes a token from the host's bucket and returns how long the caller has to wait for it,
+// ctx bounds the robots.txt fetch the Crawl-delay may need
+func (l *HostLimiter) reserve(ctx context.Context, rawURL string) time.Duration {
+	host := hostOf(rawURL)
+
+	// the crawl delay is looked up every time, so a refreshed robots.txt takes effect
+	limit := l.limitFor(host)
+	if l.Robots != nil {
+		robots, userAgent := l.Robots, UserAgent
+		if p, ok := ctx.Value(profileKey{}).(profileRobots); ok {
+			robots, userAgent = p.robots, p.userAgent
+		}
+		if delay := robots.CrawlDelay(ctx, userAgent, rawURL); delay > 0 && 1/delay.Seconds() < limit.Rate {
+			limit = Limit{Rate: 1 / delay.Seconds(), Burst: 1}
+		}
+	}
+
+	b := l.bucket(host)
+	b.mu.Lock()
+	for b.dropped {
+		b.mu.Unlock()
+		b = l.bucket(host)
+		b.mu.Lock()
+	}
+	defer b.mu.Unlock()
+
+	b.limit = limit
+
+	if b.limit.Rate <= 0 {
+		return 0
+	}
+
+	now := time.Now()
+	b.tokens = min(b.tokens+now.Sub(b.last).Seconds()*b.limit.Rate, float64(b.limit.Burst))
+	b.last = now
+	b.tokens--
+
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.limit.Rate * float64(time.Second))
+}
+
+func (l *HostLimiter) bucket(host string) *bucket {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	b, ok := l.buckets[host]
+	if !ok {
+		now := time.Now()
+		if now.Sub(l.swept) >= bucketSweepInterval {
+			l.sweep(now)
+		}
+
+		limit := l.limitFor(host)
+		b = &bucket{limit: limit, tokens: float64(limit.Burst), last: now}
+		l.buckets[host] = b
+	}
+	return b
+}
+
+// sweep drops the buckets that refilled, a host crawled once doesn't keep its bucket for good.
+// A full bucket is what a new one starts as, dropping it changes no host's budget.
+func (l *HostLimiter) sweep(now time.Time) {
+	l.swept = now
+	for host, b := range l.buckets {
+		b.mu.Lock()
+		if b.limit.Rate <= 0 || b.tokens+now.Sub(b.last).Seconds()*b.limit.Rate >= float64(b.limit.Burst) {
+			b.dropped = true
+			delete(l.buckets, host)
+		}
+		b.mu.Unlock()
+	}
+}
+
+// limitFor matches overrides on the host itself or any parent domain, "example.com" also covers "www.example.com"
+func (l *HostLimiter) limitFor(host string) Limit {
+	if i := strings.LastIndex(host, ":"); i > strings.LastIndex(host, "]") {
+		host = host[:i]
+	}
+
+	for name := host; name != ""; {
+		if limit, ok := l.overrides[name]; ok {
+			return limit
+		}
+
+		i := strings.Index(name, ".")
+		if i < 0 {
+			break
+		}
+		name = name[i+1:]
+	}
+	return l.defaults
+}
+
+// ParseRateOverrides reads "host=rate[:burst]" pairs separated by commas, e.g. "example.com=0.5,api.foo.com=5:10"
+func ParseRateOverrides(s string, defaultBurst int) (map[string]Limit, error) {
+	overrides := make(map[string]Limit)
+
+	for _, pair := range strings.Split(s, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+
+		host, value, ok := strings.Cut(pair, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid rate override %q", pair)
+		}
+
+		rate, burst, hasBurst := strings.Cut(value, ":")
+		limit := Limit{Burst: defaultBurst}
+
+		var err error
+		if limit.Rate, err = strconv.ParseFloat(rate, 64); err != nil {
+			return nil, fmt.Errorf("invalid rate in %q: %w", pair, err)
+		}
+		if hasBurst {
+			if limit.Burst, err = strconv.Atoi(burst); err != nil {
+				return nil, fmt.Errorf("invalid burst in %q: %w", pair, err)
+			}
+		}
+
+		overrides[strings.ToLower(strings.TrimSpace(host))] = limit
+	}
+	return overrides, nil
+}
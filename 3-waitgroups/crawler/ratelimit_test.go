@@ -0,0 +1,122 @@
+package crawler
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestHostLimiterRate(t *testing.T) {
+	limiter := NewHostLimiter(Limit{Rate: 10, Burst: 2}, map[string]Limit{"slow.test": {Rate: 1, Burst: 1}})
+	ctx := context.Background()
+
+	tests := []struct {
+		url     string
+		atLeast time.Duration
+		atMost  time.Duration
+	}{
+		// the burst goes through at once, then a token every 100ms
+		{"http://fast.test/1", 0, 0},
+		{"http://fast.test/2", 0, 0},
+		{"http://fast.test/3", 90 * time.Millisecond, 100 * time.Millisecond},
+		{"http://fast.test/4", 190 * time.Millisecond, 200 * time.Millisecond},
+		// other hosts have buckets of their own
+		{"http://other.test/", 0, 0},
+		{"http://slow.test/1", 0, 0},
+		{"http://slow.test/2", 990 * time.Millisecond, time.Second},
+	}
+	for _, tt := range tests {
+		if d := limiter.reserve(ctx, tt.url); d < tt.atLeast || d > tt.atMost {
+			t.Errorf("%s waits %s, want %s to %s", tt.url, d, tt.atLeast, tt.atMost)
+		}
+	}
+}
+
+func TestHostLimiterCrawlDelay(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "User-agent: *\nCrawl-delay: 2\n")
+	}))
+	defer server.Close()
+
+	limiter := NewHostLimiter(Limit{Rate: 100, Burst: 10}, nil)
+	limiter.Robots = NewRobotsCache(time.Hour)
+	ctx := context.Background()
+
+	// the Crawl-delay is slower than the configured rate, it wins and the burst shrinks to one
+	if d := limiter.reserve(ctx, server.URL+"/a"); d != 0 {
+		t.Errorf("first request waits %s", d)
+	}
+	if d := limiter.reserve(ctx, server.URL+"/b"); d < 1900*time.Millisecond || d > 2*time.Second {
+		t.Errorf("second request waits %s, want the 2s Crawl-delay", d)
+	}
+
+	// once ctx is done Wait gives up instead of sleeping
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	if err := limiter.Wait(canceled, server.URL+"/c"); err == nil {
+		t.Error("Wait with a canceled ctx succeeded")
+	}
+}
+
+func TestHostLimiterOverrides(t *testing.T) {
+	overrides, err := ParseRateOverrides(" Example.com=0.5, api.foo.com=5:10,,", 3)
+	if err != nil {
+		t.Fatalf("ParseRateOverrides: %v", err)
+	}
+	want := map[string]Limit{"example.com": {Rate: 0.5, Burst: 3}, "api.foo.com": {Rate: 5, Burst: 10}}
+	if !reflect.DeepEqual(overrides, want) {
+		t.Errorf("overrides = %v, want %v", overrides, want)
+	}
+
+	defaults := Limit{Rate: 2, Burst: 2}
+	limiter := NewHostLimiter(defaults, overrides)
+	tests := []struct {
+		host string
+		want Limit
+	}{
+		{"example.com", overrides["example.com"]},
+		{"www.example.com", overrides["example.com"]},
+		{"example.com:8080", overrides["example.com"]},
+		{"notexample.com", defaults},
+		{"foo.com", defaults},
+		{"v1.api.foo.com", overrides["api.foo.com"]},
+		{"[::1]:8080", defaults},
+	}
+	for _, tt := range tests {
+		if got := limiter.limitFor(tt.host); got != tt.want {
+			t.Errorf("limitFor(%q) = %v, want %v", tt.host, got, tt.want)
+		}
+	}
+
+	for _, bad := range []string{"example.com", "example.com=fast", "example.com=1:many"} {
+		if _, err := ParseRateOverrides(bad, 1); err == nil {
+			t.Errorf("ParseRateOverrides(%q) succeeded", bad)
+		}
+	}
+}
+
+func TestHostLimiterDropsIdleBuckets(t *testing.T) {
+	limiter := NewHostLimiter(Limit{Rate: 1000, Burst: 1}, map[string]Limit{"slow.test": {Rate: 0.001, Burst: 1}})
+	ctx := context.Background()
+
+	limiter.reserve(ctx, "http://fast.test/")
+	limiter.reserve(ctx, "http://slow.test/")
+	time.Sleep(5 * time.Millisecond)
+
+	// fast.test refilled meanwhile, slow.test still owes its token
+	limiter.swept = time.Time{}
+	limiter.reserve(ctx, "http://other.test/")
+	if _, ok := limiter.buckets["fast.test"]; ok {
+		t.Error("the refilled bucket was kept")
+	}
+	if _, ok := limiter.buckets["slow.test"]; !ok {
+		t.Error("the empty bucket was dropped")
+	}
+	if d := limiter.reserve(ctx, "http://slow.test/"); d < time.Second {
+		t.Errorf("slow.test waits %s after the sweep, its budget was reset", d)
+	}
+}
@@ -0,0 +1,90 @@
+package crawler
+
+import (
+	"context"
+	"math/rand/v2"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+)
+
+// DefaultRetry is used by crawls that don't bring their own policy
+var DefaultRetry = RetryPolicy{
+	MaxAttempts:     3,
+	BaseDelay:       500 * time.Millisecond,
+	MaxDelay:        30 * time.Second,
+	RetryableStatus: []int{408, 425, 429, 500, 502, 503, 504},
+}
+
+// RetryPolicy decides how often and how long to wait before a failed fetch is tried again.
+// Network errors are always retryable, HTTP errors only when their status is listed.
+type RetryPolicy struct {
+	MaxAttempts     int
+	BaseDelay       time.Duration
+	MaxDelay        time.Duration
+	RetryableStatus []int
+}
+
+func (p RetryPolicy) attempts() int {
+	return max(p.MaxAttempts, 1)
+}
+
+func (p RetryPolicy) retryableStatus(status int) bool {
+	return slices.Contains(p.RetryableStatus, status)
+}
+
+// backoff is the wait before the attempt after the given one: full jitter over an exponential ceiling
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	if p.BaseDelay <= 0 {
+		return 0
+	}
+
+	ceiling := p.BaseDelay << min(attempt-1, 30)
+	if p.MaxDelay > 0 && (ceiling > p.MaxDelay || ceiling <= 0) {
+		ceiling = p.MaxDelay
+	}
+	return time.Duration(rand.Int64N(int64(ceiling) + 1))
+}
+
+// delay picks the wait before the next attempt, a server's Retry-After wins over our own backoff
+func (p RetryPolicy) delay(attempt int, retryAfter time.Duration) time.Duration {
+	if retryAfter <= 0 {
+		return p.backoff(attempt)
+	}
+	if p.MaxDelay > 0 && retryAfter > p.MaxDelay {
+		return p.MaxDelay
+	}
+	return retryAfter
+}
+
+// parseRetryAfter reads a Retry-After header in either of its forms, seconds or an HTTP date
+func parseRetryAfter(value string, now time.Time) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		return time.Duration(max(seconds, 0)) * time.Second
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		return max(date.Sub(now), 0)
+	}
+	return 0
+}
+
+// sleep waits for d or until ctx is done
+func sleep(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,164 @@
+package crawler
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRetryPolicyBackoff(t *testing.T) {
+	tests := []struct {
+		name    string
+		policy  RetryPolicy
+		attempt int
+		ceiling time.Duration
+	}{
+		{"no base delay", RetryPolicy{MaxDelay: time.Second}, 3, 0},
+		{"first retry", RetryPolicy{BaseDelay: 100 * time.Millisecond}, 1, 100 * time.Millisecond},
+		{"doubles per attempt", RetryPolicy{BaseDelay: 100 * time.Millisecond}, 4, 800 * time.Millisecond},
+		{"capped", RetryPolicy{BaseDelay: 100 * time.Millisecond, MaxDelay: 300 * time.Millisecond}, 4, 300 * time.Millisecond},
+		{"overflow capped", RetryPolicy{BaseDelay: time.Hour, MaxDelay: time.Minute}, 100, time.Minute},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// full jitter: anything from zero up to the ceiling
+			var longest time.Duration
+			for i := 0; i < 500; i++ {
+				d := tt.policy.backoff(tt.attempt)
+				if d < 0 || d > tt.ceiling {
+					t.Fatalf("backoff(%d) = %s, want at most %s", tt.attempt, d, tt.ceiling)
+				}
+				longest = max(longest, d)
+			}
+			if longest < tt.ceiling/2 {
+				t.Errorf("the longest of 500 backoffs is %s, the ceiling is %s", longest, tt.ceiling)
+			}
+		})
+	}
+}
+
+func TestRetryPolicyDelay(t *testing.T) {
+	policy := RetryPolicy{BaseDelay: time.Millisecond, MaxDelay: 10 * time.Second}
+
+	tests := []struct {
+		name       string
+		retryAfter time.Duration
+		want       time.Duration
+	}{
+		{"Retry-After wins", 3 * time.Second, 3 * time.Second},
+		{"Retry-After capped", time.Hour, 10 * time.Second},
+	}
+	for _, tt := range tests {
+		if got := policy.delay(2, tt.retryAfter); got != tt.want {
+			t.Errorf("%s: delay = %s, want %s", tt.name, got, tt.want)
+		}
+	}
+
+	// without Retry-After it is the backoff
+	if got := policy.delay(2, 0); got > 2*time.Millisecond {
+		t.Errorf("delay without Retry-After = %s", got)
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name  string
+		value string
+		want  time.Duration
+	}{
+		{"empty", "", 0},
+		{"seconds", "120", 2 * time.Minute},
+		{"zero seconds", "0", 0},
+		{"negative seconds", "-5", 0},
+		{"http date", now.Add(90 * time.Second).Format(http.TimeFormat), 90 * time.Second},
+		{"past date", now.Add(-time.Hour).Format(http.TimeFormat), 0},
+		{"rfc 850 date", now.Add(time.Minute).Format("Monday, 02-Jan-06 15:04:05 GMT"), time.Minute},
+		{"garbage", "soon", 0},
+		{"fractional seconds", "1.5", 0},
+	}
+
+	for _, tt := range tests {
+		if got := parseRetryAfter(tt.value, now); got != tt.want {
+			t.Errorf("%s: parseRetryAfter(%q) = %s, want %s", tt.name, tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestRetryKeepsCrawlDelay(t *testing.T) {
+	var mu sync.Mutex
+	var hits []time.Time
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/robots.txt" {
+			fmt.Fprint(w, "User-agent: *\nCrawl-delay: 1\n")
+			return
+		}
+		mu.Lock()
+		hits = append(hits, time.Now())
+		mu.Unlock()
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	// the backoff is a millisecond, the host's Crawl-delay spaces the attempts out anyway
+	results := Crawl(context.Background(), []string{server.URL + "/busy"}, testOptions())
+	if r := results[0]; r.Status != StatusHTTPError || r.Attempts != 3 {
+		t.Fatalf("result = %+v", r)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	for i := 1; i < len(hits); i++ {
+		if gap := hits[i].Sub(hits[i-1]); gap < 900*time.Millisecond {
+			t.Errorf("attempt %d came %s after the one before, want the 1s Crawl-delay", i+1, gap)
+		}
+	}
+}
+
+func TestRetryWaitYieldsSlots(t *testing.T) {
+	pool := NewPool(1, 1)
+
+	var mu sync.Mutex
+	var events []string
+	record := func(event string) {
+		mu.Lock()
+		events = append(events, event)
+		mu.Unlock()
+	}
+
+	// a single slot: the other job only runs before the retrying one is done if the retry wait gave the slot away
+	waiting := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		pool.Each(context.Background(), []string{"http://a.test/"}, func(ctx context.Context, i int, url string) {
+			record("start a.test")
+			close(waiting)
+			if err := retryWait(ctx, 200*time.Millisecond); err != nil {
+				t.Errorf("retryWait: %v", err)
+			}
+			record("end a.test")
+		})
+	}()
+
+	<-waiting
+	pool.Each(context.Background(), []string{"http://b.test/"}, func(ctx context.Context, i int, url string) {
+		record("b.test")
+	})
+	<-done
+
+	want := []string{"start a.test", "b.test", "end a.test"}
+	if !slices.Equal(events, want) {
+		t.Errorf("events = %q, want %q", events, want)
+	}
+	if stats := pool.Stats(); stats.Active != 0 || stats.Completed != 2 || len(stats.Hosts) != 0 {
+		t.Errorf("stats after the run = %+v", stats)
+	}
+}
@@ -0,0 +1,395 @@
+package crawler
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultRobotsTTL = 24 * time.Hour
+
+	// robots.txt files bigger than this are cut, RFC 9309 asks crawlers to read at least 500 KiB
+	maxRobotsSize = 512 * 1024
+
+	// a failing robots.txt blocks the host, but only for a short while
+	robotsErrorTTL = time.Minute
+
+	// robotsSweepInterval is how often new hosts trigger dropping the expired robots.txt entries
+	robotsSweepInterval = time.Minute
+)
+
+// UserAgent is sent with every request and matched against robots.txt groups by its product token
+var UserAgent = "exercise3-crawler/1.0"
+
+var ErrBlockedByRobots = errors.New("blocked by robots.txt")
+
+// DefaultRobots is the robots.txt cache used by the rate limiter and by Fetch with the default client profile
+var DefaultRobots = NewRobotsCache(DefaultRobotsTTL)
+
+// Robots holds the parsed groups of a robots.txt file
+type Robots struct {
+	groups []robotsGroup
+
+	// Sitemaps lists the Sitemap lines, they apply to every user agent
+	Sitemaps []string
+}
+
+type robotsGroup struct {
+	agents     []string
+	rules      []robotsRule
+	crawlDelay time.Duration
+}
+
+type robotsRule struct {
+	allow   bool
+	pattern string
+}
+
+// ParseRobots reads a robots.txt body. Unknown lines and rules outside a group are ignored,
+// Sitemap lines are kept wherever they appear. It fails when the body can't be read to the end,
+// rules cut off halfway could allow what the rest disallows.
+func ParseRobots(r io.Reader) (*Robots, error) {
+	robots := &Robots{}
+	var group *robotsGroup
+
+	// a single line may take the whole file, long Sitemap and pattern lines aren't rare
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxRobotsSize)
+	for scanner.Scan() {
+		line, _, _ := strings.Cut(scanner.Text(), "#")
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		key = strings.ToLower(strings.TrimSpace(key))
+		value = strings.TrimSpace(value)
+
+		switch key {
+		case "user-agent":
+			// consecutive user-agent lines share the group that follows them
+			if group == nil || len(group.rules) > 0 || group.crawlDelay > 0 {
+				robots.groups = append(robots.groups, robotsGroup{})
+				group = &robots.groups[len(robots.groups)-1]
+			}
+			group.agents = append(group.agents, strings.ToLower(value))
+		case "allow", "disallow":
+			if group == nil || value == "" {
+				continue
+			}
+			group.rules = append(group.rules, robotsRule{allow: key == "allow", pattern: value})
+		case "crawl-delay":
+			if group == nil {
+				continue
+			}
+			if seconds, err := strconv.ParseFloat(value, 64); err == nil && seconds > 0 {
+				group.crawlDelay = time.Duration(seconds * float64(time.Second))
+			}
+		case "sitemap":
+			if value != "" {
+				robots.Sitemaps = append(robots.Sitemaps, value)
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return robots, nil
+}
+
+// disallowAll is used when robots.txt can't be read because of a server error
+var disallowAll = &Robots{groups: []robotsGroup{{
+	agents: []string{"*"},
+	rules:  []robotsRule{{allow: false, pattern: "/"}},
+}}}
+
+// match returns the groups that apply to the user agent.
+// The longest agent name that prefixes the product token wins, "*" is the fallback.
+// Groups naming the same agent are merged, as RFC 9309 asks.
+func (r *Robots) match(userAgent string) []robotsGroup {
+	token, _, _ := strings.Cut(strings.ToLower(userAgent), "/")
+
+	best := ""
+	for _, g := range r.groups {
+		for _, agent := range g.agents {
+			if agent != "*" && strings.HasPrefix(token, agent) && len(agent) > len(best) {
+				best = agent
+			}
+		}
+	}
+	if best == "" {
+		best = "*"
+	}
+
+	var groups []robotsGroup
+	for _, g := range r.groups {
+		for _, agent := range g.agents {
+			if agent == best {
+				groups = append(groups, g)
+				break
+			}
+		}
+	}
+	return groups
+}
+
+// Allowed reports whether the user agent may fetch path (path plus query).
+// The longest matching rule wins and Allow wins a tie.
+func (r *Robots) Allowed(userAgent, path string) bool {
+	if path == "" {
+		path = "/"
+	}
+	if path == "/robots.txt" {
+		return true
+	}
+
+	allowed, longest := true, -1
+	for _, g := range r.match(userAgent) {
+		for _, rule := range g.rules {
+			if !matchRobotsPattern(rule.pattern, path) {
+				continue
+			}
+			if n := len(rule.pattern); n > longest || (n == longest && rule.allow) {
+				allowed, longest = rule.allow, n
+			}
+		}
+	}
+	return allowed
+}
+
+// CrawlDelay returns the largest Crawl-delay of the groups that apply to the user agent
+func (r *Robots) CrawlDelay(userAgent string) time.Duration {
+	var delay time.Duration
+	for _, g := range r.match(userAgent) {
+		delay = max(delay, g.crawlDelay)
+	}
+	return delay
+}
+
+// matchRobotsPattern supports the "*" wildcard and the "$" end anchor
+func matchRobotsPattern(pattern, path string) bool {
+	anchored := strings.HasSuffix(pattern, "$")
+	pattern = strings.TrimSuffix(pattern, "$")
+
+	parts := strings.Split(pattern, "*")
+	if !strings.HasPrefix(path, parts[0]) {
+		return false
+	}
+	rest := path[len(parts[0]):]
+
+	if len(parts) == 1 {
+		return !anchored || rest == ""
+	}
+
+	// matching the middle parts as early as possible leaves the most room for the rest
+	for _, part := range parts[1 : len(parts)-1] {
+		i := strings.Index(rest, part)
+		if i < 0 {
+			return false
+		}
+		rest = rest[i+len(part):]
+	}
+
+	last := parts[len(parts)-1]
+	if anchored {
+		return strings.HasSuffix(rest, last)
+	}
+	return strings.Contains(rest, last)
+}
+
+// RobotsCache fetches robots.txt once per scheme and host and keeps it for TTL
+type RobotsCache struct {
+	TTL    time.Duration
+	Client *http.Client
+
+	now func() time.Time
+
+	mu      sync.Mutex
+	entries map[string]*robotsEntry
+	swept   time.Time
+}
+
+type robotsEntry struct {
+	ready   chan struct{}
+	robots  *Robots
+	expires time.Time
+}
+
+func NewRobotsCache(ttl time.Duration) *RobotsCache {
+	return &RobotsCache{
+		TTL:     ttl,
+		Client:  &http.Client{Timeout: 10 * time.Second},
+		now:     time.Now,
+		entries: make(map[string]*robotsEntry),
+	}
+}
+
+// Allowed reports whether the user agent may fetch rawURL, nothing is allowed once ctx is done
+func (c *RobotsCache) Allowed(ctx context.Context, userAgent, rawURL string) bool {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Host == "" {
+		return true
+	}
+	robots, err := c.get(ctx, u)
+	return err == nil && robots.Allowed(userAgent, u.RequestURI())
+}
+
+// CrawlDelay returns the Crawl-delay that applies to the user agent on rawURL's host, zero once ctx is done
+func (c *RobotsCache) CrawlDelay(ctx context.Context, userAgent, rawURL string) time.Duration {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Host == "" {
+		return 0
+	}
+	robots, err := c.get(ctx, u)
+	if err != nil {
+		return 0
+	}
+	return robots.CrawlDelay(userAgent)
+}
+
+// Sitemaps returns the Sitemap lines of the robots.txt of rawURL's host, none once ctx is done
+func (c *RobotsCache) Sitemaps(ctx context.Context, rawURL string) []string {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Host == "" {
+		return nil
+	}
+	robots, err := c.get(ctx, u)
+	if err != nil {
+		return nil
+	}
+	return robots.Sitemaps
+}
+
+// unreachable reports whether the robots.txt of rawURL's host failed to load, everything is disallowed meanwhile
+func (c *RobotsCache) unreachable(ctx context.Context, rawURL string) bool {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Host == "" {
+		return false
+	}
+	robots, err := c.get(ctx, u)
+	return err == nil && robots == disallowAll
+}
+
+// get returns the cached rules of the url's host, concurrent callers wait for a single fetch.
+// It gives up when ctx is done, a fetch its caller gave up on isn't cached and the next caller starts over.
+func (c *RobotsCache) get(ctx context.Context, u *url.URL) (*Robots, error) {
+	key := strings.ToLower(u.Scheme + "://" + u.Host)
+
+	for {
+		c.mu.Lock()
+		entry, ok := c.entries[key]
+		if ok {
+			select {
+			case <-entry.ready:
+				if c.now().After(entry.expires) {
+					ok = false
+				}
+			default:
+			}
+		}
+		if !ok {
+			if now := c.now(); now.Sub(c.swept) >= robotsSweepInterval {
+				c.sweep(now)
+			}
+			entry = &robotsEntry{ready: make(chan struct{})}
+			c.entries[key] = entry
+			c.mu.Unlock()
+
+			robots, ttl, err := c.fetch(ctx, key+"/robots.txt")
+
+			c.mu.Lock()
+			if err != nil {
+				if c.entries[key] == entry {
+					delete(c.entries, key)
+				}
+			} else {
+				entry.robots = robots
+				entry.expires = c.now().Add(ttl)
+			}
+			close(entry.ready)
+			c.mu.Unlock()
+
+			return robots, err
+		}
+		c.mu.Unlock()
+
+		select {
+		case <-entry.ready:
+			if entry.robots != nil {
+				return entry.robots, nil
+			}
+			// the fetching caller gave up, try again with this one's ctx
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// sweep drops the expired entries, a host crawled once doesn't keep its robots.txt for good.
+// Entries still being fetched stay, their callers are waiting on them. The caller holds c.mu.
+func (c *RobotsCache) sweep(now time.Time) {
+	c.swept = now
+	for key, entry := range c.entries {
+		select {
+		case <-entry.ready:
+			if now.After(entry.expires) {
+				delete(c.entries, key)
+			}
+		default:
+		}
+	}
+}
+
+// fetch downloads and parses robots.txt. A missing file allows everything,
+// a server error or an unreachable host disallows everything for a short while.
+// It only fails when ctx ended the fetch.
+func (c *RobotsCache) fetch(ctx context.Context, robotsURL string) (*Robots, time.Duration, error) {
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, robotsURL, nil)
+	if err != nil {
+		return &Robots{}, c.TTL, nil
+	}
+	request.Header.Set("User-Agent", UserAgent)
+
+	response, err := c.Client.Do(request)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, 0, ctx.Err()
+		}
+		log.Println("robots.txt fetch error:", robotsURL, err)
+		return disallowAll, robotsErrorTTL, nil
+	}
+
+	defer func(Body io.ReadCloser) {
+		if err := Body.Close(); err != nil {
+			log.Println("ERROR: Response body closing error. URL:", robotsURL)
+		}
+	}(response.Body)
+
+	switch {
+	case response.StatusCode >= 500:
+		log.Println("robots.txt fetch error:", robotsURL, fmt.Sprintf("status %d", response.StatusCode))
+		return disallowAll, robotsErrorTTL, nil
+	case response.StatusCode >= 400:
+		return &Robots{}, c.TTL, nil
+	}
+
+	robots, err := ParseRobots(io.LimitReader(response.Body, maxRobotsSize))
+	// a body cut off by the cancellation would give partial rules
+	if ctx.Err() != nil {
+		return nil, 0, ctx.Err()
+	}
+	if err != nil {
+		log.Println("robots.txt read error:", robotsURL, err)
+		return disallowAll, robotsErrorTTL, nil
+	}
+	return robots, c.TTL, nil
+}
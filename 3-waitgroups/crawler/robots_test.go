@@ -0,0 +1,307 @@
+package crawler
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"testing/iotest"
+	"time"
+)
+
+const robotsFixture = `
+# comments and unknown lines are ignored
+Sitemap: https://example.com/sitemap.xml
+
+User-agent: *
+Disallow: /private
+Allow: /private/open
+Disallow: /*.pdf$
+Crawl-delay: 2
+
+User-agent: exercise3-crawler
+User-agent: other-bot
+Disallow: /only-us
+Allow: /only-us/but-this
+
+User-agent: exercise3-crawler
+Disallow: /tmp/
+`
+
+func mustParseRobots(t *testing.T, body string) *Robots {
+	t.Helper()
+
+	robots, err := ParseRobots(strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("ParseRobots: %v", err)
+	}
+	return robots
+}
+
+func TestRobotsAllowed(t *testing.T) {
+	robots := mustParseRobots(t, robotsFixture)
+
+	tests := []struct {
+		agent string
+		path  string
+		want  bool
+	}{
+		{"somebot/2.0", "/", true},
+		{"somebot/2.0", "/private", false},
+		{"somebot/2.0", "/private/secret", false},
+		{"somebot/2.0", "/private/open/page", true},
+		{"somebot/2.0", "/files/report.pdf", false},
+		{"somebot/2.0", "/files/report.pdf?x=1", true},
+		{"somebot/2.0", "/only-us", true},
+		{"somebot/2.0", "/robots.txt", true},
+
+		// the specific group replaces "*", and both groups naming it are merged
+		{"exercise3-crawler/1.0", "/private", true},
+		{"Exercise3-Crawler/1.0", "/only-us", false},
+		{"exercise3-crawler/1.0", "/only-us/but-this", true},
+		{"exercise3-crawler/1.0", "/tmp/file", false},
+		{"other-bot", "/tmp/file", true},
+	}
+
+	for _, tt := range tests {
+		if got := robots.Allowed(tt.agent, tt.path); got != tt.want {
+			t.Errorf("Allowed(%q, %q) = %v, want %v", tt.agent, tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestRobotsTieGoesToAllow(t *testing.T) {
+	robots := mustParseRobots(t, "User-agent: *\nDisallow: /page\nAllow: /page\n")
+
+	if !robots.Allowed("bot", "/page") {
+		t.Error("equally long Allow and Disallow rules should allow")
+	}
+}
+
+func TestParseRobotsLongLines(t *testing.T) {
+	// a line longer than bufio's default 64 KiB doesn't end the parsing
+	sitemap := "https://example.com/" + strings.Repeat("s", 100<<10) + ".xml"
+	robots := mustParseRobots(t, "Sitemap: "+sitemap+"\nUser-agent: *\nDisallow: /private\n")
+	if len(robots.Sitemaps) != 1 || robots.Sitemaps[0] != sitemap || robots.Allowed(UserAgent, "/private") {
+		t.Errorf("rules after a long line = %+v", robots)
+	}
+
+	// a body that can't be read to the end fails instead of giving the rules before the break
+	broken := io.MultiReader(strings.NewReader("User-agent: *\nAllow: /\n"), iotest.ErrReader(errors.New("connection reset")))
+	if _, err := ParseRobots(broken); err == nil {
+		t.Error("ParseRobots of a broken body should fail")
+	}
+	tooLong := strings.NewReader("Disallow: /" + strings.Repeat("x", maxRobotsSize) + "\n")
+	if _, err := ParseRobots(tooLong); !errors.Is(err, bufio.ErrTooLong) {
+		t.Errorf("ParseRobots of a line over the size cap = %v, want bufio.ErrTooLong", err)
+	}
+}
+
+func TestMatchRobotsPattern(t *testing.T) {
+	tests := []struct {
+		pattern string
+		path    string
+		want    bool
+	}{
+		{"/", "/anything", true},
+		{"/fish", "/fish.html", true},
+		{"/fish", "/Fish", false},
+		{"/fish$", "/fish", true},
+		{"/fish$", "/fish/", false},
+		{"/*.php", "/folder/index.php?x", true},
+		{"/*.php$", "/folder/index.php?x", false},
+		{"/a*b*c", "/a-b-c-d", true},
+		{"/a*b*c", "/a-c-b", false},
+		{"/a*$", "/a/whatever", true},
+	}
+
+	for _, tt := range tests {
+		if got := matchRobotsPattern(tt.pattern, tt.path); got != tt.want {
+			t.Errorf("matchRobotsPattern(%q, %q) = %v, want %v", tt.pattern, tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestRobotsCrawlDelay(t *testing.T) {
+	robots := mustParseRobots(t, robotsFixture)
+
+	if got := robots.CrawlDelay("somebot"); got != 2*time.Second {
+		t.Errorf("CrawlDelay = %v, want 2s", got)
+	}
+	if got := robots.CrawlDelay(UserAgent); got != 0 {
+		t.Errorf("CrawlDelay = %v, want 0 for a group without a delay", got)
+	}
+}
+
+func TestRobotsCacheExpires(t *testing.T) {
+	var hits atomic.Int32
+	var body atomic.Value
+	body.Store("User-agent: *\nDisallow: /blocked\n")
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/robots.txt" {
+			hits.Add(1)
+			w.Write([]byte(body.Load().(string)))
+		}
+	}))
+	defer server.Close()
+
+	now := time.Now()
+	cache := NewRobotsCache(time.Hour)
+	cache.now = func() time.Time { return now }
+
+	if cache.Allowed(context.Background(), UserAgent, server.URL+"/blocked") {
+		t.Fatal("/blocked should be disallowed")
+	}
+	if !cache.Allowed(context.Background(), UserAgent, server.URL+"/open") {
+		t.Fatal("/open should be allowed")
+	}
+	if n := hits.Load(); n != 1 {
+		t.Fatalf("robots.txt fetched %d times, want 1", n)
+	}
+
+	// after the TTL the file is fetched again and the new rules apply
+	body.Store("User-agent: *\nDisallow:\n")
+	now = now.Add(2 * time.Hour)
+
+	if !cache.Allowed(context.Background(), UserAgent, server.URL+"/blocked") {
+		t.Error("/blocked should be allowed after refresh")
+	}
+	if n := hits.Load(); n != 2 {
+		t.Errorf("robots.txt fetched %d times, want 2", n)
+	}
+}
+
+func TestRobotsCacheSweep(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("User-agent: *\nDisallow: /blocked\n"))
+	}))
+	defer server.Close()
+	other := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer other.Close()
+
+	now := time.Now()
+	cache := NewRobotsCache(time.Hour)
+	cache.now = func() time.Time { return now }
+	entries := func() int {
+		cache.mu.Lock()
+		defer cache.mu.Unlock()
+		return len(cache.entries)
+	}
+
+	cache.Allowed(context.Background(), UserAgent, server.URL+"/page")
+	now = now.Add(30 * time.Minute)
+	cache.Allowed(context.Background(), UserAgent, other.URL+"/page")
+	if n := entries(); n != 2 {
+		t.Fatalf("%d entries cached, want both hosts", n)
+	}
+
+	// the first host expired, the next new host drops it
+	now = now.Add(45 * time.Minute)
+	cache.Allowed(context.Background(), UserAgent, "http://127.0.0.1:1/page")
+	if n := entries(); n != 2 {
+		t.Errorf("%d entries cached after the sweep, want the second and the new host", n)
+	}
+	if cache.Allowed(context.Background(), UserAgent, server.URL+"/blocked") {
+		t.Error("the swept host's rules are fetched again")
+	}
+}
+
+func TestRobotsCacheCanceled(t *testing.T) {
+	var hang atomic.Bool
+	hang.Store(true)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if hang.Load() {
+			<-r.Context().Done()
+			return
+		}
+		w.Write([]byte("User-agent: *\nDisallow: /blocked\n"))
+	}))
+	defer server.Close()
+
+	cache := NewRobotsCache(time.Hour)
+
+	// the caller fetching robots.txt and one waiting on it both give up with their ctx
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	done := make(chan bool)
+	go func() { done <- cache.Allowed(ctx, UserAgent, server.URL+"/open") }()
+	time.Sleep(5 * time.Millisecond)
+
+	waiter, stop := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer stop()
+	start := time.Now()
+	if cache.Allowed(waiter, UserAgent, server.URL+"/open") || <-done {
+		t.Error("a canceled lookup allowed the page")
+	}
+	if took := time.Since(start); took > time.Second {
+		t.Errorf("the lookups took %s to give up", took)
+	}
+
+	// the abandoned fetch isn't cached, the next caller gets the real rules
+	hang.Store(false)
+	if !cache.Allowed(context.Background(), UserAgent, server.URL+"/open") || cache.Allowed(context.Background(), UserAgent, server.URL+"/blocked") {
+		t.Error("the rules after the canceled fetch are wrong")
+	}
+}
+
+func TestRobotsCacheStatusCodes(t *testing.T) {
+	tests := []struct {
+		status int
+		want   bool
+	}{
+		{http.StatusNotFound, true},
+		{http.StatusForbidden, true},
+		{http.StatusInternalServerError, false},
+		{http.StatusServiceUnavailable, false},
+	}
+
+	for _, tt := range tests {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(tt.status)
+		}))
+
+		if got := NewRobotsCache(time.Hour).Allowed(context.Background(), UserAgent, server.URL+"/page"); got != tt.want {
+			t.Errorf("robots.txt status %d: Allowed = %v, want %v", tt.status, got, tt.want)
+		}
+		server.Close()
+	}
+}
+
+func TestFetchBlockedByRobots(t *testing.T) {
+	var pageHits atomic.Int32
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/robots.txt" {
+			w.Write([]byte("User-agent: *\nDisallow: /secret\n"))
+			return
+		}
+		pageHits.Add(1)
+		w.Write([]byte("<html><body><h1>hello</h1></body></html>"))
+	}))
+	defer server.Close()
+
+	result, err := Fetch(context.Background(), server.URL+"/secret/page", DefaultOptions())
+	if !errors.Is(err, ErrBlockedByRobots) {
+		t.Fatalf("err = %v, want ErrBlockedByRobots", err)
+	}
+	if result.Status != StatusBlockedByRobots {
+		t.Errorf("Status = %q, want %q", result.Status, StatusBlockedByRobots)
+	}
+	if n := pageHits.Load(); n != 0 {
+		t.Errorf("blocked page was requested %d times", n)
+	}
+
+	result, err = Fetch(context.Background(), server.URL+"/public", DefaultOptions())
+	if err != nil {
+		t.Fatalf("Fetch allowed page: %v", err)
+	}
+	if result.Status != StatusSuccess || len(result.Elements) != 1 {
+		t.Errorf("got status %q with %d elements, want success with 1", result.Status, len(result.Elements))
+	}
+}
@@ -0,0 +1,251 @@
+package crawler
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// sitemaps.org caps a sitemap file at 50,000 URLs and 50 MB uncompressed
+	DefaultMaxSitemapURLs = 50000
+	maxSitemapSize        = 50 << 20
+
+	// indexes may list other indexes, the cap stops runaway nesting
+	maxSitemapDepth = 4
+
+	// DefaultSitemapPriority is what sitemaps.org assumes for entries without a priority
+	DefaultSitemapPriority = 0.5
+)
+
+var ErrNoSitemap = errors.New("no sitemap could be read")
+
+// SitemapURL is a page listed by a sitemap, LastMod is zero when the entry has none
+type SitemapURL struct {
+	Loc      string    `json:"loc"`
+	LastMod  time.Time `json:"lastmod"`
+	Priority float64   `json:"priority"`
+}
+
+type sitemapFile struct {
+	URLs []struct {
+		Loc      string `xml:"loc"`
+		LastMod  string `xml:"lastmod"`
+		Priority string `xml:"priority"`
+	} `xml:"url"`
+	Sitemaps []struct {
+		Loc string `xml:"loc"`
+	} `xml:"sitemap"`
+}
+
+// ParseSitemap reads a urlset or a sitemap index, gzipped or not.
+// It returns the page entries and the locations of the sitemaps an index points to.
+func ParseSitemap(r io.Reader) ([]SitemapURL, []string, error) {
+	buffered := bufio.NewReader(r)
+
+	// .xml.gz files are usually served as application/gzip, without a Content-Encoding to undo it
+	var body io.Reader = buffered
+	if magic, _ := buffered.Peek(2); len(magic) == 2 && magic[0] == 0x1f && magic[1] == 0x8b {
+		unzipped, err := gzip.NewReader(buffered)
+		if err != nil {
+			return nil, nil, err
+		}
+		defer unzipped.Close()
+		body = unzipped
+	}
+
+	var file sitemapFile
+	if err := xml.NewDecoder(io.LimitReader(body, maxSitemapSize)).Decode(&file); err != nil {
+		return nil, nil, err
+	}
+
+	urls := make([]SitemapURL, 0, len(file.URLs))
+	for _, entry := range file.URLs {
+		loc := strings.TrimSpace(entry.Loc)
+		if loc == "" {
+			continue
+		}
+		urls = append(urls, SitemapURL{
+			Loc:      loc,
+			LastMod:  parseLastMod(entry.LastMod),
+			Priority: parsePriority(entry.Priority),
+		})
+	}
+
+	var sitemaps []string
+	for _, entry := range file.Sitemaps {
+		if loc := strings.TrimSpace(entry.Loc); loc != "" {
+			sitemaps = append(sitemaps, loc)
+		}
+	}
+	return urls, sitemaps, nil
+}
+
+// lastmod is a W3C datetime, anything from a bare year to fractional seconds
+var lastModLayouts = []string{time.RFC3339Nano, "2006-01-02T15:04Z07:00", "2006-01-02", "2006-01", "2006"}
+
+func parseLastMod(value string) time.Time {
+	value = strings.TrimSpace(value)
+	for _, layout := range lastModLayouts {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+func parsePriority(value string) float64 {
+	priority, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+	if err != nil || priority < 0 || priority > 1 {
+		return DefaultSitemapPriority
+	}
+	return priority
+}
+
+// SiteSitemaps returns the sitemaps robots.txt announces for site, or the conventional /sitemap.xml.
+// robots.txt is read with the profile's client and cache.
+func SiteSitemaps(ctx context.Context, site string, profile ClientProfile) ([]string, error) {
+	u, err := url.Parse(site)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("invalid site %q", site)
+	}
+
+	client, err := clientFor(profile)
+	if err != nil {
+		return nil, err
+	}
+	if sitemaps := client.robots.Sitemaps(ctx, site); len(sitemaps) > 0 {
+		return sitemaps, nil
+	}
+	return []string{u.Scheme + "://" + u.Host + "/sitemap.xml"}, nil
+}
+
+// DiscoverSitemaps walks the sitemaps and the indexes they lead to and returns at most limit pages,
+// highest priority first and the most recently modified first within a priority.
+// The sitemaps are fetched with the profile's client like pages, within robots.txt and the pool's limits.
+// A sitemap that fails is logged and skipped, ErrNoSitemap means none of them could be read.
+func DiscoverSitemaps(ctx context.Context, sitemaps []string, limit int, profile ClientProfile) ([]SitemapURL, error) {
+	if limit <= 0 {
+		limit = DefaultMaxSitemapURLs
+	}
+	client, err := clientFor(profile)
+	if err != nil {
+		return nil, err
+	}
+
+	type queued struct {
+		url   string
+		depth int
+	}
+	queue := make([]queued, 0, len(sitemaps))
+	visited := make(map[string]bool)
+	for _, sitemap := range sitemaps {
+		queue = append(queue, queued{url: sitemap})
+	}
+
+	var urls []SitemapURL
+	seen := make(map[string]bool)
+	read := 0
+
+	for len(queue) > 0 && len(urls) < limit {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		next := queue[0]
+		queue = queue[1:]
+		if visited[next.url] || next.depth > maxSitemapDepth {
+			continue
+		}
+		visited[next.url] = true
+
+		entries, children, err := fetchSitemap(ctx, client, profile, next.url)
+		if err != nil {
+			log.Println("sitemap fetch error:", next.url, err)
+			continue
+		}
+		read++
+
+		for _, child := range children {
+			queue = append(queue, queued{url: child, depth: next.depth + 1})
+		}
+		for _, entry := range entries {
+			loc, err := NormalizeURL(entry.Loc)
+			if err != nil || seen[loc] {
+				continue
+			}
+			seen[loc] = true
+			entry.Loc = loc
+			urls = append(urls, entry)
+			if len(urls) == limit {
+				break
+			}
+		}
+	}
+
+	if read == 0 {
+		return nil, ErrNoSitemap
+	}
+
+	sort.SliceStable(urls, func(i, j int) bool {
+		if urls[i].Priority != urls[j].Priority {
+			return urls[i].Priority > urls[j].Priority
+		}
+		return urls[i].LastMod.After(urls[j].LastMod)
+	})
+	return urls, nil
+}
+
+// fetchSitemap takes a slot of DefaultPool for the fetch, so sitemaps count toward the host's limits and rate
+func fetchSitemap(ctx context.Context, client *profileClient, profile ClientProfile, sitemapURL string) ([]SitemapURL, []string, error) {
+	if !client.robots.Allowed(ctx, profile.userAgent(), sitemapURL) {
+		return nil, nil, ErrBlockedByRobots
+	}
+
+	var entries []SitemapURL
+	var children []string
+	err := ctx.Err()
+	DefaultPool.Each(withProfile(ctx, profile), []string{sitemapURL}, func(ctx context.Context, _ int, sitemapURL string) {
+		entries, children, err = downloadSitemap(ctx, client, profile, sitemapURL)
+	})
+	return entries, children, err
+}
+
+func downloadSitemap(ctx context.Context, client *profileClient, profile ClientProfile, sitemapURL string) ([]SitemapURL, []string, error) {
+	fetchCtx, cancel := context.WithTimeout(ctx, FetchTimeout)
+	defer cancel()
+
+	request, err := http.NewRequestWithContext(fetchCtx, http.MethodGet, sitemapURL, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	profile.setHeaders(request)
+
+	response, err := client.Do(request)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	defer func(Body io.ReadCloser) {
+		if err := Body.Close(); err != nil {
+			log.Println("ERROR: Response body closing error. URL:", sitemapURL)
+		}
+	}(response.Body)
+
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return nil, nil, fmt.Errorf("unexpected HTTP status: %s", response.Status)
+	}
+
+	return ParseSitemap(io.LimitReader(response.Body, maxSitemapSize))
+}
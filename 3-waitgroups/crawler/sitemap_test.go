@@ -0,0 +1,198 @@
+package crawler
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestDiscoverSitemapsWithProfile(t *testing.T) {
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/robots.txt":
+			fmt.Fprintf(w, "User-agent: *\nSitemap: %s/pages.xml\n", server.URL)
+		case "/pages.xml":
+			if r.Header.Get("X-Token") != "secret" || r.Header.Get("User-Agent") != "profile-agent" {
+				http.Error(w, "forbidden", http.StatusForbidden)
+				return
+			}
+			fmt.Fprintf(w, `<urlset><url><loc>%s/a</loc><priority>0.8</priority></url><url><loc>%s/b</loc></url></urlset>`, server.URL, server.URL)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	profile := ClientProfile{UserAgent: "profile-agent", Headers: map[string]string{"X-Token": "secret"}}
+	sitemaps, err := SiteSitemaps(context.Background(), server.URL, profile)
+	if err != nil || len(sitemaps) != 1 || sitemaps[0] != server.URL+"/pages.xml" {
+		t.Fatalf("SiteSitemaps = %v, %v", sitemaps, err)
+	}
+
+	entries, err := DiscoverSitemaps(context.Background(), sitemaps, 0, profile)
+	if err != nil || len(entries) != 2 || entries[0].Loc != server.URL+"/a" || entries[0].Priority != 0.8 {
+		t.Fatalf("DiscoverSitemaps = %+v, %v", entries, err)
+	}
+
+	// without the profile's headers the sitemap is refused
+	if _, err := DiscoverSitemaps(context.Background(), sitemaps, 0, ClientProfile{}); !errors.Is(err, ErrNoSitemap) {
+		t.Errorf("without the profile: %v, want ErrNoSitemap", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := DiscoverSitemaps(ctx, sitemaps, 0, profile); !errors.Is(err, context.Canceled) {
+		t.Errorf("with a canceled ctx: %v", err)
+	}
+}
+
+func TestDiscoverSitemapsKeepsToRobots(t *testing.T) {
+	var mu sync.Mutex
+	hits := make(map[string][]time.Time)
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		hits[r.URL.Path] = append(hits[r.URL.Path], time.Now())
+		mu.Unlock()
+
+		switch r.URL.Path {
+		case "/robots.txt":
+			fmt.Fprint(w, "User-agent: *\nDisallow: /private\nCrawl-delay: 1\n")
+		case "/index.xml":
+			fmt.Fprintf(w, `<sitemapindex><sitemap><loc>%[1]s/private/pages.xml</loc></sitemap><sitemap><loc>%[1]s/pages.xml</loc></sitemap></sitemapindex>`, server.URL)
+		default:
+			fmt.Fprintf(w, `<urlset><url><loc>%s%s/page</loc></url></urlset>`, server.URL, strings.TrimSuffix(r.URL.Path, "/pages.xml"))
+		}
+	}))
+	defer server.Close()
+
+	// the disallowed sitemap isn't fetched, the others wait for the host's Crawl-delay like pages do
+	entries, err := DiscoverSitemaps(context.Background(), []string{server.URL + "/index.xml"}, 0, ClientProfile{})
+	if err != nil || len(entries) != 1 || entries[0].Loc != server.URL+"/page" {
+		t.Fatalf("DiscoverSitemaps = %+v, %v", entries, err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(hits["/private/pages.xml"]) != 0 {
+		t.Error("the sitemap disallowed by robots.txt was fetched")
+	}
+	if gap := hits["/pages.xml"][0].Sub(hits["/index.xml"][0]); gap < 900*time.Millisecond {
+		t.Errorf("second sitemap fetched %s after the first, want the 1s Crawl-delay", gap)
+	}
+}
+
+func TestParseSitemap(t *testing.T) {
+	gzipped := func(s string) string {
+		var buf bytes.Buffer
+		w := gzip.NewWriter(&buf)
+		w.Write([]byte(s))
+		w.Close()
+		return buf.String()
+	}
+	const urlset = `<?xml version="1.0" encoding="UTF-8"?>
+<urlset xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">
+	<url><loc> http://example.com/a </loc><lastmod>2024-05-01</lastmod><priority>0.9</priority></url>
+	<url><loc>http://example.com/b</loc><lastmod>2024-05-01T10:30:00+02:00</lastmod><priority>2</priority></url>
+	<url><loc></loc></url>
+	<url><loc>http://example.com/c</loc><lastmod>yesterday</lastmod></url>
+</urlset>`
+
+	tests := []struct {
+		name     string
+		body     string
+		urls     []SitemapURL
+		sitemaps []string
+		fails    bool
+	}{
+		{"urlset", urlset, []SitemapURL{
+			{Loc: "http://example.com/a", LastMod: time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC), Priority: 0.9},
+			{Loc: "http://example.com/b", LastMod: time.Date(2024, 5, 1, 8, 30, 0, 0, time.UTC), Priority: DefaultSitemapPriority},
+			{Loc: "http://example.com/c", Priority: DefaultSitemapPriority},
+		}, nil, false},
+		{"gzipped urlset", gzipped(`<urlset><url><loc>http://example.com/a</loc><lastmod>2024</lastmod></url></urlset>`), []SitemapURL{
+			{Loc: "http://example.com/a", LastMod: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), Priority: DefaultSitemapPriority},
+		}, nil, false},
+		{"index", `<sitemapindex><sitemap><loc>http://example.com/one.xml</loc></sitemap><sitemap><loc> http://example.com/two.xml.gz </loc></sitemap></sitemapindex>`,
+			[]SitemapURL{}, []string{"http://example.com/one.xml", "http://example.com/two.xml.gz"}, false},
+		{"not xml", "<html><body>Not found</body>", nil, nil, true},
+		{"broken gzip", "\x1f\x8b garbage", nil, nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			urls, sitemaps, err := ParseSitemap(strings.NewReader(tt.body))
+			if (err != nil) != tt.fails {
+				t.Fatalf("err = %v", err)
+			}
+			if tt.fails {
+				return
+			}
+			if len(urls) != len(tt.urls) {
+				t.Fatalf("urls = %+v, want %+v", urls, tt.urls)
+			}
+			for i, want := range tt.urls {
+				if urls[i].Loc != want.Loc || !urls[i].LastMod.Equal(want.LastMod) || urls[i].Priority != want.Priority {
+					t.Errorf("url %d = %+v, want %+v", i, urls[i], want)
+				}
+			}
+			if !slices.Equal(sitemaps, tt.sitemaps) {
+				t.Errorf("sitemaps = %v, want %v", sitemaps, tt.sitemaps)
+			}
+		})
+	}
+}
+
+func TestDiscoverSitemapsOrderAndLimit(t *testing.T) {
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/index.xml":
+			// the index lists itself, it is read once
+			fmt.Fprintf(w, `<sitemapindex><sitemap><loc>%[1]s/pages.xml</loc></sitemap><sitemap><loc>%[1]s/index.xml</loc></sitemap>
+				<sitemap><loc>%[1]s/missing.xml</loc></sitemap></sitemapindex>`, server.URL)
+		case "/pages.xml":
+			fmt.Fprintf(w, `<urlset>
+				<url><loc>%[1]s/old</loc><lastmod>2020-01-01</lastmod></url>
+				<url><loc>%[1]s/new</loc><lastmod>2024-01-01</lastmod></url>
+				<url><loc>%[1]s/top</loc><priority>1.0</priority></url>
+				<url><loc>%[1]s/new#again</loc></url>
+			</urlset>`, server.URL)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	entries, err := DiscoverSitemaps(context.Background(), []string{server.URL + "/index.xml"}, 0, ClientProfile{})
+	if err != nil {
+		t.Fatalf("DiscoverSitemaps: %v", err)
+	}
+	var locs []string
+	for _, entry := range entries {
+		locs = append(locs, strings.TrimPrefix(entry.Loc, server.URL))
+	}
+	// highest priority first, then the most recent; repeated urls are dropped once normalized
+	if want := []string{"/top", "/new", "/old"}; !slices.Equal(locs, want) {
+		t.Errorf("entries = %v, want %v", locs, want)
+	}
+
+	limited, _ := DiscoverSitemaps(context.Background(), []string{server.URL + "/pages.xml"}, 2, ClientProfile{})
+	if len(limited) != 2 {
+		t.Errorf("%d entries with a limit of 2", len(limited))
+	}
+
+	if _, err := DiscoverSitemaps(context.Background(), []string{server.URL + "/missing.xml"}, 0, ClientProfile{}); !errors.Is(err, ErrNoSitemap) {
+		t.Errorf("only a missing sitemap: %v", err)
+	}
+}
@@ -1,8 +1,103 @@
 package crawler
 
-import "exercise3/models"
+import (
+	"exercise3/models"
+	"time"
+)
+
+// Outcome of a single URL in a crawl
+const (
+	StatusSuccess    = "success"
+	StatusHTTPError  = "http_error"
+	StatusFetchError = "fetch_error"
+	StatusParseError = "parse_error"
+	StatusDBError    = "db_error"
+
+	StatusBlockedByRobots = "blocked_by_robots"
+	StatusCanceled        = "canceled"
+	StatusSkipped         = "skipped"
+	StatusTooLarge        = "too_large"
+
+	// the sitemap lastmod is not newer than the last successful crawl, the page wasn't fetched
+	StatusUnchanged = "unchanged"
+	// the server answered a conditional fetch with 304, the stored version is still current
+	StatusNotModified = "not_modified"
+	// a distributed worker's lease ran out and another worker took the URL over, the page wasn't stored
+	StatusLeaseLost = "lease_lost"
+)
 
 type PageResult struct {
-	URL      string
-	Elements []models.Element
+	URL        string
+	Status     string
+	StatusCode int
+	Error      string
+	Attempts   int
+	LastError  string
+
+	FetchedAt   time.Time
+	ContentType string
+	Bytes       int64
+	ContentHash string
+	Meta        *models.PageMeta
+	Elements    []models.Element
+	// ExtractionHash is the Hash of the spec the elements were extracted with
+	ExtractionHash string
+	// SimHash fingerprints the extracted text, near-duplicate pages are a few bits apart
+	SimHash uint64
+
+	// cache validators of the response, sent back on the next crawl of the page
+	ETag         string
+	LastModified string
+	// BytesSaved is the stored size of a page the server reported as not modified
+	BytesSaved int64
+
+	// filled by the persistence stage, Version is 0 when no snapshot was stored
+	PageID  uint
+	Version int
+}
+
+// Options are the per-crawl settings, DefaultOptions gives the process-wide ones
+type Options struct {
+	Retry       RetryPolicy
+	Extraction  ExtractionSpec
+	MaxBodySize int64
+	Client      ClientProfile
+
+	// Validators makes the fetches of the listed URLs conditional
+	Validators map[string]Validator
+}
+
+// Validator holds what an earlier fetch of a URL stored, ETag and LastModified are the raw header values
+type Validator struct {
+	ETag         string
+	LastModified string
+	ContentType  string
+	Bytes        int64
+}
+
+// MaxBodySize is the body cap of crawls that don't set their own
+var MaxBodySize int64 = DefaultMaxBodySize
+
+func DefaultOptions() Options {
+	return Options{
+		Retry:       DefaultRetry,
+		Extraction:  DefaultExtraction,
+		MaxBodySize: MaxBodySize,
+	}
+}
+
+func (o Options) maxBodySize() int64 {
+	if o.MaxBodySize <= 0 {
+		return MaxBodySize
+	}
+	return o.MaxBodySize
+}
+
+// fail marks the result with the given status and error message, a nil err sets the status only
+func (r *PageResult) fail(status string, err error) {
+	r.Status = status
+	if err != nil {
+		r.Error = err.Error()
+		r.LastError = r.Error
+	}
 }
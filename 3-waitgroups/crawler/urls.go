@@ -0,0 +1,139 @@
+package crawler
+
+import (
+	"errors"
+	"net"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+var errNotCrawlable = errors.New("not an http(s) url")
+
+// urlAttributes are the attributes whose values get resolved next to the raw value
+var urlAttributes = map[string]bool{
+	"href":     true,
+	"src":      true,
+	"action":   true,
+	"data-src": true,
+	"poster":   true,
+}
+
+// NormalizeURL gives equal pages equal strings: scheme and host are lowercased, default ports,
+// fragments and dot segments are dropped, query parameters are sorted and an empty path becomes "/"
+func NormalizeURL(rawURL string) (string, error) {
+	u, err := url.Parse(strings.TrimSpace(rawURL))
+	if err != nil {
+		return "", err
+	}
+	return normalize(u)
+}
+
+// ResolveURL resolves a link against the page's base url and normalizes it.
+// Links that can't be fetched, like "javascript:" or "mailto:", give false.
+func ResolveURL(base *url.URL, ref string) (string, bool) {
+	ref = strings.TrimSpace(ref)
+	if ref == "" || base == nil {
+		return "", false
+	}
+
+	u, err := base.Parse(ref)
+	if err != nil {
+		return "", false
+	}
+
+	resolved, err := normalize(u)
+	return resolved, err == nil
+}
+
+func normalize(u *url.URL) (string, error) {
+	n := *u
+	n.Scheme = strings.ToLower(n.Scheme)
+	if n.Scheme != "http" && n.Scheme != "https" {
+		return "", errNotCrawlable
+	}
+	if n.Host == "" {
+		return "", errNotCrawlable
+	}
+
+	host, port := strings.ToLower(n.Hostname()), n.Port()
+	if (n.Scheme == "http" && port == "80") || (n.Scheme == "https" && port == "443") {
+		port = ""
+	}
+	if port != "" {
+		n.Host = net.JoinHostPort(host, port)
+	} else if strings.Contains(host, ":") {
+		n.Host = "[" + host + "]"
+	} else {
+		n.Host = host
+	}
+
+	// the escaped path keeps reserved characters like %2F apart from the separators they stand for
+	n.RawPath = removeDotSegments(n.EscapedPath())
+	path, err := url.PathUnescape(n.RawPath)
+	if err != nil {
+		return "", err
+	}
+	n.Path = path
+
+	n.Fragment = ""
+	n.RawFragment = ""
+	n.RawQuery = sortQuery(n.RawQuery)
+	n.ForceQuery = false
+
+	return n.String(), nil
+}
+
+// removeDotSegments drops the "." and ".." segments of an absolute path, an empty path becomes "/"
+func removeDotSegments(path string) string {
+	segments := strings.Split(strings.TrimPrefix(path, "/"), "/")
+	kept := make([]string, 0, len(segments))
+	for i, segment := range segments {
+		last := i == len(segments)-1
+		switch segment {
+		case ".":
+		case "..":
+			if len(kept) > 0 {
+				kept = kept[:len(kept)-1]
+			}
+		default:
+			kept = append(kept, segment)
+			continue
+		}
+		// a trailing dot segment leaves a directory, "/a/b/.." is "/a/"
+		if last {
+			kept = append(kept, "")
+		}
+	}
+	return "/" + strings.Join(kept, "/")
+}
+
+// sortQuery sorts the query pairs by key as they were sent. Decoding them would change what the
+// server gets, and url.ParseQuery drops pairs holding a ";". Pairs with equal keys keep their order.
+func sortQuery(rawQuery string) string {
+	var pairs []string
+	for _, pair := range strings.Split(rawQuery, "&") {
+		if pair != "" {
+			pairs = append(pairs, pair)
+		}
+	}
+	sort.SliceStable(pairs, func(i, j int) bool {
+		ki, _, _ := strings.Cut(pairs[i], "=")
+		kj, _, _ := strings.Cut(pairs[j], "=")
+		return ki < kj
+	})
+	return strings.Join(pairs, "&")
+}
+
+// documentBase is the url relative links resolve against, a <base href> wins over the page url
+func documentBase(pageURL *url.URL, baseHref string) *url.URL {
+	if baseHref == "" {
+		return pageURL
+	}
+
+	base, err := pageURL.Parse(strings.TrimSpace(baseHref))
+	if err != nil {
+		return pageURL
+	}
+	return base
+}
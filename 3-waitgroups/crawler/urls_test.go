@@ -0,0 +1,49 @@
+package crawler
+
+import "testing"
+
+func TestNormalizeURL(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{"lowercased scheme and host", "HTTP://Example.COM/Path", "http://example.com/Path"},
+		{"default http port", "http://example.com:80/", "http://example.com/"},
+		{"default https port", "https://example.com:443/", "https://example.com/"},
+		{"other port kept", "https://example.com:8443/", "https://example.com:8443/"},
+		{"ipv6 host", "http://[::1]:80/", "http://[::1]/"},
+		{"empty path", "http://example.com", "http://example.com/"},
+		{"fragment dropped", "http://example.com/a#top", "http://example.com/a"},
+		{"dot segments", "http://example.com/a/./b/../c", "http://example.com/a/c"},
+		{"trailing dot dot", "http://example.com/a/b/..", "http://example.com/a/"},
+		{"dot dot above root", "http://example.com/../a", "http://example.com/a"},
+		{"trailing slash kept", "http://example.com/a/", "http://example.com/a/"},
+		{"escaped slash kept", "http://example.com/files/a%2Fb", "http://example.com/files/a%2Fb"},
+		{"escaped space kept", "http://example.com/a%20b", "http://example.com/a%20b"},
+		{"query sorted by key", "http://example.com/?b=2&a=1", "http://example.com/?a=1&b=2"},
+		{"repeated keys keep their order", "http://example.com/?a=2&b=1&a=1", "http://example.com/?a=2&a=1&b=1"},
+		{"query not decoded", "http://example.com/?q=a+b&p=%2F", "http://example.com/?p=%2F&q=a+b"},
+		{"semicolon pair kept", "http://example.com/?b=1;2&a=1", "http://example.com/?a=1&b=1;2"},
+		{"empty pairs dropped", "http://example.com/?&a=1&&", "http://example.com/?a=1"},
+		{"empty query dropped", "http://example.com/a?", "http://example.com/a"},
+		{"spaces trimmed", "  http://example.com/a  ", "http://example.com/a"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := NormalizeURL(tt.in)
+			if err != nil || got != tt.want {
+				t.Errorf("NormalizeURL(%q) = %q, %v, want %q", tt.in, got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestNormalizeURLRejects(t *testing.T) {
+	for _, in := range []string{"mailto:someone@example.com", "javascript:void(0)", "ftp://example.com/", "/relative", "http://%zz"} {
+		if got, err := NormalizeURL(in); err == nil {
+			t.Errorf("NormalizeURL(%q) = %q, want an error", in, got)
+		}
+	}
+}
@@ -0,0 +1,124 @@
+package graph
+
+import (
+	"math"
+	"sync"
+)
+
+// Graph is a directed graph over nodes 0..N-1, Out holds the targets of each node
+type Graph struct {
+	Out [][]int
+}
+
+func (g Graph) Len() int {
+	return len(g.Out)
+}
+
+// InDegrees counts the edges pointing at each node
+func (g Graph) InDegrees() []int {
+	in := make([]int, g.Len())
+	for _, targets := range g.Out {
+		for _, t := range targets {
+			in[t]++
+		}
+	}
+	return in
+}
+
+// Options of the power iteration, zero values fall back to the usual defaults
+type Options struct {
+	Damping       float64
+	MaxIterations int
+	Tolerance     float64
+	Workers       int
+}
+
+func (o Options) withDefaults() Options {
+	if o.Damping <= 0 || o.Damping >= 1 {
+		o.Damping = 0.85
+	}
+	if o.MaxIterations <= 0 {
+		o.MaxIterations = 100
+	}
+	if o.Tolerance <= 0 {
+		o.Tolerance = 1e-6
+	}
+	if o.Workers <= 0 {
+		o.Workers = 1
+	}
+	return o
+}
+
+// PageRank runs the power iteration with the nodes split into one chunk per worker.
+// Each iteration pulls rank over the incoming edges, so workers only write their own chunk
+// and need no locks. Rank of dangling nodes is spread evenly over all nodes.
+// It returns the ranks, which sum to 1, and the number of iterations done.
+func PageRank(g Graph, opts Options) ([]float64, int) {
+	n := g.Len()
+	if n == 0 {
+		return nil, 0
+	}
+	opts = opts.withDefaults()
+
+	in := make([][]int, n)
+	for from, targets := range g.Out {
+		for _, to := range targets {
+			in[to] = append(in[to], from)
+		}
+	}
+
+	rank := make([]float64, n)
+	next := make([]float64, n)
+	for i := range rank {
+		rank[i] = 1 / float64(n)
+	}
+
+	workers := min(opts.Workers, n)
+	chunk := (n + workers - 1) / workers
+	deltas := make([]float64, workers)
+
+	iteration := 0
+	for iteration < opts.MaxIterations {
+		iteration++
+
+		dangling := 0.0
+		for i, targets := range g.Out {
+			if len(targets) == 0 {
+				dangling += rank[i]
+			}
+		}
+		base := (1-opts.Damping)/float64(n) + opts.Damping*dangling/float64(n)
+
+		var wg sync.WaitGroup
+		for w := 0; w < workers; w++ {
+			wg.Add(1)
+			go func(w int) {
+				defer wg.Done()
+
+				delta := 0.0
+				for v := w * chunk; v < min((w+1)*chunk, n); v++ {
+					sum := 0.0
+					for _, u := range in[v] {
+						sum += rank[u] / float64(len(g.Out[u]))
+					}
+					next[v] = base + opts.Damping*sum
+					delta += math.Abs(next[v] - rank[v])
+				}
+				deltas[w] = delta
+			}(w)
+		}
+		wg.Wait()
+
+		rank, next = next, rank
+
+		total := 0.0
+		for _, d := range deltas {
+			total += d
+		}
+		if total < opts.Tolerance {
+			break
+		}
+	}
+
+	return rank, iteration
+}
@@ -0,0 +1,86 @@
+package graph
+
+import (
+	"math"
+	"testing"
+)
+
+func TestPageRank(t *testing.T) {
+	tests := []struct {
+		name  string
+		graph Graph
+		check func(t *testing.T, rank []float64)
+	}{
+		{"cycle ranks evenly", Graph{Out: [][]int{{1}, {2}, {0}}}, func(t *testing.T, rank []float64) {
+			for _, r := range rank {
+				if math.Abs(r-1.0/3) > 1e-6 {
+					t.Errorf("ranks = %v, want 1/3 each", rank)
+					return
+				}
+			}
+		}},
+		{"star center wins", Graph{Out: [][]int{{}, {0}, {0}, {0}}}, func(t *testing.T, rank []float64) {
+			if rank[0] <= rank[1] || rank[1] != rank[2] || rank[2] != rank[3] {
+				t.Errorf("ranks = %v, want the center first and the leaves equal", rank)
+			}
+		}},
+		{"dangling node keeps rank in the graph", Graph{Out: [][]int{{1}, {}}}, func(t *testing.T, rank []float64) {
+			if rank[1] <= rank[0] {
+				t.Errorf("ranks = %v, want the linked node first", rank)
+			}
+		}},
+		{"isolated nodes", Graph{Out: [][]int{{}, {}}}, func(t *testing.T, rank []float64) {
+			if rank[0] != rank[1] {
+				t.Errorf("ranks = %v, want them equal", rank)
+			}
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rank, iterations := PageRank(tt.graph, Options{})
+			if iterations < 1 || len(rank) != tt.graph.Len() {
+				t.Fatalf("%d ranks after %d iterations", len(rank), iterations)
+			}
+			sum := 0.0
+			for _, r := range rank {
+				sum += r
+			}
+			if math.Abs(sum-1) > 1e-6 {
+				t.Errorf("ranks sum to %f", sum)
+			}
+			tt.check(t, rank)
+		})
+	}
+}
+
+func TestPageRankWorkers(t *testing.T) {
+	// a ring with chords, big enough to split into several chunks
+	g := Graph{Out: make([][]int, 101)}
+	for i := range g.Out {
+		g.Out[i] = []int{(i + 1) % 101, (i * 7) % 101}
+	}
+	g.Out[50] = nil
+
+	single, _ := PageRank(g, Options{Workers: 1})
+	parallel, _ := PageRank(g, Options{Workers: 8})
+	for i := range single {
+		if math.Abs(single[i]-parallel[i]) > 1e-12 {
+			t.Fatalf("node %d: %f with one worker, %f with eight", i, single[i], parallel[i])
+		}
+	}
+
+	if rank, iterations := PageRank(Graph{}, Options{}); rank != nil || iterations != 0 {
+		t.Errorf("empty graph: %v after %d iterations", rank, iterations)
+	}
+	if _, iterations := PageRank(g, Options{MaxIterations: 3, Tolerance: 1e-300}); iterations != 3 {
+		t.Errorf("%d iterations, want the cap of 3", iterations)
+	}
+}
+
+func TestInDegrees(t *testing.T) {
+	in := Graph{Out: [][]int{{1, 2}, {2}, {}}}.InDegrees()
+	if len(in) != 3 || in[0] != 0 || in[1] != 1 || in[2] != 2 {
+		t.Errorf("InDegrees = %v", in)
+	}
+}
@@ -1,44 +1,176 @@
 package handlers
 
 import (
-	"exercise3/config"
+	"context"
 	"exercise3/crawler"
-	"exercise3/models"
 	"fmt"
 	"github.com/gofiber/fiber/v2"
-	"sync"
+	"time"
 )
 
-func CrawlHandler(c *fiber.Ctx) error {
-	type Requests struct {
-		URLs []string `json:"urls"`
+// CrawlResult is the per-URL outcome returned to the API caller
+type CrawlResult struct {
+	PageID     uint   `json:"page_id,omitempty"`
+	Version    int    `json:"version,omitempty"`
+	URL        string `json:"url"`
+	Status     string `json:"status"`
+	StatusCode int    `json:"status_code,omitempty"`
+	Type       string `json:"content_type,omitempty"`
+	Bytes      int64  `json:"bytes"`
+	Elements   int    `json:"elements"`
+	BytesSaved int64  `json:"bytes_saved,omitempty"`
+	Attempts   int    `json:"attempts"`
+	Error      string `json:"error,omitempty"`
+	LastError  string `json:"last_error,omitempty"`
+}
+
+// crawlRequest is the body of the crawl endpoints
+type crawlRequest struct {
+	URLs  []string `json:"urls"`
+	JobID string   `json:"job_id"`
+	OptionsRequest
+}
+
+func (h *Handler) CrawlHandler(c *fiber.Ctx) error {
+	req, opts, timeout, err := parseCrawlRequest(c)
+	if err != nil {
+		return err
 	}
 
-	var req Requests
-	if err := c.BodyParser(&req); err != nil {
-		return fiber.NewError(fiber.StatusBadRequest, "Invalid request body.")
-	}
-
-	var wg sync.WaitGroup
-	for _, url := range req.URLs {
-		wg.Add(1)
-		go func(url string) {
-			defer wg.Done()
-			results := crawler.Crawl([]string{url})
-			for _, r := range results {
-				page := models.CrawlPage{
-					URL:      r.URL,
-					Elements: r.Elements,
-				}
-				if err := config.DB.Create(&page).Error; err != nil {
-					fmt.Println("DB error:", err)
-				}
-			}
-		}(url)
-	}
-	wg.Wait()
+	if err := h.useValidators(c.Context(), req.OptionsRequest, req.URLs, &opts); err != nil {
+		return err
+	}
+
+	ctx, job, err := h.startJob(c.Context(), req.JobID, len(req.URLs), timeout)
+	if err != nil {
+		return err
+	}
+	defer h.finishJob(job)
+	job.watchClient(c)
+
+	pages := crawler.DefaultPipeline.Run(ctx, req.URLs, opts, h.savePages, nil)
+
+	results := make([]CrawlResult, len(pages))
+	for i, r := range pages {
+		results[i] = newCrawlResult(r)
+	}
 
 	return c.JSON(fiber.Map{
-		"message": "Crawl completed.",
+		"message":     "Crawl completed.",
+		"job_id":      job.ID,
+		"total":       len(results),
+		"failed":      countFailed(results),
+		"bytes_saved": bytesSaved(results),
+		"results":     results,
 	})
 }
+
+// parseCrawlRequest reads the request body into crawl options on top of the crawler defaults
+func parseCrawlRequest(c *fiber.Ctx) (crawlRequest, crawler.Options, time.Duration, error) {
+	var req crawlRequest
+	if err := c.BodyParser(&req); err != nil {
+		return req, crawler.Options{}, 0, fiber.NewError(fiber.StatusBadRequest, "Invalid request body.")
+	}
+
+	req.URLs = normalizeSeeds(req.URLs)
+	opts, timeout, err := req.build()
+	return req, opts, timeout, err
+}
+
+// normalizeSeeds normalizes the urls and drops the repeated ones, as the sitemap seeds are.
+// A url that doesn't normalize is kept as given, its crawl reports why it fails.
+func normalizeSeeds(urls []string) []string {
+	seen := make(map[string]bool, len(urls))
+	seeds := make([]string, 0, len(urls))
+	for _, url := range urls {
+		if normalized, err := crawler.NormalizeURL(url); err == nil {
+			url = normalized
+		}
+		if seen[url] {
+			continue
+		}
+		seen[url] = true
+		seeds = append(seeds, url)
+	}
+	return seeds
+}
+
+func newCrawlResult(r crawler.PageResult) CrawlResult {
+	return CrawlResult{
+		PageID:     r.PageID,
+		Version:    r.Version,
+		URL:        r.URL,
+		Status:     r.Status,
+		StatusCode: r.StatusCode,
+		Type:       r.ContentType,
+		Bytes:      r.Bytes,
+		Elements:   len(r.Elements),
+		BytesSaved: r.BytesSaved,
+		Attempts:   r.Attempts,
+		Error:      r.Error,
+		LastError:  r.LastError,
+	}
+}
+
+func countFailed(results []CrawlResult) int {
+	failed := 0
+	for _, r := range results {
+		if r.Status != crawler.StatusSuccess && r.Status != crawler.StatusNotModified {
+			failed++
+		}
+	}
+	return failed
+}
+
+func bytesSaved(results []CrawlResult) int64 {
+	var saved int64
+	for _, r := range results {
+		saved += r.BytesSaved
+	}
+	return saved
+}
+
+// useValidators makes the fetches of already stored pages conditional, unless the request opted out
+func (h *Handler) useValidators(ctx context.Context, req OptionsRequest, urls []string, opts *crawler.Options) error {
+	if req.NoCache {
+		return nil
+	}
+
+	validators, err := h.loadValidators(ctx, urls, opts.Extraction)
+	if err != nil {
+		fmt.Println("DB error:", err)
+		return fiber.NewError(fiber.StatusInternalServerError, "Pages can not be loaded.")
+	}
+	opts.Validators = validators
+	return nil
+}
+
+// loadValidators returns the stored validators of the urls, only pages with a stored version qualify
+// since a 304 leaves nothing else to fall back on. A version extracted with other rules doesn't either,
+// the page has to be fetched again to apply the spec.
+func (h *Handler) loadValidators(ctx context.Context, urls []string, spec crawler.ExtractionSpec) (map[string]crawler.Validator, error) {
+	pages, err := h.Store.PagesByURL(ctx, urls)
+	if err != nil {
+		return nil, err
+	}
+
+	hash := spec.Hash()
+	validators := make(map[string]crawler.Validator)
+	for url, page := range pages {
+		if page.LatestVersion == 0 || page.ExtractionHash != hash || (page.ETag == "" && page.LastModified == "") {
+			continue
+		}
+		validators[url] = crawler.Validator{
+			ETag:         page.ETag,
+			LastModified: page.LastModified,
+			ContentType:  page.ContentType,
+			Bytes:        page.Bytes,
+		}
+	}
+	return validators, nil
+}
+
+// CrawlStatsHandler exposes the queueing metrics of the shared crawl pool
+func (h *Handler) CrawlStatsHandler(c *fiber.Ctx) error {
+	return c.JSON(crawler.DefaultPool.Stats())
+}
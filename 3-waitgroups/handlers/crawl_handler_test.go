@@ -0,0 +1,468 @@
+package handlers
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"exercise3/crawler"
+	"exercise3/models"
+	"exercise3/storage"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+type crawlResponse struct {
+	JobID      string        `json:"job_id"`
+	Total      int           `json:"total"`
+	Failed     int           `json:"failed"`
+	BytesSaved int64         `json:"bytes_saved"`
+	Results    []CrawlResult `json:"results"`
+}
+
+// fastRetry keeps the error fixtures from slowing the suite down
+var fastRetry = map[string]any{"max_attempts": 2, "base_delay": "1ms", "max_delay": "5ms"}
+
+func TestCrawlHandlerStoresPages(t *testing.T) {
+	site := newFixtureSite(t)
+	h, app := newTestApp()
+
+	tests := []struct {
+		path   string
+		status string
+		code   int
+	}{
+		{"/", crawler.StatusSuccess, 200},
+		{"/a", crawler.StatusSuccess, 200},
+		{"/b", crawler.StatusSuccess, 200},
+		{"/error", crawler.StatusHTTPError, 500},
+		{"/huge", crawler.StatusTooLarge, 200},
+		{"/data.json", crawler.StatusSkipped, 200},
+		{"/private/page", crawler.StatusBlockedByRobots, 0},
+	}
+
+	urls := make([]string, len(tests))
+	for i, tt := range tests {
+		urls[i] = site.URL + tt.path
+	}
+
+	var resp crawlResponse
+	status := doJSON(t, app, "POST", "/crawl", map[string]any{
+		"urls":           urls,
+		"retry":          fastRetry,
+		"max_body_bytes": 16 << 10,
+	}, &resp)
+	if status != http.StatusOK {
+		t.Fatalf("POST /crawl = %d", status)
+	}
+	if resp.Total != len(tests) || resp.Failed != 4 || resp.JobID == "" {
+		t.Errorf("got total %d, failed %d, job %q", resp.Total, resp.Failed, resp.JobID)
+	}
+
+	stored, err := h.Store.PagesByURL(context.Background(), urls)
+	if err != nil {
+		t.Fatalf("PagesByURL: %v", err)
+	}
+
+	for i, tt := range tests {
+		r := resp.Results[i]
+		if r.URL != urls[i] || r.Status != tt.status || r.StatusCode != tt.code {
+			t.Errorf("%s: got %s %q (%d), want %q (%d)", tt.path, r.URL, r.Status, r.StatusCode, tt.status, tt.code)
+		}
+
+		// every outcome is stored, only successes get a version
+		page := stored[urls[i]]
+		if r.PageID == 0 || page.ID != r.PageID || page.Status != tt.status {
+			t.Errorf("%s: stored page %d with status %q, result has page %d", tt.path, page.ID, page.Status, r.PageID)
+		}
+		if wantVersion := tt.status == crawler.StatusSuccess; (r.Version == 1) != wantVersion {
+			t.Errorf("%s: version %d", tt.path, r.Version)
+		}
+		if tt.status != crawler.StatusSuccess && (r.Error == "" || page.Error != r.Error) {
+			t.Errorf("%s: error %q, stored %q", tt.path, r.Error, page.Error)
+		}
+	}
+
+	failed := resp.Results[3]
+	if failed.Attempts != 2 || !strings.Contains(failed.LastError, "500 Internal Server Error") {
+		t.Errorf("/error: %d attempts, last error %q", failed.Attempts, failed.LastError)
+	}
+
+	var page struct {
+		Page     models.CrawlPage `json:"page"`
+		Elements []models.Element `json:"elements"`
+	}
+	if status := doJSON(t, app, "GET", fmt.Sprintf("/pages/%d", resp.Results[0].PageID), nil, &page); status != http.StatusOK {
+		t.Fatalf("GET /pages/:id = %d", status)
+	}
+	var got []string
+	for _, e := range page.Elements {
+		got = append(got, e.ElementType+":"+e.Content)
+	}
+	if want := "h1:Home p:Welcome a:Go to A"; strings.Join(got, " ") != want {
+		t.Errorf("elements = %q, want %q", strings.Join(got, " "), want)
+	}
+}
+
+func TestCrawlHandlerRecrawl(t *testing.T) {
+	site := newFixtureSite(t)
+	h, app := newTestApp()
+	body := map[string]any{"urls": []string{site.URL + "/news"}}
+
+	var first, second, third crawlResponse
+	doJSON(t, app, "POST", "/crawl", body, &first)
+	if r := first.Results[0]; r.Status != crawler.StatusSuccess || r.Version != 1 {
+		t.Fatalf("first crawl: %+v", r)
+	}
+
+	// the stored ETag makes the re-crawl conditional, the server answers 304
+	doJSON(t, app, "POST", "/crawl", body, &second)
+	r := second.Results[0]
+	if r.Status != crawler.StatusNotModified || r.Version != 1 || r.BytesSaved != first.Results[0].Bytes {
+		t.Errorf("second crawl: %+v", r)
+	}
+	if second.Failed != 0 || second.BytesSaved != r.BytesSaved {
+		t.Errorf("second crawl: failed %d, bytes saved %d", second.Failed, second.BytesSaved)
+	}
+
+	// new content is a new version, the diff shows the changed paragraph
+	site.Publish()
+	doJSON(t, app, "POST", "/crawl", body, &third)
+	if r := third.Results[0]; r.Status != crawler.StatusSuccess || r.Version != 2 {
+		t.Fatalf("third crawl: %+v", r)
+	}
+
+	var diff struct {
+		Diff models.ElementDiff `json:"diff"`
+	}
+	doJSON(t, app, "GET", fmt.Sprintf("/pages/%d/diff", r.PageID), nil, &diff)
+	if len(diff.Diff.Added) != 1 || diff.Diff.Added[0].Content != "Edition 2" ||
+		len(diff.Diff.Removed) != 1 || diff.Diff.Unchanged != 2 {
+		t.Errorf("diff = %+v", diff.Diff)
+	}
+
+	versions, err := h.Store.ListVersions(context.Background(), r.PageID)
+	if err != nil || len(versions) != 2 || !versions[0].IsLatest || versions[1].IsLatest {
+		t.Errorf("versions = %+v, err %v", versions, err)
+	}
+}
+
+func TestCrawlHandlerFailedRecrawl(t *testing.T) {
+	site := newFixtureSite(t)
+	h, app := newTestApp()
+	body := map[string]any{"urls": []string{site.URL + "/news"}, "retry": map[string]any{"max_attempts": 1}}
+
+	var first, second, third crawlResponse
+	doJSON(t, app, "POST", "/crawl", body, &first)
+	stored, err := h.Store.GetPage(context.Background(), first.Results[0].PageID)
+	if err != nil || stored.ETag != `"edition-1"` {
+		t.Fatalf("page after the first crawl = %+v, err %v", stored, err)
+	}
+
+	// the failed crawl is recorded, what the last good one learned about the page stays
+	site.TakeDown(true)
+	doJSON(t, app, "POST", "/crawl", body, &second)
+	if r := second.Results[0]; r.Status != crawler.StatusHTTPError {
+		t.Fatalf("crawl while down: %+v", r)
+	}
+	page, err := h.Store.GetPage(context.Background(), stored.ID)
+	if err != nil || page.Status != crawler.StatusHTTPError || page.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("page after the failed crawl = %+v, err %v", page, err)
+	}
+	if page.ETag != stored.ETag || page.ContentType != stored.ContentType || page.Bytes != stored.Bytes ||
+		!page.LastCrawledAt.Equal(stored.LastCrawledAt) {
+		t.Errorf("failed crawl overwrote the page: %+v, was %+v", page, stored)
+	}
+
+	// the next crawl is still conditional
+	site.TakeDown(false)
+	doJSON(t, app, "POST", "/crawl", body, &third)
+	if r := third.Results[0]; r.Status != crawler.StatusNotModified || r.Version != 1 || r.BytesSaved != stored.Bytes {
+		t.Errorf("crawl after the outage: %+v", r)
+	}
+}
+
+func TestCrawlHandlerRecrawlWithOtherRules(t *testing.T) {
+	site := newFixtureSite(t)
+	h, app := newTestApp()
+	urls := []string{site.URL + "/news"}
+	headings := map[string]any{"urls": urls, "extract": map[string]any{
+		"rules": []map[string]any{{"name": "heading", "selector": "h1"}},
+	}}
+
+	var first, second, third crawlResponse
+	doJSON(t, app, "POST", "/crawl", map[string]any{"urls": urls}, &first)
+
+	// the page didn't change but the rules did, the stored ETag isn't sent and the new elements are a new version
+	doJSON(t, app, "POST", "/crawl", headings, &second)
+	r := second.Results[0]
+	if r.Status != crawler.StatusSuccess || r.Version != 2 || r.Elements != 1 {
+		t.Fatalf("crawl with other rules: %+v", r)
+	}
+
+	elements, err := h.Store.VersionElements(context.Background(), r.PageID, 2)
+	if err != nil || len(elements) != 1 || elements[0].Field != "heading" || elements[0].Content != "News" {
+		t.Errorf("stored elements = %+v, err %v", elements, err)
+	}
+
+	// with the same rules again the re-crawl is conditional
+	doJSON(t, app, "POST", "/crawl", headings, &third)
+	if r := third.Results[0]; r.Status != crawler.StatusNotModified || r.Version != 2 {
+		t.Errorf("crawl with the same rules: %+v", r)
+	}
+}
+
+func TestCrawlHandlerLinkGraph(t *testing.T) {
+	site := newFixtureSite(t)
+	h, app := newTestApp()
+
+	urls := []string{site.URL + "/", site.URL + "/a", site.URL + "/b"}
+	doJSON(t, app, "POST", "/crawl", map[string]any{"urls": urls}, nil)
+
+	summary, err := h.ComputePageRank(context.Background())
+	if err != nil {
+		t.Fatalf("ComputePageRank: %v", err)
+	}
+	// / -> a, a -> b, a -> /, b -> a, b -> b
+	if summary.Pages != 3 || summary.Edges != 5 {
+		t.Errorf("got %d pages and %d edges, want 3 and 5", summary.Pages, summary.Edges)
+	}
+
+	var inbound struct {
+		Total int64 `json:"total"`
+	}
+	doJSON(t, app, "GET", "/graph/inbound?url="+site.URL+"/a", nil, &inbound)
+	if inbound.Total != 2 {
+		t.Errorf("inbound links of /a = %d, want 2", inbound.Total)
+	}
+
+	var top struct {
+		Items []models.PageRank `json:"items"`
+	}
+	doJSON(t, app, "GET", "/graph/top", nil, &top)
+	if len(top.Items) != 3 || top.Items[0].URL != site.URL+"/a" {
+		t.Errorf("top pages = %+v, want /a first", top.Items)
+	}
+}
+
+func TestCrawlHandlerDuplicates(t *testing.T) {
+	site := newFixtureSite(t)
+	_, app := newTestApp()
+
+	urls := []string{site.URL + "/article", site.URL + "/", site.URL + "/article/print"}
+	doJSON(t, app, "POST", "/crawl", map[string]any{"urls": urls}, nil)
+
+	var summary DuplicateSummary
+	if status := doJSON(t, app, "POST", "/duplicates/detect", nil, &summary); status != http.StatusOK {
+		t.Fatalf("POST /duplicates/detect = %d", status)
+	}
+	if summary.Pages != 3 || summary.Clusters != 1 || summary.Duplicates != 1 {
+		t.Errorf("summary = %+v", summary)
+	}
+
+	var clusters struct {
+		Total int64                      `json:"total"`
+		Items []storage.DuplicateCluster `json:"items"`
+	}
+	doJSON(t, app, "GET", "/duplicates", nil, &clusters)
+	if clusters.Total != 1 || len(clusters.Items) != 1 {
+		t.Fatalf("clusters = %+v", clusters)
+	}
+	cluster := clusters.Items[0]
+	if cluster.URL != site.URL+"/article" || len(cluster.Duplicates) != 1 ||
+		cluster.Duplicates[0].URL != site.URL+"/article/print" || cluster.Duplicates[0].Distance > DuplicateDistance {
+		t.Errorf("cluster = %+v", cluster)
+	}
+
+	var print struct {
+		Page models.CrawlPage `json:"page"`
+	}
+	doJSON(t, app, "GET", fmt.Sprintf("/pages/%d", cluster.Duplicates[0].PageID), nil, &print)
+	if print.Page.DuplicateOf != cluster.PageID || print.Page.SimHash == 0 {
+		t.Errorf("/article/print stored as duplicate of %d with SimHash %x", print.Page.DuplicateOf, print.Page.SimHash)
+	}
+
+	// only identical text is a duplicate at distance 0, the print footer isn't
+	doJSON(t, app, "POST", "/duplicates/detect?distance=0", nil, &summary)
+	doJSON(t, app, "GET", "/duplicates", nil, &clusters)
+	if summary.Clusters != 0 || clusters.Total != 0 || len(clusters.Items) != 0 {
+		t.Errorf("at distance 0: summary %+v, clusters %+v", summary, clusters)
+	}
+
+	if status := doJSON(t, app, "POST", "/duplicates/detect?distance=64", nil, nil); status != http.StatusBadRequest {
+		t.Errorf("distance 64: status %d, want 400", status)
+	}
+}
+
+func TestLinkCheckHandler(t *testing.T) {
+	site := newFixtureSite(t)
+	_, app := newTestApp()
+	body := map[string]any{"urls": []string{site.URL + "/links", site.URL + "/error"}, "retry": fastRetry}
+
+	var report struct {
+		Total  int                  `json:"total"`
+		Links  int                  `json:"links"`
+		Broken int                  `json:"broken"`
+		Pages  []crawler.LinkReport `json:"pages"`
+	}
+	if status := doJSON(t, app, "POST", "/crawl/links", body, &report); status != http.StatusOK {
+		t.Fatalf("POST /crawl/links = %d", status)
+	}
+	if report.Total != 2 || report.Links != 3 || report.Broken != 2 {
+		t.Errorf("report = %+v", report)
+	}
+	if page := report.Pages[1]; page.Status != crawler.StatusHTTPError || page.Links != 0 {
+		t.Errorf("/error reported as %+v", page)
+	}
+	broken := report.Pages[0].Broken
+	if len(broken) != 2 || broken[0].URL != site.URL+"/gone" || broken[0].StatusCode != http.StatusNotFound ||
+		broken[1].Type != "img" || broken[1].StatusCode != http.StatusInternalServerError {
+		t.Errorf("broken links of /links = %+v", broken)
+	}
+
+	raw, _ := json.Marshal(body)
+	req := httptest.NewRequest("POST", "/crawl/links?format=csv", strings.NewReader(string(raw)))
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := app.Test(req, -1)
+	if err != nil {
+		t.Fatalf("POST /crawl/links?format=csv: %v", err)
+	}
+	defer resp.Body.Close()
+
+	rows, err := csv.NewReader(resp.Body).ReadAll()
+	if err != nil || resp.Header.Get("Content-Type") != "text/csv; charset=utf-8" {
+		t.Fatalf("CSV report: %v, content type %q", err, resp.Header.Get("Content-Type"))
+	}
+	if len(rows) != 3 || rows[0][0] != "page_url" || rows[1][0] != site.URL+"/links" || rows[1][1] != site.URL+"/gone" || rows[1][5] != "404" {
+		t.Errorf("CSV rows = %q", rows)
+	}
+
+	if status := doJSON(t, app, "POST", "/crawl/links?format=xml", body, nil); status != http.StatusBadRequest {
+		t.Errorf("format xml: status %d, want 400", status)
+	}
+}
+
+func TestCrawlHandlerTimeout(t *testing.T) {
+	site := newFixtureSite(t)
+	_, app := newTestApp()
+
+	var resp crawlResponse
+	doJSON(t, app, "POST", "/crawl", map[string]any{
+		"urls":    []string{site.URL + "/slow", site.URL + "/a"},
+		"timeout": "100ms",
+		"job_id":  "short",
+	}, &resp)
+
+	if resp.JobID != "short" || resp.Results[0].Status != crawler.StatusCanceled {
+		t.Errorf("got job %q with %+v", resp.JobID, resp.Results[0])
+	}
+
+	var jobs []map[string]any
+	doJSON(t, app, "GET", "/crawl/jobs", nil, &jobs)
+	if len(jobs) != 0 {
+		t.Errorf("finished job still listed: %v", jobs)
+	}
+}
+
+func TestCrawlHandlerNormalizesSeeds(t *testing.T) {
+	site := newFixtureSite(t)
+	_, app := newTestApp()
+
+	var resp crawlResponse
+	doJSON(t, app, "POST", "/crawl", map[string]any{
+		"urls": []string{site.URL + "/a#top", site.URL + "/./a", strings.ToUpper(site.URL[:4]) + site.URL[4:] + "/b", "mailto:someone@example.com"},
+	}, &resp)
+
+	if resp.Total != 3 || resp.Results[0].URL != site.URL+"/a" || resp.Results[1].URL != site.URL+"/b" ||
+		resp.Results[2].URL != "mailto:someone@example.com" || resp.Results[2].Status == crawler.StatusSuccess {
+		t.Errorf("got %+v", resp.Results)
+	}
+}
+
+func TestCrawlHandlerClientDisconnect(t *testing.T) {
+	site := newFixtureSite(t)
+	h, app := newTestApp()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	go app.Listener(listener)
+	defer app.Shutdown()
+
+	// a crawl on a kept-alive connection answers, and the connection serves the next request
+	client := &http.Client{}
+	for i := 0; i < 2; i++ {
+		body := fmt.Sprintf(`{"urls": [%q]}`, site.URL+"/a")
+		resp, err := client.Post("http://"+listener.Addr().String()+"/crawl", "application/json", strings.NewReader(body))
+		if err != nil {
+			t.Fatalf("crawl %d: %v", i, err)
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("crawl %d answered %d", i, resp.StatusCode)
+		}
+	}
+
+	// a client that hangs up cancels its crawl long before /slow answers
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	body := fmt.Sprintf(`{"urls": [%q], "job_id": "gone"}`, site.URL+"/slow")
+	fmt.Fprintf(conn, "POST /crawl HTTP/1.1\r\nHost: test\r\nContent-Type: application/json\r\nContent-Length: %d\r\n\r\n%s", len(body), body)
+
+	running := func() bool {
+		h.jobs.Lock()
+		defer h.jobs.Unlock()
+		_, ok := h.jobs.running["gone"]
+		return ok
+	}
+	for start := time.Now(); !running(); time.Sleep(5 * time.Millisecond) {
+		if time.Since(start) > time.Second {
+			t.Fatal("the crawl never started")
+		}
+	}
+
+	start := time.Now()
+	conn.Close()
+	for running() {
+		if time.Since(start) > time.Second {
+			t.Fatal("the crawl kept running after the client left")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestCrawlHandlerRejectsBadRequests(t *testing.T) {
+	_, app := newTestApp()
+
+	tests := []struct {
+		name string
+		body any
+	}{
+		{"not an object", "urls"},
+		{"bad timeout", map[string]any{"urls": []string{"http://example.com"}, "timeout": "soon"}},
+		{"bad retry delay", map[string]any{"urls": []string{"http://example.com"}, "retry": map[string]any{"base_delay": "x"}}},
+		{"no retry attempts", map[string]any{"urls": []string{"http://example.com"}, "retry": map[string]any{"max_attempts": 0}}},
+		{"too many retry attempts", map[string]any{"urls": []string{"http://example.com"}, "retry": map[string]any{"max_attempts": 1000}}},
+		{"negative retry delay", map[string]any{"urls": []string{"http://example.com"}, "retry": map[string]any{"base_delay": "-1s"}}},
+		{"retry delay too long", map[string]any{"urls": []string{"http://example.com"}, "retry": map[string]any{"max_delay": "24h"}}},
+		{"bad selector", map[string]any{"urls": []string{"http://example.com"}, "extract": map[string]any{
+			"rules": []map[string]any{{"selector": "a["}},
+		}}},
+		{"bad client profile", map[string]any{"urls": []string{"http://example.com"}, "client": map[string]any{"proxy": "ftp://proxy:21"}}},
+	}
+
+	for _, tt := range tests {
+		if status := doJSON(t, app, "POST", "/crawl", tt.body, nil); status != http.StatusBadRequest {
+			t.Errorf("%s: status %d, want 400", tt.name, status)
+		}
+	}
+}
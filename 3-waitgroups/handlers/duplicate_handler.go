@@ -0,0 +1,146 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"exercise3/simhash"
+	"fmt"
+	"github.com/gofiber/fiber/v2"
+	"time"
+)
+
+const (
+	// DefaultDuplicateInterval is how often the background job looks for near-duplicates
+	DefaultDuplicateInterval = 15 * time.Minute
+
+	// MaxDuplicateDistance caps the distance, beyond it the bands of the SimHash index get too narrow
+	// to narrow anything down and unrelated pages start to match
+	MaxDuplicateDistance = 16
+)
+
+// DuplicateDistance is how many bits the SimHashes of two near-duplicate pages may differ in
+var DuplicateDistance = 3
+
+var ErrDuplicatesRunning = errors.New("duplicate detection already running")
+
+// DuplicateSummary describes one near-duplicate detection
+type DuplicateSummary struct {
+	Pages       int           `json:"pages"`
+	Clusters    int           `json:"clusters"`
+	Duplicates  int           `json:"duplicates"`
+	MaxDistance int           `json:"max_distance"`
+	Took        time.Duration `json:"took"`
+	ComputedAt  time.Time     `json:"computed_at"`
+}
+
+// DetectDuplicates clusters the crawled pages whose SimHashes are at most maxDistance bits apart
+// and flags every page of a cluster but the first as its duplicate
+func (h *Handler) DetectDuplicates(ctx context.Context, maxDistance int) (DuplicateSummary, error) {
+	if !h.duplicateMu.TryLock() {
+		return DuplicateSummary{}, ErrDuplicatesRunning
+	}
+	defer h.duplicateMu.Unlock()
+
+	start := time.Now()
+	pages, err := h.Store.Fingerprints(ctx)
+	if err != nil {
+		return DuplicateSummary{}, err
+	}
+
+	fingerprints := make([]uint64, len(pages))
+	for i, page := range pages {
+		fingerprints[i] = uint64(page.SimHash)
+	}
+
+	// pages come by id, so the first of a cluster is the page crawled first
+	clusters := simhash.Cluster(fingerprints, maxDistance)
+	duplicateOf := make(map[uint]uint)
+	for _, members := range clusters {
+		first := pages[members[0]].ID
+		for _, i := range members[1:] {
+			duplicateOf[pages[i].ID] = first
+		}
+	}
+
+	if err := h.Store.ReplaceDuplicates(ctx, duplicateOf); err != nil {
+		return DuplicateSummary{}, err
+	}
+
+	return DuplicateSummary{
+		Pages:       len(pages),
+		Clusters:    len(clusters),
+		Duplicates:  len(duplicateOf),
+		MaxDistance: maxDistance,
+		Took:        time.Since(start),
+		ComputedAt:  time.Now(),
+	}, nil
+}
+
+// StartDuplicateJob looks for near-duplicates in the background every interval until ctx is done,
+// a zero interval disables it
+func (h *Handler) StartDuplicateJob(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+
+	h.background.Add(1)
+	go func() {
+		defer h.background.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+
+			summary, err := h.DetectDuplicates(ctx, DuplicateDistance)
+			if err != nil {
+				if !errors.Is(err, ErrDuplicatesRunning) && ctx.Err() == nil {
+					fmt.Println("Duplicates error:", err)
+				}
+				continue
+			}
+			fmt.Printf("Duplicates: %d pages, %d clusters, %d duplicates in %s\n",
+				summary.Pages, summary.Clusters, summary.Duplicates, summary.Took)
+		}
+	}()
+}
+
+// DetectDuplicatesHandler runs the detection right away and returns the summary,
+// the distance query parameter overrides the configured one
+func (h *Handler) DetectDuplicatesHandler(c *fiber.Ctx) error {
+	distance := c.QueryInt("distance", DuplicateDistance)
+	if distance < 0 || distance > MaxDuplicateDistance {
+		return fiber.NewError(fiber.StatusBadRequest, fmt.Sprintf("Distance must be between 0 and %d.", MaxDuplicateDistance))
+	}
+
+	summary, err := h.DetectDuplicates(c.Context(), distance)
+	if errors.Is(err, ErrDuplicatesRunning) {
+		return fiber.NewError(fiber.StatusConflict, "Duplicates are already being detected.")
+	}
+	if err != nil {
+		fmt.Println("DB error:", err)
+		return fiber.NewError(fiber.StatusInternalServerError, "Duplicates can not be detected.")
+	}
+
+	return c.JSON(summary)
+}
+
+// ListDuplicatesHandler lists the clusters of the last detection, largest first
+func (h *Handler) ListDuplicatesHandler(c *fiber.Ctx) error {
+	window := pagination(c)
+	clusters, total, err := h.Store.DuplicateClusters(c.Context(), window)
+	if err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Duplicates can not be loaded.")
+	}
+
+	return c.JSON(fiber.Map{
+		"total":  total,
+		"limit":  window.Limit,
+		"offset": window.Offset,
+		"items":  clusters,
+	})
+}
@@ -0,0 +1,136 @@
+package handlers
+
+import (
+	"encoding/json"
+	"exercise3/crawler"
+	"exercise3/internal/testsite"
+	"exercise3/storage"
+	"fmt"
+	"github.com/gofiber/fiber/v2"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+// TestMain lifts the per-host rate limit, every fixture site is a single local host
+func TestMain(m *testing.M) {
+	crawler.UseLimiter(crawler.NewHostLimiter(crawler.Limit{Rate: 1000, Burst: 1000}, nil))
+
+	os.Exit(m.Run())
+}
+
+// fixtureSite adds to the shared test site two near-duplicate articles, /links, which has broken links,
+// a /huge page and /news, whose content and ETag change with Publish and which fails while it's down
+type fixtureSite struct {
+	*testsite.Site
+
+	edition atomic.Int32
+	down    atomic.Bool
+}
+
+func newFixtureSite(t *testing.T) *fixtureSite {
+	t.Helper()
+
+	site := &fixtureSite{Site: testsite.New(t)}
+	site.edition.Store(1)
+
+	site.Handle("/{$}", func(w http.ResponseWriter, r *http.Request) {
+		testsite.WriteHTML(w, `<html lang="en"><head><title>Home</title></head><body><h1>Home</h1><p>Welcome</p><a href="/a">Go to A</a></body></html>`)
+	})
+
+	// /article/print is /article with a print footer, a near-duplicate
+	site.Handle("/article", func(w http.ResponseWriter, r *http.Request) {
+		testsite.WriteHTML(w, "<html><body><h1>Article</h1>"+articleBody+"</body></html>")
+	})
+	site.Handle("/article/print", func(w http.ResponseWriter, r *http.Request) {
+		testsite.WriteHTML(w, "<html><body><h1>Article</h1>"+articleBody+"<p>Print this page</p></body></html>")
+	})
+
+	site.Handle("/links", func(w http.ResponseWriter, r *http.Request) {
+		testsite.WriteHTML(w, `<html><body><a href="/a">A</a><a href="/gone">Gone</a><img src="/error"></body></html>`)
+	})
+
+	site.Handle("/news", func(w http.ResponseWriter, r *http.Request) {
+		if site.down.Load() {
+			http.Error(w, "down for maintenance", http.StatusServiceUnavailable)
+			return
+		}
+		edition := site.edition.Load()
+		etag := fmt.Sprintf(`"edition-%d"`, edition)
+		w.Header().Set("ETag", etag)
+		if r.Header.Get("If-None-Match") == etag {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		testsite.WriteHTML(w, fmt.Sprintf(`<html><body><h1>News</h1><p>Edition %d</p><p>Weather</p></body></html>`, edition))
+	})
+
+	site.Handle("/huge", func(w http.ResponseWriter, r *http.Request) {
+		testsite.WriteHTML(w, "<html><body><p>"+strings.Repeat("x", 64<<10)+"</p></body></html>")
+	})
+
+	return site
+}
+
+const articleBody = `<p>Crawlers visit pages by following the links they find on pages they already fetched.</p>
+<p>A polite crawler keeps to the robots file of every host and spaces out its requests.</p>
+<p>Pages that changed since the last visit are stored as a new version with their elements.</p>
+<p>Near duplicate pages share almost all of their text and only differ in small details.</p>
+<p>Print views, tracking parameters and mirrored sections are the usual sources of them.</p>
+<p>Finding them keeps the index small and stops ranking from counting the same page twice.</p>`
+
+// Publish changes the content and the ETag of /news
+func (s *fixtureSite) Publish() {
+	s.edition.Add(1)
+}
+
+// TakeDown makes /news fail until it's brought back up
+func (s *fixtureSite) TakeDown(down bool) {
+	s.down.Store(down)
+}
+
+// newTestApp serves the whole API on top of an empty memory store
+func newTestApp() (*Handler, *fiber.App) {
+	h := New(storage.NewMemory())
+	app := fiber.New()
+	h.Register(app)
+	return h, app
+}
+
+// doJSON sends body to the app and decodes the response into out, it returns the status code
+func doJSON(t *testing.T, app *fiber.App, method, path string, body any, out any) int {
+	t.Helper()
+
+	var reader io.Reader
+	if body != nil {
+		raw, err := json.Marshal(body)
+		if err != nil {
+			t.Fatalf("encoding request: %v", err)
+		}
+		reader = strings.NewReader(string(raw))
+	}
+
+	req := httptest.NewRequest(method, path, reader)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := app.Test(req, -1)
+	if err != nil {
+		t.Fatalf("%s %s: %v", method, path, err)
+	}
+	defer resp.Body.Close()
+
+	raw, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("reading response: %v", err)
+	}
+	if out != nil && resp.StatusCode < 300 {
+		if err := json.Unmarshal(raw, out); err != nil {
+			t.Fatalf("decoding %s: %v", raw, err)
+		}
+	}
+	return resp.StatusCode
+}
@@ -0,0 +1,233 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"exercise3/crawler"
+	"exercise3/models"
+	"exercise3/storage"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// FrontierConfig tunes the frontier worker of a process
+type FrontierConfig struct {
+	// Owner names the worker on its leases, it has to be unique across processes
+	Owner string
+	// BatchSize URLs are leased at a time, zero disables the worker
+	BatchSize int
+	// LeaseTTL is how long a lease lasts without a heartbeat, the worker sends one every third of it
+	LeaseTTL time.Duration
+	// PollInterval is the wait after finding the frontier empty
+	PollInterval time.Duration
+	// MaxLeases abandons a URL leased that many times without being stored, released leases count too
+	MaxLeases int
+}
+
+var DefaultFrontierConfig = FrontierConfig{BatchSize: 20, LeaseTTL: time.Minute, PollInterval: 2 * time.Second, MaxLeases: 3}
+
+// StartFrontierWorker crawls the URLs of distributed jobs in the background until ctx is done,
+// the leases it holds then go back to the frontier. A zero batch size disables it.
+func (h *Handler) StartFrontierWorker(ctx context.Context, cfg FrontierConfig) {
+	if cfg.BatchSize <= 0 {
+		return
+	}
+	if cfg.Owner == "" {
+		cfg.Owner = workerName()
+	}
+
+	h.background.Add(1)
+	go func() {
+		defer h.background.Done()
+		h.runFrontierWorker(ctx, cfg)
+	}()
+}
+
+// workerName identifies this process on its leases
+func workerName() string {
+	host, _ := os.Hostname()
+	return fmt.Sprintf("%s-%d-%s", host, os.Getpid(), newJobID()[:6])
+}
+
+// runFrontierWorker crawls batch after batch until ctx is done, it waits PollInterval whenever there is nothing to lease
+func (h *Handler) runFrontierWorker(ctx context.Context, cfg FrontierConfig) {
+	for ctx.Err() == nil {
+		leased, err := h.crawlFrontierBatch(ctx, cfg)
+		if err != nil && ctx.Err() == nil {
+			fmt.Println("Frontier error:", err)
+		}
+		if leased > 0 && err == nil {
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+		case <-time.After(cfg.PollInterval):
+		}
+	}
+}
+
+// crawlFrontierBatch leases a batch and crawls it, it returns how many URLs it leased.
+// The URLs it couldn't store go back to the frontier for the next worker.
+func (h *Handler) crawlFrontierBatch(ctx context.Context, cfg FrontierConfig) (int, error) {
+	leases, err := h.Store.LeaseURLs(ctx, storage.LeaseRequest{
+		Owner:     cfg.Owner,
+		Limit:     cfg.BatchSize,
+		TTL:       cfg.LeaseTTL,
+		MaxLeases: cfg.MaxLeases,
+	})
+	if err != nil || len(leases) == 0 {
+		return 0, err
+	}
+
+	held := newLeaseSet(leases)
+	defer func() {
+		if rest := held.list(); len(rest) > 0 {
+			if err := h.Store.ReleaseLeases(context.WithoutCancel(ctx), rest); err != nil {
+				fmt.Println("DB error:", err)
+			}
+		}
+	}()
+
+	beat, stop := context.WithCancel(ctx)
+	defer stop()
+	go h.heartbeat(beat, cfg.LeaseTTL, held)
+
+	// a batch can span jobs, each is crawled with its own options
+	var jobIDs []string
+	byJob := make(map[string][]models.FrontierURL)
+	for _, lease := range leases {
+		if _, ok := byJob[lease.JobID]; !ok {
+			jobIDs = append(jobIDs, lease.JobID)
+		}
+		byJob[lease.JobID] = append(byJob[lease.JobID], lease)
+	}
+
+	for _, jobID := range jobIDs {
+		if err := h.crawlLeases(ctx, jobID, byJob[jobID], held); err != nil {
+			return len(leases), fmt.Errorf("job %s: %w", jobID, err)
+		}
+	}
+	return len(leases), nil
+}
+
+// crawlLeases crawls the leased URLs of one job with the job's options
+func (h *Handler) crawlLeases(ctx context.Context, jobID string, leases []models.FrontierURL, held *leaseSet) error {
+	job, err := h.Store.GetFrontierJob(ctx, jobID)
+	if err != nil {
+		return err
+	}
+
+	var req OptionsRequest
+	if len(job.Options) > 0 {
+		if err := json.Unmarshal(job.Options, &req); err != nil {
+			return err
+		}
+	}
+	opts, timeout, err := req.build()
+	if err != nil {
+		return err
+	}
+
+	urls := make([]string, len(leases))
+	byURL := make(map[string]models.FrontierURL, len(leases))
+	for i, lease := range leases {
+		urls[i] = lease.URL
+		byURL[lease.URL] = lease
+	}
+	if err := h.useValidators(ctx, req, urls, &opts); err != nil {
+		return err
+	}
+
+	if timeout <= 0 {
+		timeout = DefaultJobTimeout
+	}
+	jobCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	// a page that was stored, or whose lease was taken over, is no longer this worker's to give back
+	save := func(ctx context.Context, batch []*crawler.PageResult) error {
+		batchLeases := make([]models.FrontierURL, len(batch))
+		ids := make([]uint, len(batch))
+		for i, r := range batch {
+			batchLeases[i] = byURL[r.URL]
+			ids[i] = batchLeases[i].ID
+		}
+		if err := h.Store.SaveLeasedPages(ctx, batchLeases, batch); err != nil {
+			return err
+		}
+		held.drop(ids...)
+		return nil
+	}
+
+	crawler.DefaultPipeline.Run(jobCtx, urls, opts, func(ctx context.Context, batch []*crawler.PageResult) {
+		persistBatch(ctx, batch, save)
+	}, nil)
+	return nil
+}
+
+// heartbeat renews the held leases every third of ttl until ctx is done, leases taken over meanwhile are dropped
+func (h *Handler) heartbeat(ctx context.Context, ttl time.Duration, held *leaseSet) {
+	ticker := time.NewTicker(max(ttl/3, time.Millisecond))
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		lost, err := h.Store.RenewLeases(ctx, held.list(), ttl)
+		if err != nil {
+			if ctx.Err() == nil {
+				fmt.Println("DB error:", err)
+			}
+			continue
+		}
+		if len(lost) > 0 {
+			fmt.Printf("Frontier: %d leases were taken over\n", len(lost))
+			held.drop(lost...)
+		}
+	}
+}
+
+// leaseSet is the leases a worker holds and still has to store or give back
+type leaseSet struct {
+	mu     sync.Mutex
+	leases map[uint]models.FrontierURL
+}
+
+func newLeaseSet(leases []models.FrontierURL) *leaseSet {
+	s := &leaseSet{leases: make(map[uint]models.FrontierURL, len(leases))}
+	for _, lease := range leases {
+		s.leases[lease.ID] = lease
+	}
+	return s
+}
+
+func (s *leaseSet) list() []models.FrontierURL {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	list := make([]models.FrontierURL, 0, len(s.leases))
+	for _, lease := range s.leases {
+		list = append(list, lease)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].ID < list[j].ID
+	})
+	return list
+}
+
+func (s *leaseSet) drop(ids ...uint) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, id := range ids {
+		delete(s.leases, id)
+	}
+}
@@ -0,0 +1,61 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"exercise3/models"
+	"exercise3/storage"
+	"fmt"
+	"github.com/gofiber/fiber/v2"
+)
+
+// DistributedCrawlHandler puts the urls on the shared frontier and answers right away,
+// the frontier workers of every process on the database crawl them
+func (h *Handler) DistributedCrawlHandler(c *fiber.Ctx) error {
+	req, _, _, err := parseCrawlRequest(c)
+	if err != nil {
+		return err
+	}
+	if len(req.URLs) == 0 {
+		return fiber.NewError(fiber.StatusBadRequest, "At least one url is required.")
+	}
+
+	options, err := json.Marshal(req.OptionsRequest)
+	if err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "Invalid options.")
+	}
+
+	job := models.FrontierJob{ID: req.JobID, Options: options}
+	if job.ID == "" {
+		job.ID = newJobID()
+	}
+	if err := h.Store.CreateFrontierJob(c.Context(), &job, req.URLs); err != nil {
+		if errors.Is(err, storage.ErrConflict) {
+			return fiber.NewError(fiber.StatusConflict, "A crawl job with this id already exists.")
+		}
+		fmt.Println("DB error:", err)
+		return fiber.NewError(fiber.StatusInternalServerError, "Crawl job can not be queued.")
+	}
+
+	return c.Status(fiber.StatusAccepted).JSON(fiber.Map{
+		"message": "Crawl queued.",
+		"job_id":  job.ID,
+		"total":   job.Total,
+	})
+}
+
+// DistributedJobHandler reports how far the workers got with a distributed job
+func (h *Handler) DistributedJobHandler(c *fiber.Ctx) error {
+	progress, err := h.Store.FrontierProgress(c.Context(), c.Params("id"))
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			return fiber.NewError(fiber.StatusNotFound, "Crawl job not found.")
+		}
+		return fiber.NewError(fiber.StatusInternalServerError, "Crawl job can not be loaded.")
+	}
+
+	return c.JSON(struct {
+		storage.FrontierProgress
+		Finished bool `json:"finished"`
+	}{progress, progress.Finished()})
+}
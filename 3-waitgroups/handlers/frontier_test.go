@@ -0,0 +1,261 @@
+package handlers
+
+import (
+	"context"
+	"exercise3/crawler"
+	"exercise3/models"
+	"exercise3/storage"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+)
+
+type progressResponse struct {
+	storage.FrontierProgress
+	Finished bool `json:"finished"`
+}
+
+// drainFrontier runs a worker until it finds nothing left to lease
+func drainFrontier(t *testing.T, h *Handler, cfg FrontierConfig) {
+	t.Helper()
+
+	for {
+		leased, err := h.crawlFrontierBatch(context.Background(), cfg)
+		if err != nil {
+			t.Errorf("%s: %v", cfg.Owner, err)
+			return
+		}
+		if leased == 0 {
+			return
+		}
+	}
+}
+
+func TestDistributedCrawl(t *testing.T) {
+	site := newFixtureSite(t)
+	h, app := newTestApp()
+	// a second process on the same database
+	other := New(h.Store)
+
+	urls := []string{site.URL + "/", site.URL + "/a", site.URL + "/b", site.URL + "/error", site.URL + "/a"}
+	var queued struct {
+		JobID string `json:"job_id"`
+		Total int    `json:"total"`
+	}
+	status := doJSON(t, app, "POST", "/crawl/distributed", map[string]any{"urls": urls, "job_id": "shared", "retry": fastRetry}, &queued)
+	if status != http.StatusAccepted || queued.JobID != "shared" || queued.Total != 4 {
+		t.Fatalf("POST /crawl/distributed = %d, %+v", status, queued)
+	}
+
+	var progress progressResponse
+	doJSON(t, app, "GET", "/crawl/distributed/shared", nil, &progress)
+	if progress.Pending != 4 || progress.Finished {
+		t.Errorf("before the workers: %+v", progress)
+	}
+
+	var wg sync.WaitGroup
+	for owner, worker := range map[string]*Handler{"first": h, "second": other} {
+		wg.Add(1)
+		go func(owner string, worker *Handler) {
+			defer wg.Done()
+			drainFrontier(t, worker, FrontierConfig{Owner: owner, BatchSize: 1, LeaseTTL: time.Minute})
+		}(owner, worker)
+	}
+	wg.Wait()
+
+	doJSON(t, app, "GET", "/crawl/distributed/shared", nil, &progress)
+	if !progress.Finished || progress.Done != 4 || progress.Leased != 0 ||
+		progress.Statuses[crawler.StatusSuccess] != 3 || progress.Statuses[crawler.StatusHTTPError] != 1 {
+		t.Errorf("after the workers: %+v", progress)
+	}
+
+	stored, err := h.Store.PagesByURL(context.Background(), urls)
+	if err != nil || len(stored) != 4 {
+		t.Fatalf("stored %d pages, err %v", len(stored), err)
+	}
+	if page := stored[site.URL+"/a"]; page.Status != crawler.StatusSuccess || page.LatestVersion != 1 {
+		t.Errorf("/a stored as %+v", page)
+	}
+}
+
+func TestDistributedCrawlRejectsBadRequests(t *testing.T) {
+	_, app := newTestApp()
+
+	body := map[string]any{"urls": []string{"http://example.com"}, "job_id": "taken"}
+	if status := doJSON(t, app, "POST", "/crawl/distributed", body, nil); status != http.StatusAccepted {
+		t.Fatalf("first job: status %d", status)
+	}
+
+	tests := []struct {
+		name string
+		body any
+		want int
+	}{
+		{"no urls", map[string]any{"urls": []string{}}, http.StatusBadRequest},
+		{"bad options", map[string]any{"urls": []string{"http://example.com"}, "timeout": "soon"}, http.StatusBadRequest},
+		{"taken id", body, http.StatusConflict},
+	}
+	for _, tt := range tests {
+		if status := doJSON(t, app, "POST", "/crawl/distributed", tt.body, nil); status != tt.want {
+			t.Errorf("%s: status %d, want %d", tt.name, status, tt.want)
+		}
+	}
+
+	if status := doJSON(t, app, "GET", "/crawl/distributed/missing", nil, nil); status != http.StatusNotFound {
+		t.Errorf("unknown job: status %d, want 404", status)
+	}
+}
+
+func TestFrontierLeaseTakeover(t *testing.T) {
+	site := newFixtureSite(t)
+	h, _ := newTestApp()
+	ctx := context.Background()
+
+	job := models.FrontierJob{ID: "takeover"}
+	if err := h.Store.CreateFrontierJob(ctx, &job, []string{site.URL + "/a"}); err != nil {
+		t.Fatalf("CreateFrontierJob: %v", err)
+	}
+
+	// a worker leases the URL and hangs until its lease runs out
+	stale, err := h.Store.LeaseURLs(ctx, storage.LeaseRequest{Owner: "hung", Limit: 10, TTL: time.Millisecond})
+	if err != nil || len(stale) != 1 {
+		t.Fatalf("LeaseURLs = %v, %v", stale, err)
+	}
+	time.Sleep(10 * time.Millisecond)
+
+	if leased, err := h.crawlFrontierBatch(ctx, FrontierConfig{Owner: "healthy", BatchSize: 10, LeaseTTL: time.Minute}); leased != 1 || err != nil {
+		t.Fatalf("the expired lease wasn't taken over: %d leased, err %v", leased, err)
+	}
+
+	// the hung worker comes back, its heartbeat and its write are both fenced off
+	lost, err := h.Store.RenewLeases(ctx, stale, time.Minute)
+	if err != nil || len(lost) != 1 || lost[0] != stale[0].ID {
+		t.Errorf("RenewLeases = %v, %v", lost, err)
+	}
+	late := &crawler.PageResult{URL: site.URL + "/a", Status: crawler.StatusSuccess, ContentHash: "late", FetchedAt: time.Now()}
+	if err := h.Store.SaveLeasedPages(ctx, stale, []*crawler.PageResult{late}); err != nil {
+		t.Fatalf("SaveLeasedPages: %v", err)
+	}
+	if late.Status != crawler.StatusLeaseLost || late.PageID != 0 {
+		t.Errorf("late write: status %q, page %d", late.Status, late.PageID)
+	}
+
+	stored, _ := h.Store.PagesByURL(ctx, []string{site.URL + "/a"})
+	versions, err := h.Store.ListVersions(ctx, stored[site.URL+"/a"].ID)
+	if err != nil || len(versions) != 1 {
+		t.Errorf("got %d versions, want the one of the healthy worker", len(versions))
+	}
+
+	progress, _ := h.Store.FrontierProgress(ctx, "takeover")
+	if progress.Done != 1 || progress.Statuses[crawler.StatusSuccess] != 1 {
+		t.Errorf("progress = %+v", progress)
+	}
+}
+
+func TestFrontierAbandonAndRelease(t *testing.T) {
+	h, _ := newTestApp()
+	ctx := context.Background()
+
+	job := models.FrontierJob{ID: "poison"}
+	if err := h.Store.CreateFrontierJob(ctx, &job, []string{"http://example.com/crash", "http://example.com/fail"}); err != nil {
+		t.Fatalf("CreateFrontierJob: %v", err)
+	}
+	lease := func(owner string, limit int, ttl time.Duration) []models.FrontierURL {
+		leases, err := h.Store.LeaseURLs(ctx, storage.LeaseRequest{Owner: owner, Limit: limit, TTL: ttl, MaxLeases: 2})
+		if err != nil {
+			t.Fatalf("LeaseURLs: %v", err)
+		}
+		return leases
+	}
+
+	// one worker crashes with its lease, the other fails the URL and releases it
+	crashed := lease("crashed", 1, 50*time.Millisecond)
+	released := lease("failing", 1, time.Minute)
+	if len(crashed) != 1 || len(released) != 1 || released[0].URL != "http://example.com/fail" {
+		t.Fatalf("leased %v and %v", crashed, released)
+	}
+	if err := h.Store.ReleaseLeases(ctx, released); err != nil {
+		t.Fatalf("ReleaseLeases: %v", err)
+	}
+	time.Sleep(100 * time.Millisecond)
+
+	// both have a lease left, the released URL comes back with a new token
+	again := lease("again", 2, 50*time.Millisecond)
+	if len(again) != 2 || again[1].URL != "http://example.com/fail" || again[1].Token != released[0].Token+1 {
+		t.Fatalf("leased %+v", again)
+	}
+	if err := h.Store.ReleaseLeases(ctx, again[1:]); err != nil {
+		t.Fatalf("ReleaseLeases: %v", err)
+	}
+	time.Sleep(100 * time.Millisecond)
+
+	// a URL that keeps failing is given up on like one that keeps crashing its workers
+	if next := lease("next", 2, time.Minute); len(next) != 0 {
+		t.Fatalf("leased %+v", next)
+	}
+	progress, _ := h.Store.FrontierProgress(ctx, "poison")
+	if progress.Abandoned != 2 || !progress.Finished() {
+		t.Errorf("progress = %+v", progress)
+	}
+}
+
+func TestFrontierHeartbeatAndRelease(t *testing.T) {
+	site := newFixtureSite(t)
+	h, app := newTestApp()
+	ctx := context.Background()
+
+	doJSON(t, app, "POST", "/crawl/distributed", map[string]any{
+		"urls": []string{site.URL + "/slow"}, "job_id": "slow", "timeout": "400ms",
+	}, nil)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		h.crawlFrontierBatch(ctx, FrontierConfig{Owner: "busy", BatchSize: 1, LeaseTTL: 100 * time.Millisecond})
+	}()
+
+	// well past the TTL the heartbeats still hold the lease
+	time.Sleep(250 * time.Millisecond)
+	if leases, _ := h.Store.LeaseURLs(ctx, storage.LeaseRequest{Owner: "idle", Limit: 1, TTL: time.Minute}); len(leases) != 0 {
+		t.Errorf("a lease kept alive by heartbeats was handed out: %+v", leases)
+	}
+	<-done
+
+	// the job timed out before the page could be stored, the URL is back for the next worker
+	progress, _ := h.Store.FrontierProgress(ctx, "slow")
+	if progress.Pending != 1 || progress.Done != 0 {
+		t.Errorf("progress = %+v", progress)
+	}
+}
+
+func TestFrontierWorkerStops(t *testing.T) {
+	site := newFixtureSite(t)
+	h, app := newTestApp()
+
+	doJSON(t, app, "POST", "/crawl/distributed", map[string]any{"urls": []string{site.URL + "/slow"}, "job_id": "stopped"}, nil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	h.StartFrontierWorker(ctx, FrontierConfig{Owner: "stopping", BatchSize: 1, LeaseTTL: time.Minute, PollInterval: time.Millisecond})
+	for start := time.Now(); ; time.Sleep(5 * time.Millisecond) {
+		if progress, _ := h.Store.FrontierProgress(context.Background(), "stopped"); progress.Leased == 1 {
+			break
+		}
+		if time.Since(start) > time.Second {
+			t.Fatal("the worker never leased the URL")
+		}
+	}
+
+	start := time.Now()
+	cancel()
+	h.Wait()
+	if took := time.Since(start); took > time.Second {
+		t.Errorf("the worker took %s to stop", took)
+	}
+
+	// the lease went back before Wait returned, it doesn't have to run out first
+	progress, _ := h.Store.FrontierProgress(context.Background(), "stopped")
+	if progress.Pending != 1 || progress.Leased != 0 {
+		t.Errorf("progress = %+v", progress)
+	}
+}
@@ -0,0 +1,185 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"exercise3/crawler"
+	"exercise3/graph"
+	"exercise3/models"
+	"fmt"
+	"github.com/gofiber/fiber/v2"
+	"runtime"
+	"time"
+)
+
+// DefaultGraphInterval is how often the background job recomputes PageRank
+const DefaultGraphInterval = 15 * time.Minute
+
+// PageRankOptions tunes the power iteration of every computation
+var PageRankOptions = graph.Options{Damping: 0.85, MaxIterations: 100, Tolerance: 1e-6, Workers: runtime.NumCPU()}
+
+var ErrRankRunning = errors.New("pagerank computation already running")
+
+// RankSummary describes one PageRank computation
+type RankSummary struct {
+	Pages      int           `json:"pages"`
+	Edges      int           `json:"edges"`
+	Iterations int           `json:"iterations"`
+	Took       time.Duration `json:"took"`
+	ComputedAt time.Time     `json:"computed_at"`
+}
+
+// ComputePageRank builds the link graph of the crawled pages and replaces the stored ranks.
+// Edges to URLs that were never crawled count for the out-degree but are not part of the graph.
+func (h *Handler) ComputePageRank(ctx context.Context) (RankSummary, error) {
+	if !h.rankMu.TryLock() {
+		return RankSummary{}, ErrRankRunning
+	}
+	defer h.rankMu.Unlock()
+
+	start := time.Now()
+	pages, links, err := h.Store.LinkGraph(ctx)
+	if err != nil {
+		return RankSummary{}, err
+	}
+
+	node := make(map[uint]int, len(pages))
+	byURL := make(map[string]int, len(pages))
+	for i, page := range pages {
+		node[page.ID] = i
+		if normalized, err := crawler.NormalizeURL(page.URL); err == nil {
+			byURL[normalized] = i
+		}
+	}
+
+	g := graph.Graph{Out: make([][]int, len(pages))}
+	outDegree := make([]int, len(pages))
+	seen := make(map[[2]int]bool)
+	edges := 0
+	for _, link := range links {
+		from, ok := node[link.FromPageID]
+		if !ok {
+			continue
+		}
+		outDegree[from]++
+
+		to, ok := byURL[link.ToURL]
+		if !ok || seen[[2]int{from, to}] {
+			continue
+		}
+		seen[[2]int{from, to}] = true
+		g.Out[from] = append(g.Out[from], to)
+		edges++
+	}
+
+	ranks, iterations := graph.PageRank(g, PageRankOptions)
+	inDegree := g.InDegrees()
+
+	computedAt := time.Now()
+	rows := make([]models.PageRank, len(pages))
+	for i, page := range pages {
+		rows[i] = models.PageRank{
+			PageID:     page.ID,
+			URL:        page.URL,
+			Rank:       ranks[i],
+			InDegree:   inDegree[i],
+			OutDegree:  outDegree[i],
+			ComputedAt: computedAt,
+		}
+	}
+
+	if err := h.Store.ReplaceRanks(ctx, rows); err != nil {
+		return RankSummary{}, err
+	}
+
+	return RankSummary{
+		Pages:      len(pages),
+		Edges:      edges,
+		Iterations: iterations,
+		Took:       time.Since(start),
+		ComputedAt: computedAt,
+	}, nil
+}
+
+// StartPageRankJob recomputes PageRank in the background every interval until ctx is done, a zero interval disables it
+func (h *Handler) StartPageRankJob(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+
+	h.background.Add(1)
+	go func() {
+		defer h.background.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+
+			summary, err := h.ComputePageRank(ctx)
+			if err != nil {
+				if !errors.Is(err, ErrRankRunning) && ctx.Err() == nil {
+					fmt.Println("PageRank error:", err)
+				}
+				continue
+			}
+			fmt.Printf("PageRank: %d pages, %d edges, %d iterations in %s\n",
+				summary.Pages, summary.Edges, summary.Iterations, summary.Took)
+		}
+	}()
+}
+
+// ComputePageRankHandler recomputes PageRank right away and returns the summary
+func (h *Handler) ComputePageRankHandler(c *fiber.Ctx) error {
+	summary, err := h.ComputePageRank(c.Context())
+	if errors.Is(err, ErrRankRunning) {
+		return fiber.NewError(fiber.StatusConflict, "PageRank is already being computed.")
+	}
+	if err != nil {
+		fmt.Println("DB error:", err)
+		return fiber.NewError(fiber.StatusInternalServerError, "PageRank can not be computed.")
+	}
+
+	return c.JSON(summary)
+}
+
+// TopPagesHandler lists the pages of the last computation by rank
+func (h *Handler) TopPagesHandler(c *fiber.Ctx) error {
+	window := pagination(c)
+	ranks, err := h.Store.TopRanks(c.Context(), window)
+	if err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Ranks can not be loaded.")
+	}
+
+	return c.JSON(fiber.Map{
+		"limit":  window.Limit,
+		"offset": window.Offset,
+		"items":  ranks,
+	})
+}
+
+// InboundLinksHandler lists the crawled pages linking to the url query parameter
+func (h *Handler) InboundLinksHandler(c *fiber.Ctx) error {
+	target, err := crawler.NormalizeURL(c.Query("url"))
+	if err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "A valid url is required.")
+	}
+
+	window := pagination(c)
+	items, total, err := h.Store.InboundLinks(c.Context(), target, window)
+	if err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Links can not be loaded.")
+	}
+
+	return c.JSON(fiber.Map{
+		"url":    target,
+		"total":  total,
+		"limit":  window.Limit,
+		"offset": window.Offset,
+		"items":  items,
+	})
+}
@@ -0,0 +1,28 @@
+package handlers
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestBackgroundJobsStop(t *testing.T) {
+	h, _ := newTestApp()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	h.StartPageRankJob(ctx, time.Millisecond)
+	h.StartDuplicateJob(ctx, time.Millisecond)
+	time.Sleep(20 * time.Millisecond)
+
+	cancel()
+	stopped := make(chan struct{})
+	go func() {
+		h.Wait()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Fatal("the background jobs kept running after their ctx ended")
+	}
+}
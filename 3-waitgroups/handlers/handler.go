@@ -0,0 +1,78 @@
+package handlers
+
+import (
+	"context"
+	"exercise3/storage"
+	"github.com/gofiber/fiber/v2"
+	"sync"
+)
+
+// Handler serves the API on top of a Store. main hands it the configured backend,
+// tests hand it a storage.Memory.
+type Handler struct {
+	Store storage.Store
+	// Shutdown ends the jobs that outlive their request, like the streamed crawls, New sets context.Background()
+	Shutdown context.Context
+
+	jobs struct {
+		sync.Mutex
+		running map[string]*crawlJob
+	}
+
+	// rankMu lets only one computation replace the stored ranks at a time
+	rankMu sync.Mutex
+	// duplicateMu does the same for the duplicate clusters
+	duplicateMu sync.Mutex
+
+	// background counts the loops started by the Start methods
+	background sync.WaitGroup
+}
+
+func New(store storage.Store) *Handler {
+	h := &Handler{Store: store, Shutdown: context.Background()}
+	h.jobs.running = make(map[string]*crawlJob)
+	return h
+}
+
+// Wait blocks until the background loops returned, they return once the ctx they were started with is done
+func (h *Handler) Wait() {
+	h.background.Wait()
+}
+
+// Register mounts every route of the API on app
+func (h *Handler) Register(app *fiber.App) {
+	app.Post("/crawl", h.CrawlHandler)
+	app.Post("/crawl/stream", h.CrawlStreamHandler)
+	app.Post("/crawl/sitemap", h.SitemapCrawlHandler)
+	app.Post("/crawl/links", h.LinkCheckHandler)
+	app.Get("/crawl/stats", h.CrawlStatsHandler)
+	app.Get("/crawl/jobs", h.ListJobsHandler)
+	app.Delete("/crawl/jobs/:id", h.CancelJobHandler)
+	app.Post("/crawl/distributed", h.DistributedCrawlHandler)
+	app.Get("/crawl/distributed/:id", h.DistributedJobHandler)
+
+	app.Get("/pages", h.ListPagesHandler)
+	app.Get("/pages/:id", h.GetPageHandler)
+	app.Get("/pages/:id/versions", h.ListVersionsHandler)
+	app.Get("/pages/:id/diff", h.DiffVersionsHandler)
+	app.Get("/pages/:id/meta", h.PageMetaHandler)
+	app.Get("/meta", h.ListMetaHandler)
+	app.Get("/elements", h.ListElementsHandler)
+	app.Get("/search", h.SearchHandler)
+
+	app.Get("/graph/top", h.TopPagesHandler)
+	app.Get("/graph/inbound", h.InboundLinksHandler)
+	app.Post("/graph/pagerank", h.ComputePageRankHandler)
+
+	app.Get("/duplicates", h.ListDuplicatesHandler)
+	app.Post("/duplicates/detect", h.DetectDuplicatesHandler)
+
+	app.Post("/schedules", h.CreateScheduleHandler)
+	app.Get("/schedules", h.ListSchedulesHandler)
+	app.Get("/schedules/:id", h.GetScheduleHandler)
+	app.Put("/schedules/:id", h.UpdateScheduleHandler)
+	app.Delete("/schedules/:id", h.DeleteScheduleHandler)
+	app.Get("/schedules/:id/runs", h.ListRunsHandler)
+
+	app.Get("/metrics", h.MetricsHandler)
+}
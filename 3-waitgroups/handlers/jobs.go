@@ -0,0 +1,148 @@
+package handlers
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"github.com/gofiber/fiber/v2"
+	"net"
+	"sort"
+	"syscall"
+	"time"
+)
+
+// DefaultJobTimeout is the deadline of a crawl job that doesn't ask for its own
+var DefaultJobTimeout = 10 * time.Minute
+
+// crawlJob is a running crawl, kept so it can be listed and cancelled from another request
+type crawlJob struct {
+	ID        string    `json:"id"`
+	URLs      int       `json:"urls"`
+	StartedAt time.Time `json:"started_at"`
+	Deadline  time.Time `json:"deadline"`
+
+	cancel  context.CancelFunc
+	unwatch func()
+}
+
+// startJob derives the job context from parent. Handlers that answer before returning pass the request
+// context, so a server shutdown aborts the crawl too, and watch the client with watchClient.
+// Jobs that outlive their request pass h.Shutdown instead.
+func (h *Handler) startJob(parent context.Context, id string, urls int, timeout time.Duration) (context.Context, *crawlJob, error) {
+	if id == "" {
+		id = newJobID()
+	}
+	if timeout <= 0 {
+		timeout = DefaultJobTimeout
+	}
+
+	ctx, cancel := context.WithTimeout(parent, timeout)
+	deadline, _ := ctx.Deadline()
+	job := &crawlJob{ID: id, URLs: urls, StartedAt: time.Now(), Deadline: deadline, cancel: cancel}
+
+	h.jobs.Lock()
+	defer h.jobs.Unlock()
+
+	if _, exists := h.jobs.running[id]; exists {
+		cancel()
+		return nil, nil, fiber.NewError(fiber.StatusConflict, "A crawl job with this id is already running.")
+	}
+	h.jobs.running[id] = job
+
+	return ctx, job, nil
+}
+
+// watchClient cancels the job when the client of c disconnects before the answer is ready.
+// fasthttp doesn't read the connection while the handler runs and its request context only ends
+// on shutdown, so a read on the connection is what notices the disconnect. A client that sends its
+// next request meanwhile loses a byte of it to the read, that connection is closed after the answer.
+// Connections that aren't sockets, like the ones of app.Test, aren't watched.
+func (job *crawlJob) watchClient(c *fiber.Ctx) {
+	conn := c.Context().Conn()
+	if _, ok := conn.(syscall.Conn); !ok {
+		return
+	}
+
+	done := make(chan struct{})
+	var consumed bool
+	go func() {
+		defer close(done)
+
+		n, err := conn.Read(make([]byte, 1))
+		consumed = n > 0
+		// unwatch ends the read with a deadline, anything else is the client going away
+		var netErr net.Error
+		if n == 0 && err != nil && !(errors.As(err, &netErr) && netErr.Timeout()) {
+			job.cancel()
+		}
+	}()
+
+	job.unwatch = func() {
+		_ = conn.SetReadDeadline(time.Now())
+		<-done
+		_ = conn.SetReadDeadline(time.Time{})
+		if consumed {
+			c.Context().SetConnectionClose()
+		}
+	}
+}
+
+// setJobURLs sets the URL count of a job that found its URLs after it started
+func (h *Handler) setJobURLs(job *crawlJob, urls int) {
+	h.jobs.Lock()
+	defer h.jobs.Unlock()
+	job.URLs = urls
+}
+
+// finishJob cancels the job, stops watching its client and removes it from the registry
+func (h *Handler) finishJob(job *crawlJob) {
+	job.cancel()
+	if job.unwatch != nil {
+		job.unwatch()
+	}
+
+	h.jobs.Lock()
+	delete(h.jobs.running, job.ID)
+	h.jobs.Unlock()
+}
+
+func newJobID() string {
+	b := make([]byte, 8)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// ListJobsHandler returns the crawl jobs that are still running
+func (h *Handler) ListJobsHandler(c *fiber.Ctx) error {
+	// copies, a job may still update its URL count
+	h.jobs.Lock()
+	list := make([]crawlJob, 0, len(h.jobs.running))
+	for _, job := range h.jobs.running {
+		list = append(list, *job)
+	}
+	h.jobs.Unlock()
+
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].StartedAt.Before(list[j].StartedAt)
+	})
+
+	return c.JSON(list)
+}
+
+// CancelJobHandler aborts a running crawl, its outstanding fetches and DB writes
+func (h *Handler) CancelJobHandler(c *fiber.Ctx) error {
+	h.jobs.Lock()
+	job, ok := h.jobs.running[c.Params("id")]
+	h.jobs.Unlock()
+
+	if !ok {
+		return fiber.NewError(fiber.StatusNotFound, "Crawl job not found.")
+	}
+	job.cancel()
+
+	return c.JSON(fiber.Map{
+		"message": "Crawl job cancelled.",
+		"id":      job.ID,
+	})
+}
@@ -0,0 +1,94 @@
+package handlers
+
+import (
+	"encoding/csv"
+	"exercise3/crawler"
+	"fmt"
+	"github.com/gofiber/fiber/v2"
+	"strconv"
+	"strings"
+)
+
+// linkCSVHeader are the columns of the CSV report, one row per broken link
+var linkCSVHeader = []string{
+	"page_url", "link_url", "type", "text", "status", "status_code", "method",
+	"redirects", "final_url", "error", "duration_ms",
+}
+
+// LinkCheckHandler crawls the urls and checks every a and img target of the pages, answering with a broken link report
+// per page. format=csv answers with one row per broken link instead of JSON.
+func (h *Handler) LinkCheckHandler(c *fiber.Ctx) error {
+	format := c.Query("format", "json")
+	if format != "json" && format != "csv" {
+		return fiber.NewError(fiber.StatusBadRequest, "Format must be json or csv.")
+	}
+
+	req, opts, timeout, err := parseCrawlRequest(c)
+	if err != nil {
+		return err
+	}
+	if len(req.URLs) == 0 {
+		return fiber.NewError(fiber.StatusBadRequest, "At least one url is required.")
+	}
+
+	ctx, job, err := h.startJob(c.Context(), req.JobID, len(req.URLs), timeout)
+	if err != nil {
+		return err
+	}
+	defer h.finishJob(job)
+	job.watchClient(c)
+
+	// no validators, a 304 leaves no links to check
+	pages := crawler.DefaultPipeline.Run(ctx, req.URLs, opts, h.savePages, nil)
+	reports := crawler.DefaultLinkChecker.Report(ctx, pages, opts.Client)
+
+	if format == "csv" {
+		return writeLinkCSV(c, job.ID, reports)
+	}
+
+	links, broken := 0, 0
+	for _, report := range reports {
+		links += report.Links
+		broken += len(report.Broken)
+	}
+
+	return c.JSON(fiber.Map{
+		"message": "Link check completed.",
+		"job_id":  job.ID,
+		"total":   len(reports),
+		"links":   links,
+		"broken":  broken,
+		"pages":   reports,
+	})
+}
+
+func writeLinkCSV(c *fiber.Ctx, jobID string, reports []crawler.LinkReport) error {
+	c.Set(fiber.HeaderContentType, "text/csv; charset=utf-8")
+	c.Set(fiber.HeaderContentDisposition, fmt.Sprintf(`attachment; filename="broken-links-%s.csv"`, jobID))
+
+	w := csv.NewWriter(c)
+	if err := w.Write(linkCSVHeader); err != nil {
+		return err
+	}
+
+	for _, report := range reports {
+		for _, link := range report.Broken {
+			hops := make([]string, len(link.Redirects))
+			for i, hop := range link.Redirects {
+				hops[i] = fmt.Sprintf("%d %s", hop.StatusCode, hop.URL)
+			}
+
+			err := w.Write([]string{
+				report.URL, link.URL, link.Type, link.Text, link.Status,
+				strconv.Itoa(link.StatusCode), link.Method, strings.Join(hops, " -> "),
+				link.FinalURL, link.Error, strconv.FormatInt(link.Duration.Milliseconds(), 10),
+			})
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	w.Flush()
+	return w.Error()
+}
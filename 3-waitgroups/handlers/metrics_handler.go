@@ -0,0 +1,13 @@
+package handlers
+
+import (
+	"exercise3/metrics"
+	"github.com/gofiber/fiber/v2"
+)
+
+// MetricsHandler serves the process metrics in the Prometheus text format
+func (h *Handler) MetricsHandler(c *fiber.Ctx) error {
+	c.Set(fiber.HeaderContentType, metrics.ContentType)
+	_, err := metrics.Default.WriteTo(c)
+	return err
+}
@@ -0,0 +1,49 @@
+package handlers
+
+import (
+	"exercise3/metrics"
+	"io"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestMetricsHandler(t *testing.T) {
+	site := newFixtureSite(t)
+	_, app := newTestApp()
+
+	doJSON(t, app, "POST", "/crawl", map[string]any{
+		"urls":  []string{site.URL + "/", site.URL + "/error"},
+		"retry": fastRetry,
+	}, nil)
+
+	resp, err := app.Test(httptest.NewRequest("GET", "/metrics", nil))
+	if err != nil {
+		t.Fatalf("GET /metrics: %v", err)
+	}
+	if ct := resp.Header.Get("Content-Type"); ct != metrics.ContentType {
+		t.Errorf("Content-Type = %q", ct)
+	}
+	body, _ := io.ReadAll(resp.Body)
+
+	host, _ := url.Parse(site.URL)
+	for _, want := range []string{
+		"# TYPE crawler_pages_fetched_total counter",
+		`crawler_pages_failed_total{status="http_error"}`,
+		"crawler_bytes_downloaded_total ",
+		"# TYPE crawler_fetch_duration_seconds histogram",
+		"crawler_fetch_duration_seconds_count ",
+		"crawler_fetches_in_flight 0",
+		"crawler_queue_depth 0",
+		"# TYPE crawler_db_write_duration_seconds histogram",
+	} {
+		if !strings.Contains(string(body), want) {
+			t.Errorf("/metrics is missing %q", want)
+		}
+	}
+	// every crawled domain would be a series of its own
+	if strings.Contains(string(body), host.Host) {
+		t.Error("/metrics has a series per crawled host")
+	}
+}
@@ -0,0 +1,140 @@
+package handlers
+
+import (
+	"exercise3/crawler"
+	"fmt"
+	"github.com/gofiber/fiber/v2"
+	"time"
+)
+
+const (
+	// MaxRetryAttempts and MaxRetryDelay bound the retry overrides, so one request can't keep a host's slot for hours
+	MaxRetryAttempts = 10
+	MaxRetryDelay    = 5 * time.Minute
+)
+
+// OptionsRequest holds the crawl settings a request or a schedule can override, unset fields keep the global value
+type OptionsRequest struct {
+	Timeout string             `json:"timeout,omitempty"`
+	Retry   *RetryRequest      `json:"retry,omitempty"`
+	Extract *ExtractionRequest `json:"extract,omitempty"`
+	// Client is the HTTP client profile of the crawl, left out it's the default client
+	Client *crawler.ClientProfile `json:"client,omitempty"`
+
+	MaxBodyBytes int64 `json:"max_body_bytes,omitempty"`
+	// NoCache downloads every page in full, without the stored validators
+	NoCache bool `json:"no_cache,omitempty"`
+}
+
+// build applies the overrides to the crawler defaults, the timeout is 0 when the job default applies
+func (r OptionsRequest) build() (crawler.Options, time.Duration, error) {
+	var timeout time.Duration
+	if err := parseDuration(r.Timeout, &timeout); err != nil {
+		return crawler.Options{}, 0, fiber.NewError(fiber.StatusBadRequest, "Invalid timeout.")
+	}
+
+	opts := crawler.DefaultOptions()
+	if err := r.Retry.apply(&opts.Retry); err != nil {
+		return opts, 0, err
+	}
+	if err := r.Extract.apply(&opts.Extraction); err != nil {
+		return opts, 0, err
+	}
+	if r.MaxBodyBytes > 0 {
+		opts.MaxBodySize = r.MaxBodyBytes
+	}
+	if r.Client != nil {
+		if err := r.Client.Validate(); err != nil {
+			return opts, 0, fiber.NewError(fiber.StatusBadRequest, "Invalid client profile: "+err.Error()+".")
+		}
+		opts.Client = *r.Client
+	}
+	return opts, timeout, nil
+}
+
+// RetryRequest overrides the global retry policy for one crawl, unset fields keep the global value
+type RetryRequest struct {
+	MaxAttempts *int   `json:"max_attempts,omitempty"`
+	BaseDelay   string `json:"base_delay,omitempty"`
+	MaxDelay    string `json:"max_delay,omitempty"`
+	RetryOn     []int  `json:"retry_on,omitempty"`
+}
+
+func (r *RetryRequest) apply(policy *crawler.RetryPolicy) error {
+	if r == nil {
+		return nil
+	}
+
+	if r.MaxAttempts != nil {
+		if *r.MaxAttempts < 1 || *r.MaxAttempts > MaxRetryAttempts {
+			return fiber.NewError(fiber.StatusBadRequest, fmt.Sprintf("Retry max_attempts must be between 1 and %d.", MaxRetryAttempts))
+		}
+		policy.MaxAttempts = *r.MaxAttempts
+	}
+	if r.RetryOn != nil {
+		policy.RetryableStatus = r.RetryOn
+	}
+	if err := parseRetryDelay("base_delay", r.BaseDelay, &policy.BaseDelay); err != nil {
+		return err
+	}
+	return parseRetryDelay("max_delay", r.MaxDelay, &policy.MaxDelay)
+}
+
+// parseRetryDelay parses the named retry delay into d, rejecting one beyond MaxRetryDelay
+func parseRetryDelay(name, s string, d *time.Duration) error {
+	if s == "" {
+		return nil
+	}
+
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "Invalid retry "+name+".")
+	}
+	if parsed < 0 || parsed > MaxRetryDelay {
+		return fiber.NewError(fiber.StatusBadRequest, fmt.Sprintf("Retry %s must be between 0 and %s.", name, MaxRetryDelay))
+	}
+	*d = parsed
+	return nil
+}
+
+// parseDuration leaves d untouched when s is empty
+func parseDuration(s string, d *time.Duration) error {
+	if s == "" {
+		return nil
+	}
+
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = parsed
+	return nil
+}
+
+// ExtractionRequest picks a named profile or brings its own rules, rules win when both are set
+type ExtractionRequest struct {
+	Profile string                   `json:"profile,omitempty"`
+	Rules   []crawler.ExtractionRule `json:"rules,omitempty"`
+}
+
+func (r *ExtractionRequest) apply(spec *crawler.ExtractionSpec) error {
+	if r == nil {
+		return nil
+	}
+
+	switch {
+	case len(r.Rules) > 0:
+		*spec = crawler.ExtractionSpec{Rules: r.Rules}
+	case r.Profile != "":
+		profile, ok := crawler.Profiles[r.Profile]
+		if !ok {
+			return fiber.NewError(fiber.StatusBadRequest, "Unknown extraction profile.")
+		}
+		*spec = profile
+	}
+
+	if err := spec.Validate(); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, err.Error())
+	}
+	return nil
+}
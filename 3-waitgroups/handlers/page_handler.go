@@ -0,0 +1,142 @@
+package handlers
+
+import (
+	"errors"
+	"exercise3/models"
+	"exercise3/storage"
+	"github.com/gofiber/fiber/v2"
+)
+
+// ListVersionsHandler lists the snapshots of a page, newest first
+func (h *Handler) ListVersionsHandler(c *fiber.Ctx) error {
+	page, err := h.findPage(c)
+	if err != nil {
+		return err
+	}
+
+	versions, err := h.Store.ListVersions(c.Context(), page.ID)
+	if err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Versions can not be loaded.")
+	}
+
+	return c.JSON(fiber.Map{
+		"page_id":        page.ID,
+		"url":            page.URL,
+		"latest_version": page.LatestVersion,
+		"versions":       versions,
+	})
+}
+
+// DiffVersionsHandler compares the elements of two versions, by default the latest one and the one before
+func (h *Handler) DiffVersionsHandler(c *fiber.Ctx) error {
+	page, err := h.findPage(c)
+	if err != nil {
+		return err
+	}
+
+	to := c.QueryInt("to", page.LatestVersion)
+	from := c.QueryInt("from", to-1)
+
+	fromElements, err := h.versionElements(c, page.ID, from)
+	if err != nil {
+		return err
+	}
+	toElements, err := h.versionElements(c, page.ID, to)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(fiber.Map{
+		"page_id": page.ID,
+		"url":     page.URL,
+		"from":    from,
+		"to":      to,
+		"diff":    models.DiffElements(fromElements, toElements),
+	})
+}
+
+func (h *Handler) findPage(c *fiber.Ctx) (models.CrawlPage, error) {
+	id, err := c.ParamsInt("id")
+	if err != nil || id < 1 {
+		return models.CrawlPage{}, fiber.NewError(fiber.StatusBadRequest, "Invalid page id.")
+	}
+
+	page, err := h.Store.GetPage(c.Context(), uint(id))
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			return page, fiber.NewError(fiber.StatusNotFound, "Page not found.")
+		}
+		return page, fiber.NewError(fiber.StatusInternalServerError, "Page can not be loaded.")
+	}
+	return page, nil
+}
+
+func (h *Handler) versionElements(c *fiber.Ctx, pageID uint, version int) ([]models.Element, error) {
+	elements, err := h.Store.VersionElements(c.Context(), pageID, version)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			return nil, fiber.NewError(fiber.StatusNotFound, "Version not found.")
+		}
+		return nil, fiber.NewError(fiber.StatusInternalServerError, "Version can not be loaded.")
+	}
+	return elements, nil
+}
+
+// PageMetaHandler returns the title, meta tags, OpenGraph/Twitter cards and JSON-LD of a page
+func (h *Handler) PageMetaHandler(c *fiber.Ctx) error {
+	page, err := h.findPage(c)
+	if err != nil {
+		return err
+	}
+
+	meta, err := h.Store.GetMeta(c.Context(), page.ID)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			return fiber.NewError(fiber.StatusNotFound, "Page has no metadata yet.")
+		}
+		return fiber.NewError(fiber.StatusInternalServerError, "Metadata can not be loaded.")
+	}
+
+	return c.JSON(fiber.Map{
+		"page_id": page.ID,
+		"url":     page.URL,
+		"meta":    meta,
+	})
+}
+
+// ListMetaHandler lists page metadata for SEO reports.
+// Filters: lang, missing (title, description, canonical, og) and an url prefix, paginated with limit and offset.
+func (h *Handler) ListMetaHandler(c *fiber.Ctx) error {
+	filter := storage.MetaFilter{
+		Language:  c.Query("lang"),
+		URLPrefix: c.Query("url"),
+		Missing:   c.Query("missing"),
+	}
+
+	switch filter.Missing {
+	case "", storage.MissingTitle, storage.MissingDescription, storage.MissingCanonical, storage.MissingOpenGraph:
+	default:
+		return fiber.NewError(fiber.StatusBadRequest, "Invalid missing filter.")
+	}
+
+	filter.Window = pagination(c)
+	rows, err := h.Store.ListMeta(c.Context(), filter)
+	if err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Metadata can not be loaded.")
+	}
+
+	return c.JSON(fiber.Map{
+		"limit":  filter.Limit,
+		"offset": filter.Offset,
+		"items":  rows,
+	})
+}
+
+// pagination reads limit and offset, limit is capped so one call can't dump the whole table
+func pagination(c *fiber.Ctx) storage.Window {
+	limit := c.QueryInt("limit", 50)
+	if limit < 1 || limit > 500 {
+		limit = 50
+	}
+	return storage.Window{Limit: limit, Offset: max(c.QueryInt("offset", 0), 0)}
+}
@@ -0,0 +1,45 @@
+package handlers
+
+import (
+	"context"
+	"exercise3/crawler"
+	"exercise3/metrics"
+	"fmt"
+	"time"
+)
+
+var dbWriteDuration = metrics.NewHistogram("crawler_db_write_duration_seconds", "Duration of the page batch writes of the persistence stage.", nil)
+
+// savePages is the pipeline's persistence stage of the crawl endpoints
+func (h *Handler) savePages(ctx context.Context, batch []*crawler.PageResult) {
+	persistBatch(ctx, batch, h.Store.SavePages)
+}
+
+// persistBatch writes the whole batch in one go with save. When that fails the pages are retried one by one,
+// so a single bad page doesn't cost the others their write.
+func persistBatch(ctx context.Context, batch []*crawler.PageResult, save func(context.Context, []*crawler.PageResult) error) {
+	start := time.Now()
+	err := save(ctx, batch)
+	dbWriteDuration.Observe(time.Since(start).Seconds())
+	if err == nil {
+		return
+	}
+
+	if len(batch) > 1 && ctx.Err() == nil {
+		for _, r := range batch {
+			persistBatch(ctx, []*crawler.PageResult{r}, save)
+		}
+		return
+	}
+
+	fmt.Println("DB error:", err)
+	for _, r := range batch {
+		r.PageID, r.Version = 0, 0
+		r.Status = crawler.StatusDBError
+		if ctx.Err() != nil {
+			r.Status = crawler.StatusCanceled
+		}
+		r.Error = err.Error()
+		r.LastError = r.Error
+	}
+}
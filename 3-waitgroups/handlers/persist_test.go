@@ -0,0 +1,35 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"exercise3/crawler"
+	"testing"
+)
+
+func TestPersistBatchFailures(t *testing.T) {
+	bad := errors.New("constraint violated")
+	save := func(_ context.Context, batch []*crawler.PageResult) error {
+		for _, r := range batch {
+			if r.URL == "http://example.com/bad" {
+				return bad
+			}
+		}
+		for _, r := range batch {
+			r.PageID = 1
+		}
+		return nil
+	}
+
+	good := &crawler.PageResult{URL: "http://example.com/good", Status: crawler.StatusSuccess}
+	failed := &crawler.PageResult{URL: "http://example.com/bad", Status: crawler.StatusSuccess, Attempts: 1}
+	persistBatch(context.Background(), []*crawler.PageResult{good, failed}, save)
+
+	// the batch is retried page by page, only the bad one fails
+	if good.Status != crawler.StatusSuccess || good.PageID != 1 {
+		t.Errorf("good page = %+v", good)
+	}
+	if failed.Status != crawler.StatusDBError || failed.Error != bad.Error() || failed.LastError != bad.Error() || failed.PageID != 0 {
+		t.Errorf("bad page = %+v", failed)
+	}
+}
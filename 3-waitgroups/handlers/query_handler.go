@@ -0,0 +1,146 @@
+package handlers
+
+import (
+	"exercise3/models"
+	"exercise3/storage"
+	"github.com/gofiber/fiber/v2"
+	"time"
+)
+
+// ListPagesHandler lists crawled pages, newest crawl first.
+// Filters: status, since and until on the last crawl time.
+func (h *Handler) ListPagesHandler(c *fiber.Ctx) error {
+	crawled, err := crawlDateRange(c)
+	if err != nil {
+		return err
+	}
+
+	filter := storage.PageFilter{Status: c.Query("status"), Crawled: crawled, Window: pagination(c)}
+	pages, total, err := h.Store.ListPages(c.Context(), filter)
+	if err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Pages can not be loaded.")
+	}
+
+	return c.JSON(fiber.Map{
+		"total":  total,
+		"limit":  filter.Limit,
+		"offset": filter.Offset,
+		"items":  pages,
+	})
+}
+
+// GetPageHandler returns a page with the elements of its latest version
+func (h *Handler) GetPageHandler(c *fiber.Ctx) error {
+	page, err := h.findPage(c)
+	if err != nil {
+		return err
+	}
+
+	elements := []models.Element{}
+	if page.LatestVersion > 0 {
+		if elements, err = h.versionElements(c, page.ID, page.LatestVersion); err != nil {
+			return err
+		}
+	}
+
+	return c.JSON(fiber.Map{
+		"page":     page,
+		"elements": elements,
+	})
+}
+
+// ListElementsHandler filters the elements of the latest page versions by type, field and page
+func (h *Handler) ListElementsHandler(c *fiber.Ctx) error {
+	fetched, err := crawlDateRange(c)
+	if err != nil {
+		return err
+	}
+
+	filter := storage.ElementFilter{
+		Type:    c.Query("type"),
+		Field:   c.Query("field"),
+		PageID:  uint(max(c.QueryInt("page_id"), 0)),
+		Fetched: fetched,
+		Window:  pagination(c),
+	}
+	elements, err := h.Store.ListElements(c.Context(), filter)
+	if err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Elements can not be loaded.")
+	}
+
+	return c.JSON(fiber.Map{
+		"limit":  filter.Limit,
+		"offset": filter.Offset,
+		"items":  elements,
+	})
+}
+
+// SearchHandler runs a full-text search over the content of the latest page versions.
+// Results are ranked by relevance, or by crawl date with order=date, and filterable by type, since and until.
+func (h *Handler) SearchHandler(c *fiber.Ctx) error {
+	q := c.Query("q")
+	if q == "" {
+		return fiber.NewError(fiber.StatusBadRequest, "Query parameter q is required.")
+	}
+
+	fetched, err := crawlDateRange(c)
+	if err != nil {
+		return err
+	}
+
+	query := storage.SearchQuery{Text: q, Type: c.Query("type"), Fetched: fetched, Window: pagination(c)}
+	switch c.Query("order", "rank") {
+	case "rank":
+	case "date":
+		query.ByDate = true
+	default:
+		return fiber.NewError(fiber.StatusBadRequest, "Invalid order, use rank or date.")
+	}
+
+	hits, err := h.Store.Search(c.Context(), query)
+	if err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Search failed.")
+	}
+
+	return c.JSON(fiber.Map{
+		"query":  q,
+		"limit":  query.Limit,
+		"offset": query.Offset,
+		"items":  hits,
+	})
+}
+
+// crawlDateRange reads the since and until query parameters.
+// Both take RFC 3339 timestamps or plain dates, a plain until date includes the whole day.
+func crawlDateRange(c *fiber.Ctx) (storage.TimeRange, error) {
+	var r storage.TimeRange
+	if since := c.Query("since"); since != "" {
+		t, _, err := parseQueryTime(since)
+		if err != nil {
+			return r, fiber.NewError(fiber.StatusBadRequest, "Invalid since.")
+		}
+		r.Since = t
+	}
+
+	if until := c.Query("until"); until != "" {
+		t, dateOnly, err := parseQueryTime(until)
+		if err != nil {
+			return r, fiber.NewError(fiber.StatusBadRequest, "Invalid until.")
+		}
+		if dateOnly {
+			t = t.AddDate(0, 0, 1)
+		}
+		r.Until = t
+	}
+
+	return r, nil
+}
+
+func parseQueryTime(s string) (time.Time, bool, error) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, false, nil
+	}
+
+	t, err := time.Parse(time.DateOnly, s)
+	return t, true, err
+}
@@ -0,0 +1,198 @@
+package handlers
+
+import (
+	"exercise3/crawler"
+	"exercise3/internal/testsite"
+	"exercise3/models"
+	"exercise3/storage"
+	"fmt"
+	"github.com/gofiber/fiber/v2"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+)
+
+// crawlQuerySite crawls /, /a, /b and /seo, which has every kind of metadata, and returns their page ids by path
+func crawlQuerySite(t *testing.T) (*fixtureSite, *fiber.App, map[string]uint) {
+	t.Helper()
+
+	site := newFixtureSite(t)
+	site.Handle("/seo", func(w http.ResponseWriter, r *http.Request) {
+		testsite.WriteHTML(w, `<html lang="de"><head><title>Crawler</title>
+<meta name="description" content="Alles über Crawler">
+<link rel="canonical" href="/seo">
+<meta property="og:title" content="Crawler im Netz">
+</head><body><h1>Crawler</h1><p>Ein Crawler besucht Seiten</p></body></html>`)
+	})
+
+	_, app := newTestApp()
+	var resp crawlResponse
+	paths := []string{"/", "/a", "/b", "/seo"}
+	urls := make([]string, len(paths))
+	for i, path := range paths {
+		urls[i] = site.URL + path
+	}
+	doJSON(t, app, "POST", "/crawl", map[string]any{"urls": urls}, &resp)
+
+	ids := make(map[string]uint, len(paths))
+	for _, r := range resp.Results {
+		if r.Status != crawler.StatusSuccess {
+			t.Fatalf("crawl of %s: %+v", r.URL, r)
+		}
+		ids[r.URL[len(site.URL):]] = r.PageID
+	}
+	return site, app, ids
+}
+
+func TestSearchHandler(t *testing.T) {
+	site, app, ids := crawlQuerySite(t)
+
+	var result struct {
+		Query string              `json:"query"`
+		Limit int                 `json:"limit"`
+		Items []storage.SearchHit `json:"items"`
+	}
+	if status := doJSON(t, app, "GET", "/search?q=crawler+seiten", nil, &result); status != http.StatusOK {
+		t.Fatalf("GET /search = %d", status)
+	}
+	if result.Query != "crawler seiten" || result.Limit != 50 || len(result.Items) != 1 {
+		t.Fatalf("search for every term = %+v", result)
+	}
+	if hit := result.Items[0]; hit.PageID != ids["/seo"] || hit.URL != site.URL+"/seo" || hit.ElementType != "p" || hit.Version != 1 {
+		t.Errorf("hit = %+v", hit)
+	}
+
+	// the type narrows the hits, the date order and the window still find them
+	tests := []struct {
+		query string
+		want  int
+	}{
+		{"q=crawler", 2},
+		{"q=crawler&type=h1", 1},
+		{"q=page&type=h1", 1},
+		{"q=page&order=date", 2},
+		{"q=page&limit=1", 1},
+		{"q=page&offset=1&limit=1", 1},
+		{"q=page&until=2000-01-01", 0},
+		{"q=page&since=" + url.QueryEscape(time.Now().Add(time.Hour).Format(time.RFC3339)), 0},
+		{"q=nowhere", 0},
+	}
+	for _, tt := range tests {
+		result.Items = nil
+		if status := doJSON(t, app, "GET", "/search?"+tt.query, nil, &result); status != http.StatusOK || len(result.Items) != tt.want {
+			t.Errorf("GET /search?%s = %d with %d hits, want %d", tt.query, status, len(result.Items), tt.want)
+		}
+	}
+
+	for _, query := range []string{"", "q=page&order=popular", "q=page&since=yesterday", "q=page&until=2024-13-01"} {
+		if status := doJSON(t, app, "GET", "/search?"+query, nil, nil); status != http.StatusBadRequest {
+			t.Errorf("GET /search?%s = %d, want 400", query, status)
+		}
+	}
+}
+
+func TestListElementsHandler(t *testing.T) {
+	_, app, ids := crawlQuerySite(t)
+
+	var result struct {
+		Limit  int              `json:"limit"`
+		Offset int              `json:"offset"`
+		Items  []models.Element `json:"items"`
+	}
+	path := fmt.Sprintf("/elements?page_id=%d", ids["/a"])
+	if status := doJSON(t, app, "GET", path, nil, &result); status != http.StatusOK || len(result.Items) != 3 {
+		t.Fatalf("GET %s = %d, %+v", path, status, result)
+	}
+	for _, e := range result.Items {
+		if e.PageID != ids["/a"] {
+			t.Errorf("element of page %d listed for page %d", e.PageID, ids["/a"])
+		}
+	}
+
+	tests := []struct {
+		query string
+		want  int
+	}{
+		{"type=h1", 3},
+		{"type=h2", 1},
+		{fmt.Sprintf("type=a&page_id=%d", ids["/b"]), 2},
+		{"type=a&limit=2&offset=3", 2},
+		{"type=a&offset=10", 0},
+		{"type=video", 0},
+		{"until=2000-01-01", 0},
+	}
+	for _, tt := range tests {
+		result.Items = nil
+		if status := doJSON(t, app, "GET", "/elements?"+tt.query, nil, &result); status != http.StatusOK || len(result.Items) != tt.want {
+			t.Errorf("GET /elements?%s = %d with %d elements, want %d", tt.query, status, len(result.Items), tt.want)
+		}
+	}
+
+	if status := doJSON(t, app, "GET", "/elements?since=soon", nil, nil); status != http.StatusBadRequest {
+		t.Errorf("GET /elements with a bad since = %d, want 400", status)
+	}
+}
+
+func TestMetaHandlers(t *testing.T) {
+	site, app, ids := crawlQuerySite(t)
+
+	var page struct {
+		PageID uint            `json:"page_id"`
+		URL    string          `json:"url"`
+		Meta   models.PageMeta `json:"meta"`
+	}
+	path := fmt.Sprintf("/pages/%d/meta", ids["/seo"])
+	if status := doJSON(t, app, "GET", path, nil, &page); status != http.StatusOK {
+		t.Fatalf("GET %s = %d", path, status)
+	}
+	if page.URL != site.URL+"/seo" || page.Meta.Title != "Crawler" || page.Meta.Description != "Alles über Crawler" ||
+		page.Meta.Canonical != site.URL+"/seo" || page.Meta.Language != "de" || page.Meta.OpenGraph["og:title"] != "Crawler im Netz" {
+		t.Errorf("meta of /seo = %+v", page)
+	}
+	if status := doJSON(t, app, "GET", "/pages/999/meta", nil, nil); status != http.StatusNotFound {
+		t.Errorf("GET /pages/999/meta = %d, want 404", status)
+	}
+
+	var result struct {
+		Items []storage.MetaRow `json:"items"`
+	}
+	tests := []struct {
+		query string
+		want  []string
+	}{
+		{"lang=de", []string{"/seo"}},
+		{"lang=en", []string{"/"}},
+		{"missing=description", []string{"/", "/a", "/b"}},
+		{"missing=canonical", []string{"/", "/a", "/b"}},
+		{"missing=og", []string{"/", "/a", "/b"}},
+		{"missing=title", nil},
+		{"url=" + url.QueryEscape(site.URL+"/s"), []string{"/seo"}},
+		{"url=" + url.QueryEscape(site.URL+"/%"), nil},
+	}
+	for _, tt := range tests {
+		result.Items = nil
+		if status := doJSON(t, app, "GET", "/meta?"+tt.query, nil, &result); status != http.StatusOK {
+			t.Errorf("GET /meta?%s = %d", tt.query, status)
+			continue
+		}
+		got := make(map[string]bool, len(result.Items))
+		for _, row := range result.Items {
+			got[row.URL[len(site.URL):]] = true
+		}
+		if len(got) != len(tt.want) {
+			t.Errorf("GET /meta?%s listed %v, want %v", tt.query, got, tt.want)
+			continue
+		}
+		for _, path := range tt.want {
+			if !got[path] {
+				t.Errorf("GET /meta?%s listed %v, want %v", tt.query, got, tt.want)
+				break
+			}
+		}
+	}
+
+	if status := doJSON(t, app, "GET", "/meta?missing=keywords", nil, nil); status != http.StatusBadRequest {
+		t.Errorf("GET /meta?missing=keywords = %d, want 400", status)
+	}
+}
@@ -0,0 +1,284 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"exercise3/crawler"
+	"exercise3/models"
+	"exercise3/scheduler"
+	"exercise3/storage"
+	"fmt"
+	"github.com/gofiber/fiber/v2"
+	"time"
+)
+
+// ScheduleRequest is the body of the schedule create and update endpoints
+type ScheduleRequest struct {
+	Name    string         `json:"name"`
+	Cron    string         `json:"cron"`
+	URLs    []string       `json:"urls"`
+	Enabled *bool          `json:"enabled"`
+	Options OptionsRequest `json:"options"`
+}
+
+// scheduleView adds the next due time to a stored schedule
+type scheduleView struct {
+	models.CrawlSchedule
+	NextRunAt *time.Time `json:"next_run_at"`
+}
+
+func newScheduleView(schedule models.CrawlSchedule) scheduleView {
+	view := scheduleView{CrawlSchedule: schedule}
+	if cron, err := scheduler.ParseCron(schedule.Cron); err == nil && schedule.Enabled {
+		if next := cron.Next(time.Now()); !next.IsZero() {
+			view.NextRunAt = &next
+		}
+	}
+	return view
+}
+
+// apply validates the request and copies it onto the schedule, enabled keeps its value when left out
+func (r ScheduleRequest) apply(schedule *models.CrawlSchedule) error {
+	if _, err := scheduler.ParseCron(r.Cron); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "Invalid cron expression.")
+	}
+	if len(r.URLs) == 0 {
+		return fiber.NewError(fiber.StatusBadRequest, "At least one url is required.")
+	}
+	// a schedule keeps crawling its urls, one that can never be fetched is refused up front
+	for _, url := range r.URLs {
+		if _, err := crawler.NormalizeURL(url); err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, fmt.Sprintf("Invalid url %q.", url))
+		}
+	}
+	if _, _, err := r.Options.build(); err != nil {
+		return err
+	}
+
+	options, err := json.Marshal(r.Options)
+	if err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "Invalid options.")
+	}
+
+	schedule.Name = r.Name
+	schedule.Cron = r.Cron
+	schedule.URLs = normalizeSeeds(r.URLs)
+	schedule.Options = options
+	if r.Enabled != nil {
+		schedule.Enabled = *r.Enabled
+	}
+	return nil
+}
+
+func (h *Handler) CreateScheduleHandler(c *fiber.Ctx) error {
+	var req ScheduleRequest
+	if err := c.BodyParser(&req); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "Invalid request body.")
+	}
+
+	schedule := models.CrawlSchedule{Enabled: true}
+	if err := req.apply(&schedule); err != nil {
+		return err
+	}
+	if err := h.Store.CreateSchedule(c.Context(), &schedule); err != nil {
+		fmt.Println("DB error:", err)
+		return fiber.NewError(fiber.StatusInternalServerError, "Schedule can not be saved.")
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(newScheduleView(schedule))
+}
+
+func (h *Handler) ListSchedulesHandler(c *fiber.Ctx) error {
+	schedules, err := h.Store.ListSchedules(c.Context(), false)
+	if err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Schedules can not be loaded.")
+	}
+
+	views := make([]scheduleView, len(schedules))
+	for i, schedule := range schedules {
+		views[i] = newScheduleView(schedule)
+	}
+	return c.JSON(views)
+}
+
+func (h *Handler) GetScheduleHandler(c *fiber.Ctx) error {
+	schedule, err := h.findSchedule(c)
+	if err != nil {
+		return err
+	}
+	return c.JSON(newScheduleView(schedule))
+}
+
+// UpdateScheduleHandler replaces a schedule, the scheduler plans it again from the update on its next tick
+func (h *Handler) UpdateScheduleHandler(c *fiber.Ctx) error {
+	schedule, err := h.findSchedule(c)
+	if err != nil {
+		return err
+	}
+
+	var req ScheduleRequest
+	if err := c.BodyParser(&req); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "Invalid request body.")
+	}
+	if err := req.apply(&schedule); err != nil {
+		return err
+	}
+	if err := h.Store.SaveSchedule(c.Context(), &schedule); err != nil {
+		fmt.Println("DB error:", err)
+		return fiber.NewError(fiber.StatusInternalServerError, "Schedule can not be saved.")
+	}
+
+	return c.JSON(newScheduleView(schedule))
+}
+
+// DeleteScheduleHandler removes a schedule, a run in progress finishes and its history is kept
+func (h *Handler) DeleteScheduleHandler(c *fiber.Ctx) error {
+	schedule, err := h.findSchedule(c)
+	if err != nil {
+		return err
+	}
+	if err := h.Store.DeleteSchedule(c.Context(), schedule.ID); err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			return fiber.NewError(fiber.StatusNotFound, "Schedule not found.")
+		}
+		return fiber.NewError(fiber.StatusInternalServerError, "Schedule can not be deleted.")
+	}
+
+	return c.JSON(fiber.Map{
+		"message": "Schedule deleted.",
+		"id":      schedule.ID,
+	})
+}
+
+// ListRunsHandler returns the run history of a schedule, newest first
+func (h *Handler) ListRunsHandler(c *fiber.Ctx) error {
+	schedule, err := h.findSchedule(c)
+	if err != nil {
+		return err
+	}
+
+	window := pagination(c)
+	runs, err := h.Store.ListRuns(c.Context(), schedule.ID, window)
+	if err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Runs can not be loaded.")
+	}
+
+	return c.JSON(fiber.Map{
+		"schedule_id": schedule.ID,
+		"limit":       window.Limit,
+		"offset":      window.Offset,
+		"items":       runs,
+	})
+}
+
+func (h *Handler) findSchedule(c *fiber.Ctx) (models.CrawlSchedule, error) {
+	id, err := c.ParamsInt("id")
+	if err != nil || id < 1 {
+		return models.CrawlSchedule{}, fiber.NewError(fiber.StatusBadRequest, "Invalid schedule id.")
+	}
+
+	schedule, err := h.Store.GetSchedule(c.Context(), uint(id))
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			return schedule, fiber.NewError(fiber.StatusNotFound, "Schedule not found.")
+		}
+		return schedule, fiber.NewError(fiber.StatusInternalServerError, "Schedule can not be loaded.")
+	}
+	return schedule, nil
+}
+
+// LoadSchedules is the scheduler's source of enabled schedules
+func (h *Handler) LoadSchedules(ctx context.Context) ([]models.CrawlSchedule, error) {
+	return h.Store.ListSchedules(ctx, true)
+}
+
+// RunSchedule crawls a schedule as a regular job, so it shows up in /crawl/jobs and can be cancelled there,
+// and records the run in the schedule's history. Another process that already claimed the run crawls it instead.
+func (h *Handler) RunSchedule(ctx context.Context, schedule models.CrawlSchedule, scheduledAt time.Time) {
+	run := models.CrawlRun{
+		ScheduleID:  schedule.ID,
+		Status:      models.RunRunning,
+		ScheduledAt: scheduledAt,
+		StartedAt:   time.Now(),
+	}
+	if !h.claimRun(ctx, &run) {
+		return
+	}
+
+	total, failed, err := h.runSchedule(ctx, schedule, &run)
+
+	finished := time.Now()
+	run.FinishedAt = &finished
+	run.Total, run.Failed = total, failed
+	run.Status = models.RunCompleted
+	if err != nil {
+		run.Status = models.RunFailed
+		run.Error = err.Error()
+	}
+	if err := h.Store.SaveRun(context.WithoutCancel(ctx), &run); err != nil {
+		fmt.Println("DB error:", err)
+	}
+}
+
+func (h *Handler) runSchedule(ctx context.Context, schedule models.CrawlSchedule, run *models.CrawlRun) (int, int, error) {
+	var req OptionsRequest
+	if len(schedule.Options) > 0 {
+		if err := json.Unmarshal(schedule.Options, &req); err != nil {
+			return 0, 0, err
+		}
+	}
+	opts, timeout, err := req.build()
+	if err != nil {
+		return 0, 0, err
+	}
+
+	// schedules stored before their urls were normalized crawl the same pages as /crawl too
+	urls := normalizeSeeds(schedule.URLs)
+	if err := h.useValidators(ctx, req, urls, &opts); err != nil {
+		return 0, 0, err
+	}
+
+	jobCtx, job, err := h.startJob(ctx, "", len(urls), timeout)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer h.finishJob(job)
+
+	run.JobID = job.ID
+	if err := h.Store.SaveRun(ctx, run); err != nil {
+		fmt.Println("DB error:", err)
+	}
+
+	pages := crawler.DefaultPipeline.Run(jobCtx, urls, opts, h.savePages, nil)
+
+	results := make([]CrawlResult, len(pages))
+	for i, r := range pages {
+		results[i] = newCrawlResult(r)
+	}
+	// a job cancelled through the API or cut off by its timeout didn't complete
+	return len(results), countFailed(results), jobCtx.Err()
+}
+
+// SkipSchedule records a run the scheduler dropped because the previous one was still going
+func (h *Handler) SkipSchedule(ctx context.Context, schedule models.CrawlSchedule, scheduledAt time.Time) {
+	now := time.Now()
+	run := models.CrawlRun{
+		ScheduleID:  schedule.ID,
+		Status:      models.RunSkipped,
+		ScheduledAt: scheduledAt,
+		StartedAt:   now,
+		FinishedAt:  &now,
+		Error:       "previous run still in progress",
+	}
+	h.claimRun(ctx, &run)
+}
+
+// claimRun records the run unless another process got to it first, a deleted schedule isn't run either
+func (h *Handler) claimRun(ctx context.Context, run *models.CrawlRun) bool {
+	err := h.Store.ClaimRun(ctx, run)
+	if err != nil && !errors.Is(err, storage.ErrConflict) && !errors.Is(err, storage.ErrNotFound) {
+		fmt.Println("DB error:", err)
+	}
+	return err == nil
+}
@@ -0,0 +1,166 @@
+package handlers
+
+import (
+	"context"
+	"exercise3/models"
+	"exercise3/storage"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestScheduleHandlers(t *testing.T) {
+	site := newFixtureSite(t)
+	_, app := newTestApp()
+
+	// the urls are stored the way /crawl stores its pages
+	var created scheduleView
+	status := doJSON(t, app, "POST", "/schedules", map[string]any{
+		"name": "news",
+		"cron": "*/15 * * * *",
+		"urls": []string{site.URL + "/news#top", site.URL + "/news", strings.ToUpper(site.URL[:4]) + site.URL[4:] + "/a"},
+	}, &created)
+	if status != http.StatusCreated || created.ID == 0 || !created.Enabled || created.NextRunAt == nil {
+		t.Fatalf("POST /schedules = %d, %+v", status, created)
+	}
+	if want := []string{site.URL + "/news", site.URL + "/a"}; strings.Join(created.URLs, " ") != strings.Join(want, " ") {
+		t.Errorf("urls = %v, want %v", created.URLs, want)
+	}
+	path := fmt.Sprintf("/schedules/%d", created.ID)
+
+	// disabling keeps the schedule, it just isn't due anymore
+	var updated scheduleView
+	status = doJSON(t, app, "PUT", path, map[string]any{
+		"name": "news", "cron": "@hourly", "urls": []string{site.URL + "/news"}, "enabled": false,
+	}, &updated)
+	if status != http.StatusOK || updated.Enabled || updated.NextRunAt != nil || updated.Cron != "@hourly" {
+		t.Errorf("PUT %s = %d, %+v", path, status, updated)
+	}
+
+	var listed []scheduleView
+	if status := doJSON(t, app, "GET", "/schedules", nil, &listed); status != http.StatusOK || len(listed) != 1 || listed[0].ID != created.ID {
+		t.Errorf("GET /schedules = %d, %+v", status, listed)
+	}
+	var runs struct {
+		Items []models.CrawlRun `json:"items"`
+	}
+	if status := doJSON(t, app, "GET", path+"/runs", nil, &runs); status != http.StatusOK || len(runs.Items) != 0 {
+		t.Errorf("GET %s/runs = %d, %+v", path, status, runs)
+	}
+
+	if status := doJSON(t, app, "DELETE", path, nil, nil); status != http.StatusOK {
+		t.Errorf("DELETE %s = %d", path, status)
+	}
+	if status := doJSON(t, app, "GET", path, nil, nil); status != http.StatusNotFound {
+		t.Errorf("GET %s after the delete = %d", path, status)
+	}
+}
+
+func TestScheduleHandlerRejectsBadRequests(t *testing.T) {
+	_, app := newTestApp()
+
+	tests := []struct {
+		name string
+		body map[string]any
+	}{
+		{"bad cron", map[string]any{"cron": "every minute", "urls": []string{"http://example.com"}}},
+		{"no urls", map[string]any{"cron": "@daily"}},
+		{"not crawlable", map[string]any{"cron": "@daily", "urls": []string{"http://example.com", "ftp://example.com/file"}}},
+		{"relative url", map[string]any{"cron": "@daily", "urls": []string{"/page"}}},
+		{"bad options", map[string]any{"cron": "@daily", "urls": []string{"http://example.com"}, "options": map[string]any{"timeout": "soon"}}},
+	}
+	for _, tt := range tests {
+		if status := doJSON(t, app, "POST", "/schedules", tt.body, nil); status != http.StatusBadRequest {
+			t.Errorf("%s: POST /schedules = %d, want 400", tt.name, status)
+		}
+	}
+
+	if status := doJSON(t, app, "PUT", "/schedules/99", map[string]any{"cron": "@daily", "urls": []string{"http://example.com"}}, nil); status != http.StatusNotFound {
+		t.Errorf("PUT of a missing schedule = %d, want 404", status)
+	}
+}
+
+func TestRunSchedule(t *testing.T) {
+	site := newFixtureSite(t)
+	h, app := newTestApp()
+	ctx := context.Background()
+
+	// stored before the urls were normalized, the run still crawls the page /crawl stores
+	schedule := models.CrawlSchedule{Name: "site", Cron: "@hourly", URLs: []string{site.URL + "/a#top", site.URL + "/error"}, Enabled: true}
+	schedule.Options = models.JSONDoc(`{"retry": {"max_attempts": 1}}`)
+	if err := h.Store.CreateSchedule(ctx, &schedule); err != nil {
+		t.Fatalf("CreateSchedule: %v", err)
+	}
+	doJSON(t, app, "POST", "/crawl", map[string]any{"urls": []string{site.URL + "/a"}}, nil)
+
+	scheduledAt := time.Now().Truncate(time.Minute)
+	h.RunSchedule(ctx, schedule, scheduledAt)
+	h.SkipSchedule(ctx, schedule, scheduledAt.Add(time.Minute))
+
+	runs, err := h.Store.ListRuns(ctx, schedule.ID, storage.Window{Limit: 10})
+	if err != nil || len(runs) != 2 {
+		t.Fatalf("runs = %+v, err %v", runs, err)
+	}
+	skipped, run := runs[0], runs[1]
+	if run.Status != models.RunCompleted || run.Total != 2 || run.Failed != 1 || run.JobID == "" ||
+		!run.ScheduledAt.Equal(scheduledAt) || run.FinishedAt == nil {
+		t.Errorf("run = %+v", run)
+	}
+	if skipped.Status != models.RunSkipped || skipped.Error == "" || skipped.JobID != "" {
+		t.Errorf("skipped run = %+v", skipped)
+	}
+
+	pages, total, err := h.Store.ListPages(ctx, storage.PageFilter{Window: storage.Window{Limit: 10}})
+	if err != nil || total != 2 {
+		t.Errorf("%d pages stored, want /a once and /error: %+v, err %v", total, pages, err)
+	}
+
+	// options that no longer build fail the run instead of crawling with defaults
+	schedule.Options = models.JSONDoc(`{"timeout": "soon"}`)
+	h.RunSchedule(ctx, schedule, scheduledAt.Add(2*time.Minute))
+	runs, _ = h.Store.ListRuns(ctx, schedule.ID, storage.Window{Limit: 1})
+	if len(runs) != 1 || runs[0].Status != models.RunFailed || runs[0].Error == "" || runs[0].Total != 0 {
+		t.Errorf("failed run = %+v", runs)
+	}
+	if hits := site.Hits("/a"); hits != 2 {
+		t.Errorf("/a fetched %d times, want once by /crawl and once by the run", hits)
+	}
+}
+
+func TestRunScheduleOnceAcrossProcesses(t *testing.T) {
+	site := newFixtureSite(t)
+	store := storage.NewMemory()
+	ctx := context.Background()
+
+	schedule := models.CrawlSchedule{Name: "a", Cron: "* * * * *", URLs: []string{site.URL + "/a"}, Enabled: true}
+	if err := store.CreateSchedule(ctx, &schedule); err != nil {
+		t.Fatalf("CreateSchedule: %v", err)
+	}
+
+	// every process on the database fires the schedule at the same minute, one of them crawls it
+	scheduledAt := time.Now().Truncate(time.Minute)
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func(h *Handler) {
+			defer wg.Done()
+			h.RunSchedule(ctx, schedule, scheduledAt)
+			h.SkipSchedule(ctx, schedule, scheduledAt)
+		}(New(store))
+	}
+	wg.Wait()
+
+	runs, err := store.ListRuns(ctx, schedule.ID, storage.Window{Limit: 10})
+	if err != nil || len(runs) != 1 || runs[0].Status != models.RunCompleted || site.Hits("/a") != 1 {
+		t.Fatalf("runs = %+v, err %v, %d crawls", runs, err, site.Hits("/a"))
+	}
+
+	// the next minute is a run of its own
+	New(store).RunSchedule(ctx, schedule, scheduledAt.Add(time.Minute))
+	if runs, _ := store.ListRuns(ctx, schedule.ID, storage.Window{Limit: 10}); len(runs) != 2 || site.Hits("/a") != 2 {
+		t.Errorf("runs = %+v, %d crawls", runs, site.Hits("/a"))
+	}
+}
@@ -0,0 +1,141 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"exercise3/crawler"
+	"exercise3/models"
+	"fmt"
+	"github.com/gofiber/fiber/v2"
+)
+
+// SitemapCrawlHandler discovers the URLs of a site from its sitemaps and crawls them, highest priority first.
+// Pages whose sitemap lastmod isn't newer than their last successful crawl are reported as unchanged
+// without being fetched, unless force is set.
+func (h *Handler) SitemapCrawlHandler(c *fiber.Ctx) error {
+	type SitemapRequest struct {
+		Site     string   `json:"site"`
+		Sitemaps []string `json:"sitemaps"`
+		Limit    int      `json:"limit"`
+		Force    bool     `json:"force"`
+	}
+
+	var sitemapReq SitemapRequest
+	if err := c.BodyParser(&sitemapReq); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "Invalid request body.")
+	}
+	req, opts, timeout, err := parseCrawlRequest(c)
+	if err != nil {
+		return err
+	}
+
+	// the discovery is part of the job, its timeout and cancellation cover the sitemap fetches too
+	ctx, job, err := h.startJob(c.Context(), req.JobID, 0, timeout)
+	if err != nil {
+		return err
+	}
+	defer h.finishJob(job)
+	job.watchClient(c)
+
+	sitemaps := sitemapReq.Sitemaps
+	if len(sitemaps) == 0 {
+		if sitemaps, err = crawler.SiteSitemaps(ctx, sitemapReq.Site, opts.Client); err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, "A valid site or a list of sitemaps is required.")
+		}
+	}
+
+	entries, err := crawler.DiscoverSitemaps(ctx, sitemaps, sitemapReq.Limit, opts.Client)
+	if errors.Is(err, crawler.ErrNoSitemap) {
+		return fiber.NewError(fiber.StatusBadGateway, "No sitemap could be read.")
+	}
+	if err != nil {
+		return fiber.NewError(fiber.StatusServiceUnavailable, "Sitemap discovery was cancelled.")
+	}
+
+	crawled, err := h.lastCrawls(ctx, entries)
+	if err != nil {
+		fmt.Println("DB error:", err)
+		return fiber.NewError(fiber.StatusInternalServerError, "Pages can not be loaded.")
+	}
+
+	bySitemap := make(map[string]crawler.SitemapURL, len(entries))
+	var urls []string
+	unchanged := []CrawlResult{}
+	for _, entry := range entries {
+		page, ok := crawled[entry.Loc]
+		if ok && !sitemapReq.Force && !entry.LastMod.IsZero() && !page.LastCrawledAt.Before(entry.LastMod) {
+			unchanged = append(unchanged, CrawlResult{
+				PageID:  page.ID,
+				Version: page.LatestVersion,
+				URL:     entry.Loc,
+				Status:  crawler.StatusUnchanged,
+			})
+			continue
+		}
+		bySitemap[entry.Loc] = entry
+		urls = append(urls, entry.Loc)
+	}
+
+	if err := h.useValidators(ctx, req.OptionsRequest, urls, &opts); err != nil {
+		return err
+	}
+	h.setJobURLs(job, len(urls))
+
+	persist := func(ctx context.Context, batch []*crawler.PageResult) {
+		h.savePages(ctx, batch)
+		h.saveSitemapEntries(ctx, batch, bySitemap)
+	}
+	pages := crawler.DefaultPipeline.Run(ctx, urls, opts, persist, nil)
+
+	results := make([]CrawlResult, len(pages))
+	for i, r := range pages {
+		results[i] = newCrawlResult(r)
+	}
+
+	return c.JSON(fiber.Map{
+		"message":     "Crawl completed.",
+		"job_id":      job.ID,
+		"discovered":  len(entries),
+		"unchanged":   len(unchanged),
+		"total":       len(results),
+		"failed":      countFailed(results),
+		"bytes_saved": bytesSaved(results),
+		"results":     append(results, unchanged...),
+	})
+}
+
+// lastCrawls loads the successfully crawled pages among the sitemap entries by URL, a 304 counts as a success
+func (h *Handler) lastCrawls(ctx context.Context, entries []crawler.SitemapURL) (map[string]models.CrawlPage, error) {
+	urls := make([]string, len(entries))
+	for i, entry := range entries {
+		urls[i] = entry.Loc
+	}
+
+	pages, err := h.Store.PagesByURL(ctx, urls)
+	if err != nil {
+		return nil, err
+	}
+	for url, page := range pages {
+		if page.Status != crawler.StatusSuccess && page.Status != crawler.StatusNotModified {
+			delete(pages, url)
+		}
+	}
+	return pages, nil
+}
+
+// saveSitemapEntries records the lastmod and priority the sitemap gave the stored pages of a batch
+func (h *Handler) saveSitemapEntries(ctx context.Context, batch []*crawler.PageResult, bySitemap map[string]crawler.SitemapURL) {
+	entries := make(map[uint]crawler.SitemapURL)
+	for _, r := range batch {
+		if entry, ok := bySitemap[r.URL]; ok && r.PageID != 0 {
+			entries[r.PageID] = entry
+		}
+	}
+	if len(entries) == 0 {
+		return
+	}
+
+	if err := h.Store.SetSitemapEntries(ctx, entries); err != nil {
+		fmt.Println("DB error:", err)
+	}
+}
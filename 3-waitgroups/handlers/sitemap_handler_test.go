@@ -0,0 +1,70 @@
+package handlers
+
+import (
+	"exercise3/crawler"
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+type sitemapResponse struct {
+	crawlResponse
+	Discovered int `json:"discovered"`
+	Unchanged  int `json:"unchanged"`
+}
+
+func TestSitemapCrawlHandlerSkipsUnchanged(t *testing.T) {
+	site := newFixtureSite(t)
+	// /a last changed long ago, /b changes after every crawl, /news doesn't say and /error never got stored
+	site.Handle("/sitemap.xml", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/xml")
+		fmt.Fprintf(w, `<?xml version="1.0" encoding="UTF-8"?>
+<urlset xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">
+<url><loc>%[1]s/a</loc><lastmod>2020-01-01</lastmod></url>
+<url><loc>%[1]s/b</loc><lastmod>2100-01-01</lastmod></url>
+<url><loc>%[1]s/news</loc></url>
+<url><loc>%[1]s/error</loc><lastmod>2020-01-01</lastmod></url>
+</urlset>`, site.URL)
+	})
+	_, app := newTestApp()
+	body := map[string]any{"sitemaps": []string{site.URL + "/sitemap.xml"}, "retry": map[string]any{"max_attempts": 1}}
+
+	var first, second, forced sitemapResponse
+	if status := doJSON(t, app, "POST", "/crawl/sitemap", body, &first); status != http.StatusOK {
+		t.Fatalf("POST /crawl/sitemap = %d", status)
+	}
+	if first.Discovered != 4 || first.Unchanged != 0 || first.Total != 4 || first.Failed != 1 {
+		t.Fatalf("first crawl: %+v", first)
+	}
+
+	// only the page whose lastmod is older than its crawl is skipped
+	doJSON(t, app, "POST", "/crawl/sitemap", body, &second)
+	if second.Discovered != 4 || second.Unchanged != 1 || second.Total != 3 || len(second.Results) != 4 {
+		t.Fatalf("second crawl: %+v", second)
+	}
+	statuses := make(map[string]string)
+	for _, r := range second.Results {
+		statuses[r.URL] = r.Status
+	}
+	want := map[string]string{
+		site.URL + "/a":     crawler.StatusUnchanged,
+		site.URL + "/b":     crawler.StatusSuccess,
+		site.URL + "/news":  crawler.StatusNotModified,
+		site.URL + "/error": crawler.StatusHTTPError,
+	}
+	for url, status := range want {
+		if statuses[url] != status {
+			t.Errorf("%s = %q, want %q", url, statuses[url], status)
+		}
+	}
+	if hits := site.Hits("/a"); hits != 1 {
+		t.Errorf("/a fetched %d times, want once", hits)
+	}
+
+	// force fetches every page again
+	body["force"] = true
+	doJSON(t, app, "POST", "/crawl/sitemap", body, &forced)
+	if forced.Unchanged != 0 || forced.Total != 4 || site.Hits("/a") != 2 {
+		t.Errorf("forced crawl: %+v, /a fetched %d times", forced, site.Hits("/a"))
+	}
+}
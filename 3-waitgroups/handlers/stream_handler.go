@@ -0,0 +1,150 @@
+package handlers
+
+import (
+	"bufio"
+	"encoding/json"
+	"exercise3/crawler"
+	"fmt"
+	"github.com/gofiber/fiber/v2"
+	"sync"
+	"time"
+)
+
+// streamHeartbeat keeps idle event streams from being closed by proxies while slow pages are fetched
+const streamHeartbeat = 15 * time.Second
+
+type streamEvent struct {
+	name string
+	data any
+}
+
+// eventQueue is unbounded on purpose: the pipeline pushes without ever blocking,
+// so a client that reads slowly only delays its own stream, never the crawl.
+type eventQueue struct {
+	mu     sync.Mutex
+	events []streamEvent
+	closed bool
+	ready  chan struct{}
+}
+
+func newEventQueue() *eventQueue {
+	return &eventQueue{ready: make(chan struct{}, 1)}
+}
+
+func (q *eventQueue) push(name string, data any) {
+	q.mu.Lock()
+	q.events = append(q.events, streamEvent{name: name, data: data})
+	q.mu.Unlock()
+	q.signal()
+}
+
+func (q *eventQueue) close() {
+	q.mu.Lock()
+	q.closed = true
+	q.mu.Unlock()
+	q.signal()
+}
+
+func (q *eventQueue) signal() {
+	select {
+	case q.ready <- struct{}{}:
+	default:
+	}
+}
+
+// drain takes every queued event, closed reports that no more will follow
+func (q *eventQueue) drain() (events []streamEvent, closed bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	events, q.events = q.events, nil
+	return events, q.closed
+}
+
+// CrawlStreamHandler runs a crawl like CrawlHandler but answers with Server-Sent Events:
+// a job event first, a result event per page as soon as it is persisted and a summary event at the end.
+// A client that disconnects cancels its job, pages that were already written stay stored.
+func (h *Handler) CrawlStreamHandler(c *fiber.Ctx) error {
+	req, opts, timeout, err := parseCrawlRequest(c)
+	if err != nil {
+		return err
+	}
+
+	if err := h.useValidators(c.Context(), req.OptionsRequest, req.URLs, &opts); err != nil {
+		return err
+	}
+
+	// the request context is recycled as soon as this handler returns, the job outlives it until the server shuts down
+	ctx, job, err := h.startJob(h.Shutdown, req.JobID, len(req.URLs), timeout)
+	if err != nil {
+		return err
+	}
+
+	events := newEventQueue()
+	events.push("job", fiber.Map{"job_id": job.ID, "total": len(req.URLs)})
+
+	go func() {
+		defer h.finishJob(job)
+		defer events.close()
+
+		start := time.Now()
+		pages := crawler.DefaultPipeline.Run(ctx, req.URLs, opts, h.savePages, func(r crawler.PageResult) {
+			events.push("result", newCrawlResult(r))
+		})
+
+		results := make([]CrawlResult, len(pages))
+		for i, r := range pages {
+			results[i] = newCrawlResult(r)
+		}
+		events.push("summary", fiber.Map{
+			"message":     "Crawl completed.",
+			"job_id":      job.ID,
+			"total":       len(results),
+			"failed":      countFailed(results),
+			"bytes_saved": bytesSaved(results),
+			"took":        time.Since(start).String(),
+		})
+	}()
+
+	c.Set(fiber.HeaderContentType, "text/event-stream")
+	c.Set(fiber.HeaderCacheControl, "no-cache")
+	c.Set(fiber.HeaderConnection, "keep-alive")
+	c.Set("X-Accel-Buffering", "no")
+
+	c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		heartbeat := time.NewTicker(streamHeartbeat)
+		defer heartbeat.Stop()
+
+		for {
+			pending, closed := events.drain()
+			for _, event := range pending {
+				writeEvent(w, event)
+			}
+			// a failed flush is the only sign fasthttp gives of a client that went away
+			if err := w.Flush(); err != nil {
+				job.cancel()
+				return
+			}
+			if closed {
+				return
+			}
+
+			select {
+			case <-events.ready:
+			case <-heartbeat.C:
+				fmt.Fprint(w, ": ping\n\n")
+			}
+		}
+	})
+
+	return nil
+}
+
+func writeEvent(w *bufio.Writer, event streamEvent) {
+	data, err := json.Marshal(event.data)
+	if err != nil {
+		fmt.Println("Stream error:", err)
+		return
+	}
+	fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.name, data)
+}
@@ -0,0 +1,135 @@
+package handlers
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"exercise3/crawler"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+type sseEvent struct {
+	name string
+	data string
+}
+
+// readEvents splits an event stream into its events, comments such as the heartbeat are skipped
+func readEvents(t *testing.T, scanner *bufio.Scanner) []sseEvent {
+	t.Helper()
+
+	var events []sseEvent
+	var event sseEvent
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case line == "":
+			if event.name != "" {
+				events = append(events, event)
+			}
+			event = sseEvent{}
+		case strings.HasPrefix(line, "event: "):
+			event.name = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			event.data = strings.TrimPrefix(line, "data: ")
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		t.Fatalf("reading the stream: %v", err)
+	}
+	return events
+}
+
+func TestCrawlStreamHandler(t *testing.T) {
+	site := newFixtureSite(t)
+	_, app := newTestApp()
+
+	urls := []string{site.URL + "/a", site.URL + "/b", site.URL + "/error"}
+	raw, _ := json.Marshal(map[string]any{"urls": urls, "retry": fastRetry})
+	req := httptest.NewRequest("POST", "/crawl/stream", strings.NewReader(string(raw)))
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := app.Test(req, -1)
+	if err != nil {
+		t.Fatalf("POST /crawl/stream: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("got %d %q, want an event stream", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+
+	events := readEvents(t, bufio.NewScanner(resp.Body))
+	if len(events) != len(urls)+2 {
+		t.Fatalf("got %d events, want a job, %d results and a summary: %v", len(events), len(urls), events)
+	}
+
+	var job struct {
+		JobID string `json:"job_id"`
+		Total int    `json:"total"`
+	}
+	if events[0].name != "job" || json.Unmarshal([]byte(events[0].data), &job) != nil || job.JobID == "" || job.Total != len(urls) {
+		t.Errorf("first event = %v, want the job", events[0])
+	}
+
+	// results arrive in the order their batches are written, not in url order
+	seen := make(map[string]string)
+	for _, event := range events[1 : len(events)-1] {
+		var r CrawlResult
+		if event.name != "result" || json.Unmarshal([]byte(event.data), &r) != nil {
+			t.Errorf("got %v, want a result", event)
+			continue
+		}
+		if r.PageID == 0 {
+			t.Errorf("%s was streamed before it was stored", r.URL)
+		}
+		seen[r.URL] = r.Status
+	}
+	want := map[string]string{urls[0]: crawler.StatusSuccess, urls[1]: crawler.StatusSuccess, urls[2]: crawler.StatusHTTPError}
+	for url, status := range want {
+		if seen[url] != status {
+			t.Errorf("%s streamed as %q, want %q", url, seen[url], status)
+		}
+	}
+
+	var summary crawlResponse
+	last := events[len(events)-1]
+	if last.name != "summary" || json.Unmarshal([]byte(last.data), &summary) != nil {
+		t.Fatalf("last event = %v, want the summary", last)
+	}
+	if summary.JobID != job.JobID || summary.Total != len(urls) || summary.Failed != 1 {
+		t.Errorf("summary = %+v", summary)
+	}
+}
+
+func TestCrawlStreamHandlerShutdown(t *testing.T) {
+	site := newFixtureSite(t)
+	h, app := newTestApp()
+	shutdown, stop := context.WithCancel(context.Background())
+	h.Shutdown = shutdown
+
+	raw, _ := json.Marshal(map[string]any{"urls": []string{site.URL + "/slow"}, "retry": map[string]any{"max_attempts": 1}})
+	req := httptest.NewRequest("POST", "/crawl/stream", strings.NewReader(string(raw)))
+	req.Header.Set("Content-Type", "application/json")
+
+	// the server shuts down while /slow is still being fetched, the stream ends with the canceled page
+	time.AfterFunc(100*time.Millisecond, stop)
+	start := time.Now()
+	resp, err := app.Test(req, -1)
+	if err != nil {
+		t.Fatalf("POST /crawl/stream: %v", err)
+	}
+	defer resp.Body.Close()
+
+	events := readEvents(t, bufio.NewScanner(resp.Body))
+	if took := time.Since(start); took > time.Second {
+		t.Errorf("stream took %s, the shutdown didn't end the job", took)
+	}
+	var r CrawlResult
+	if len(events) != 3 || json.Unmarshal([]byte(events[1].data), &r) != nil || r.Status != crawler.StatusCanceled {
+		t.Errorf("events = %v, want the job, a canceled result and the summary", events)
+	}
+}
@@ -0,0 +1,86 @@
+// Package testsite serves the local sites the crawler and handler tests crawl
+package testsite
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Site is a local site that counts the requests per path. It starts with the pages both suites crawl,
+// Handle adds the ones of a single test.
+type Site struct {
+	*httptest.Server
+
+	mux *http.ServeMux
+
+	mu   sync.Mutex
+	hits map[string]int
+}
+
+// New starts a site with a robots.txt disallowing /private, the /a <-> /b link cycle, /slow, which answers
+// after 2s unless the client gives up first, /error and /data.json. The site closes when the test ends.
+func New(t testing.TB) *Site {
+	t.Helper()
+
+	site := &Site{mux: http.NewServeMux(), hits: make(map[string]int)}
+
+	site.Handle("/robots.txt", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "User-agent: *\nDisallow: /private\n")
+	})
+
+	// /a -> /b -> /a and back to /, /b also links to itself
+	site.Handle("/a", func(w http.ResponseWriter, r *http.Request) {
+		WriteHTML(w, `<html><head><title>A</title></head><body><h1>Page A</h1><a href="b">B</a><a href="/">Home</a></body></html>`)
+	})
+	site.Handle("/b", func(w http.ResponseWriter, r *http.Request) {
+		WriteHTML(w, `<html><head><title>B</title></head><body><h2>Page B</h2><a href="/a#top">A</a><a href="/b">Itself</a></body></html>`)
+	})
+
+	site.Handle("/slow", func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-time.After(2 * time.Second):
+			WriteHTML(w, `<html><body><p>finally</p></body></html>`)
+		case <-r.Context().Done():
+		}
+	})
+	site.Handle("/error", func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "boom", http.StatusInternalServerError)
+	})
+	site.Handle("/data.json", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"html": "<h1>not a page</h1>"}`)
+	})
+
+	site.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		site.mu.Lock()
+		site.hits[r.URL.Path]++
+		site.mu.Unlock()
+
+		site.mux.ServeHTTP(w, r)
+	}))
+	t.Cleanup(site.Close)
+
+	return site
+}
+
+// Handle serves pattern with handler, the patterns are those of http.ServeMux
+func (s *Site) Handle(pattern string, handler http.HandlerFunc) {
+	s.mux.HandleFunc(pattern, handler)
+}
+
+// Hits is how often path was requested
+func (s *Site) Hits(path string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.hits[path]
+}
+
+// WriteHTML answers with body as a UTF-8 HTML page
+func WriteHTML(w http.ResponseWriter, body string) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	fmt.Fprint(w, body)
+}
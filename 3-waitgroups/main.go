@@ -1,20 +1,56 @@
 package main
 
 import (
+	"context"
 	"exercise3/config"
+	"exercise3/crawler"
 	"exercise3/handlers"
+	"exercise3/scheduler"
+	"exercise3/storage"
 	"exercise3/utils"
 	"github.com/gofiber/fiber/v2"
 	"log"
+	"os"
+	"os/signal"
+	"syscall"
 )
 
 func main() {
 	utils.LoadEnv()
-	config.ConnectDatabase()
+	store := openStore()
+
+	configureCrawler()
+
+	// SIGINT and SIGTERM stop the server and the background loops, the frontier worker gives its leases back
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
 	app := fiber.New()
 
-	app.Post("/crawl", handlers.CrawlHandler)
+	h := handlers.New(store)
+	h.Shutdown = ctx
+	h.Register(app)
+
+	h.StartPageRankJob(ctx, utils.GetEnvDuration("GRAPH_INTERVAL", handlers.DefaultGraphInterval))
+	h.StartDuplicateJob(ctx, utils.GetEnvDuration("DUPLICATE_INTERVAL", handlers.DefaultDuplicateInterval))
+	h.StartFrontierWorker(ctx, frontierConfig())
+
+	crawls := scheduler.New(scheduler.RealClock{})
+	crawls.Load = h.LoadSchedules
+	crawls.Run = h.RunSchedule
+	crawls.Skip = h.SkipSchedule
+	scheduled := make(chan struct{})
+	go func() {
+		defer close(scheduled)
+		crawls.Start(ctx)
+	}()
+
+	go func() {
+		<-ctx.Done()
+		if err := app.Shutdown(); err != nil {
+			log.Println("Shutdown error:", err)
+		}
+	}()
 
 	port := utils.GetEnv("APP_PORT", "1234")
 
@@ -23,4 +59,93 @@ func main() {
 	if err != nil {
 		log.Fatal("Port can not listening")
 	}
+
+	<-scheduled
+	h.Wait()
+}
+
+// openStore picks the persistence backend with STORAGE, memory keeps everything in the process and loses it on exit
+func openStore() storage.Store {
+	switch backend := utils.GetEnv("STORAGE", "postgres"); backend {
+	case "memory":
+		return storage.NewMemory()
+	case "postgres":
+		db, err := config.ConnectDatabase()
+		if err != nil {
+			log.Fatal("Database connection error: ", err)
+		}
+		store, err := storage.NewPostgres(db)
+		if err != nil {
+			log.Fatal("Database migration error: ", err)
+		}
+		return store
+	default:
+		log.Fatal("invalid STORAGE: ", backend)
+		return nil
+	}
+}
+
+// frontierConfig reads the settings of the worker crawling distributed jobs, FRONTIER_BATCH=0 turns it off
+func frontierConfig() handlers.FrontierConfig {
+	defaults := handlers.DefaultFrontierConfig
+	return handlers.FrontierConfig{
+		Owner:        utils.GetEnv("FRONTIER_WORKER_ID", ""),
+		BatchSize:    utils.GetEnvInt("FRONTIER_BATCH", defaults.BatchSize),
+		LeaseTTL:     utils.GetEnvDuration("FRONTIER_LEASE_TTL", defaults.LeaseTTL),
+		PollInterval: utils.GetEnvDuration("FRONTIER_POLL_INTERVAL", defaults.PollInterval),
+		MaxLeases:    utils.GetEnvInt("FRONTIER_MAX_LEASES", defaults.MaxLeases),
+	}
+}
+
+// configureCrawler replaces the crawler's shared pool, rate limiter and robots cache with the env settings
+func configureCrawler() {
+	crawler.UserAgent = utils.GetEnv("CRAWL_USER_AGENT", crawler.UserAgent)
+	crawler.DefaultRobots.TTL = utils.GetEnvDuration("ROBOTS_TTL", crawler.DefaultRobotsTTL)
+	crawler.FetchTimeout = utils.GetEnvDuration("FETCH_TIMEOUT", crawler.DefaultFetchTimeout)
+	crawler.MaxBodySize = int64(utils.GetEnvInt("CRAWL_MAX_BODY_BYTES", crawler.DefaultMaxBodySize))
+	handlers.DefaultJobTimeout = utils.GetEnvDuration("CRAWL_JOB_TIMEOUT", handlers.DefaultJobTimeout)
+
+	crawler.DefaultRetry.MaxAttempts = utils.GetEnvInt("RETRY_MAX_ATTEMPTS", crawler.DefaultRetry.MaxAttempts)
+	crawler.DefaultRetry.BaseDelay = utils.GetEnvDuration("RETRY_BASE_DELAY", crawler.DefaultRetry.BaseDelay)
+	crawler.DefaultRetry.MaxDelay = utils.GetEnvDuration("RETRY_MAX_DELAY", crawler.DefaultRetry.MaxDelay)
+	if codes := utils.GetEnv("RETRY_STATUS_CODES", ""); codes != "" {
+		statuses, err := utils.ParseIntList(codes)
+		if err != nil {
+			log.Fatal("invalid RETRY_STATUS_CODES: ", err)
+		}
+		crawler.DefaultRetry.RetryableStatus = statuses
+	}
+
+	burst := utils.GetEnvInt("CRAWL_BURST", crawler.DefaultBurst)
+	overrides, err := crawler.ParseRateOverrides(utils.GetEnv("CRAWL_RATE_OVERRIDES", ""), burst)
+	if err != nil {
+		log.Fatal("invalid CRAWL_RATE_OVERRIDES: ", err)
+	}
+
+	crawler.DefaultPool = crawler.NewPool(
+		utils.GetEnvInt("CRAWL_WORKERS", crawler.DefaultWorkers),
+		utils.GetEnvInt("CRAWL_PER_HOST", crawler.DefaultPerHost),
+	)
+	crawler.UseLimiter(crawler.NewHostLimiter(crawler.Limit{
+		Rate:  utils.GetEnvFloat("CRAWL_RATE", crawler.DefaultRate),
+		Burst: burst,
+	}, overrides))
+
+	crawler.DefaultLinkChecker = crawler.NewLinkChecker(utils.GetEnvDuration("LINK_CACHE_TTL", crawler.DefaultLinkCacheTTL))
+	crawler.DefaultLinkChecker.Timeout = utils.GetEnvDuration("LINK_CHECK_TIMEOUT", crawler.DefaultLinkTimeout)
+
+	crawler.DefaultPipeline = crawler.Pipeline{
+		Fetchers:      utils.GetEnvInt("PIPELINE_FETCHERS", crawler.DefaultPool.Size()),
+		Parsers:       utils.GetEnvInt("PIPELINE_PARSERS", crawler.DefaultPipeline.Parsers),
+		Writers:       utils.GetEnvInt("PIPELINE_WRITERS", crawler.DefaultPipeline.Writers),
+		BatchSize:     utils.GetEnvInt("PIPELINE_BATCH_SIZE", crawler.DefaultPipeline.BatchSize),
+		FlushInterval: utils.GetEnvDuration("PIPELINE_FLUSH_INTERVAL", crawler.DefaultPipeline.FlushInterval),
+	}
+
+	handlers.PageRankOptions.Damping = utils.GetEnvFloat("GRAPH_DAMPING", handlers.PageRankOptions.Damping)
+	handlers.PageRankOptions.Workers = utils.GetEnvInt("GRAPH_WORKERS", handlers.PageRankOptions.Workers)
+	handlers.DuplicateDistance = utils.GetEnvInt("SIMHASH_MAX_DISTANCE", handlers.DuplicateDistance)
+	if d := handlers.DuplicateDistance; d < 0 || d > handlers.MaxDuplicateDistance {
+		log.Fatalf("invalid SIMHASH_MAX_DISTANCE: %d, it must be between 0 and %d", d, handlers.MaxDuplicateDistance)
+	}
 }
@@ -0,0 +1,411 @@
+// Package metrics is a small Prometheus client: counters, gauges and histograms,
+// optionally split by labels, written in the text exposition format.
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// ContentType is the media type of the text exposition format
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// DefaultBuckets are the Prometheus default latency buckets, in seconds
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// collector is a registered metric family
+type collector interface {
+	name() string
+	collect(w *bufio.Writer)
+}
+
+// Registry holds metric families and writes them sorted by name
+type Registry struct {
+	mu         sync.Mutex
+	collectors map[string]collector
+}
+
+// Default is the registry the package level constructors register with
+var Default = NewRegistry()
+
+func NewRegistry() *Registry {
+	return &Registry{collectors: make(map[string]collector)}
+}
+
+// register panics on a duplicate name, that is a programming error like in the Prometheus client
+func (r *Registry) register(c collector) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, exists := r.collectors[c.name()]; exists {
+		panic("metrics: duplicate metric " + c.name())
+	}
+	r.collectors[c.name()] = c
+}
+
+// WriteTo writes every family in the text exposition format
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	r.mu.Lock()
+	collectors := make([]collector, 0, len(r.collectors))
+	for _, c := range r.collectors {
+		collectors = append(collectors, c)
+	}
+	r.mu.Unlock()
+
+	sort.Slice(collectors, func(i, j int) bool {
+		return collectors[i].name() < collectors[j].name()
+	})
+
+	counter := &countingWriter{w: w}
+	buf := bufio.NewWriter(counter)
+	for _, c := range collectors {
+		c.collect(buf)
+	}
+	err := buf.Flush()
+	return counter.n, err
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// desc is the name, help and label names of a family
+type desc struct {
+	fqName string
+	help   string
+	kind   string
+	labels []string
+}
+
+func (d *desc) name() string {
+	return d.fqName
+}
+
+func (d *desc) header(w *bufio.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", d.fqName, escapeHelp(d.help), d.fqName, d.kind)
+}
+
+// labelPairs renders values as {name="value",...}, extra is appended as is (the histogram's le)
+func (d *desc) labelPairs(values []string, extra string) string {
+	if len(values) == 0 && extra == "" {
+		return ""
+	}
+
+	pairs := make([]string, 0, len(values)+1)
+	for i, value := range values {
+		pairs = append(pairs, d.labels[i]+`="`+escapeLabel(value)+`"`)
+	}
+	if extra != "" {
+		pairs = append(pairs, extra)
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func escapeHelp(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(s)
+}
+
+func escapeLabel(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`).Replace(s)
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// Counter only goes up
+type Counter struct {
+	*desc
+	v atomic.Uint64
+}
+
+func (r *Registry) NewCounter(name, help string) *Counter {
+	c := &Counter{desc: &desc{fqName: name, help: help, kind: "counter"}}
+	r.register(c)
+	return c
+}
+
+func NewCounter(name, help string) *Counter {
+	return Default.NewCounter(name, help)
+}
+
+func (c *Counter) Inc() {
+	c.v.Add(1)
+}
+
+func (c *Counter) Add(n uint64) {
+	c.v.Add(n)
+}
+
+func (c *Counter) Value() uint64 {
+	return c.v.Load()
+}
+
+func (c *Counter) collect(w *bufio.Writer) {
+	c.header(w)
+	fmt.Fprintf(w, "%s %d\n", c.fqName, c.Value())
+}
+
+// Gauge goes up and down
+type Gauge struct {
+	*desc
+	v atomic.Int64
+}
+
+func (r *Registry) NewGauge(name, help string) *Gauge {
+	g := &Gauge{desc: &desc{fqName: name, help: help, kind: "gauge"}}
+	r.register(g)
+	return g
+}
+
+func NewGauge(name, help string) *Gauge {
+	return Default.NewGauge(name, help)
+}
+
+func (g *Gauge) Inc() {
+	g.v.Add(1)
+}
+
+func (g *Gauge) Dec() {
+	g.v.Add(-1)
+}
+
+func (g *Gauge) Set(v int64) {
+	g.v.Store(v)
+}
+
+func (g *Gauge) Value() int64 {
+	return g.v.Load()
+}
+
+func (g *Gauge) collect(w *bufio.Writer) {
+	g.header(w)
+	fmt.Fprintf(w, "%s %d\n", g.fqName, g.Value())
+}
+
+// GaugeFunc is a gauge read from fn at every scrape, for values another component already tracks
+type GaugeFunc struct {
+	*desc
+	fn func() float64
+}
+
+func (r *Registry) NewGaugeFunc(name, help string, fn func() float64) *GaugeFunc {
+	g := &GaugeFunc{desc: &desc{fqName: name, help: help, kind: "gauge"}, fn: fn}
+	r.register(g)
+	return g
+}
+
+func NewGaugeFunc(name, help string, fn func() float64) *GaugeFunc {
+	return Default.NewGaugeFunc(name, help, fn)
+}
+
+func (g *GaugeFunc) collect(w *bufio.Writer) {
+	g.header(w)
+	fmt.Fprintf(w, "%s %s\n", g.fqName, formatFloat(g.fn()))
+}
+
+// Histogram counts observations into cumulative buckets
+type Histogram struct {
+	*desc
+	buckets []float64
+
+	mu     sync.Mutex
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+func newHistogram(d *desc, buckets []float64) *Histogram {
+	if len(buckets) == 0 {
+		buckets = DefaultBuckets
+	}
+	buckets = append([]float64(nil), buckets...)
+	sort.Float64s(buckets)
+
+	return &Histogram{desc: d, buckets: buckets, counts: make([]uint64, len(buckets))}
+}
+
+// NewHistogram registers a histogram, nil buckets mean DefaultBuckets
+func (r *Registry) NewHistogram(name, help string, buckets []float64) *Histogram {
+	h := newHistogram(&desc{fqName: name, help: help, kind: "histogram"}, buckets)
+	r.register(h)
+	return h
+}
+
+func NewHistogram(name, help string, buckets []float64) *Histogram {
+	return Default.NewHistogram(name, help, buckets)
+}
+
+func (h *Histogram) Observe(v float64) {
+	i := sort.SearchFloat64s(h.buckets, v)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if i < len(h.counts) {
+		h.counts[i]++
+	}
+	h.sum += v
+	h.count++
+}
+
+// Count is the number of observations so far
+func (h *Histogram) Count() uint64 {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.count
+}
+
+func (h *Histogram) collect(w *bufio.Writer) {
+	h.header(w)
+	h.writeSamples(w, nil)
+}
+
+// writeSamples writes the cumulative buckets, sum and count, labeled with values
+func (h *Histogram) writeSamples(w *bufio.Writer, values []string) {
+	h.mu.Lock()
+	counts := append([]uint64(nil), h.counts...)
+	sum, count := h.sum, h.count
+	h.mu.Unlock()
+
+	var cumulative uint64
+	for i, bound := range h.buckets {
+		cumulative += counts[i]
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.fqName, h.labelPairs(values, `le="`+formatFloat(bound)+`"`), cumulative)
+	}
+	fmt.Fprintf(w, "%s_bucket%s %d\n", h.fqName, h.labelPairs(values, `le="+Inf"`), count)
+	fmt.Fprintf(w, "%s_sum%s %s\n", h.fqName, h.labelPairs(values, ""), formatFloat(sum))
+	fmt.Fprintf(w, "%s_count%s %d\n", h.fqName, h.labelPairs(values, ""), count)
+}
+
+// vec keeps one child per combination of label values
+type vec[T any] struct {
+	*desc
+	newChild func() T
+
+	mu       sync.Mutex
+	children map[string]T
+	values   map[string][]string
+}
+
+func newVec[T any](d *desc, newChild func() T) *vec[T] {
+	return &vec[T]{desc: d, newChild: newChild, children: make(map[string]T), values: make(map[string][]string)}
+}
+
+// with returns the child of the label values, they are given in the order the labels were declared
+func (v *vec[T]) with(values []string) T {
+	if len(values) != len(v.labels) {
+		panic(fmt.Sprintf("metrics: %s takes %d label values, got %d", v.fqName, len(v.labels), len(values)))
+	}
+	key := strings.Join(values, "\xff")
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	child, ok := v.children[key]
+	if !ok {
+		child = v.newChild()
+		v.children[key] = child
+		v.values[key] = append([]string(nil), values...)
+	}
+	return child
+}
+
+// each calls fn for every child, ordered by label values
+func (v *vec[T]) each(fn func(values []string, child T)) {
+	type entry struct {
+		key    string
+		values []string
+		child  T
+	}
+
+	v.mu.Lock()
+	entries := make([]entry, 0, len(v.children))
+	for key, child := range v.children {
+		entries = append(entries, entry{key, v.values[key], child})
+	}
+	v.mu.Unlock()
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].key < entries[j].key
+	})
+	for _, e := range entries {
+		fn(e.values, e.child)
+	}
+}
+
+// CounterVec is a counter split by labels
+type CounterVec struct {
+	*vec[*Counter]
+}
+
+func (r *Registry) NewCounterVec(name, help string, labels ...string) *CounterVec {
+	d := &desc{fqName: name, help: help, kind: "counter", labels: labels}
+	c := &CounterVec{newVec(d, func() *Counter { return &Counter{desc: d} })}
+	r.register(c)
+	return c
+}
+
+func NewCounterVec(name, help string, labels ...string) *CounterVec {
+	return Default.NewCounterVec(name, help, labels...)
+}
+
+func (c *CounterVec) With(values ...string) *Counter {
+	return c.with(values)
+}
+
+func (c *CounterVec) collect(w *bufio.Writer) {
+	c.header(w)
+	c.each(func(values []string, child *Counter) {
+		fmt.Fprintf(w, "%s%s %d\n", c.fqName, c.labelPairs(values, ""), child.Value())
+	})
+}
+
+// HistogramVec is a histogram split by labels, every child has the same buckets
+type HistogramVec struct {
+	*vec[*Histogram]
+}
+
+func (r *Registry) NewHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	d := &desc{fqName: name, help: help, kind: "histogram", labels: labels}
+	h := &HistogramVec{newVec(d, func() *Histogram { return newHistogram(d, buckets) })}
+	r.register(h)
+	return h
+}
+
+func NewHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	return Default.NewHistogramVec(name, help, buckets, labels...)
+}
+
+func (h *HistogramVec) With(values ...string) *Histogram {
+	return h.with(values)
+}
+
+func (h *HistogramVec) collect(w *bufio.Writer) {
+	h.header(w)
+	h.each(func(values []string, child *Histogram) {
+		child.writeSamples(w, values)
+	})
+}
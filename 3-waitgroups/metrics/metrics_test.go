@@ -0,0 +1,123 @@
+package metrics
+
+import (
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestRegistryExposition(t *testing.T) {
+	r := NewRegistry()
+
+	fetched := r.NewCounter("pages_total", "Pages seen.")
+	fetched.Add(3)
+
+	failed := r.NewCounterVec("failures_total", "Failures by status.", "status")
+	failed.With("http_error").Inc()
+	failed.With("canceled").Add(2)
+
+	inFlight := r.NewGauge("in_flight", "Running now.")
+	inFlight.Inc()
+	inFlight.Inc()
+	inFlight.Dec()
+
+	r.NewGaugeFunc("queue_depth", "Waiting.", func() float64 { return 7 })
+
+	latency := r.NewHistogramVec("latency_seconds", "Latency\nby host.", []float64{1, 0.1}, "host")
+	latency.With(`a"b`).Observe(0.05)
+	latency.With(`a"b`).Observe(0.1)
+	latency.With(`a"b`).Observe(3)
+
+	var out strings.Builder
+	if _, err := r.WriteTo(&out); err != nil {
+		t.Fatalf("WriteTo: %v", err)
+	}
+
+	want := `# HELP failures_total Failures by status.
+# TYPE failures_total counter
+failures_total{status="canceled"} 2
+failures_total{status="http_error"} 1
+# HELP in_flight Running now.
+# TYPE in_flight gauge
+in_flight 1
+# HELP latency_seconds Latency\nby host.
+# TYPE latency_seconds histogram
+latency_seconds_bucket{host="a\"b",le="0.1"} 2
+latency_seconds_bucket{host="a\"b",le="1"} 2
+latency_seconds_bucket{host="a\"b",le="+Inf"} 3
+latency_seconds_sum{host="a\"b"} 3.15
+latency_seconds_count{host="a\"b"} 3
+# HELP pages_total Pages seen.
+# TYPE pages_total counter
+pages_total 3
+# HELP queue_depth Waiting.
+# TYPE queue_depth gauge
+queue_depth 7
+`
+	if got := out.String(); got != want {
+		t.Errorf("exposition:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestHistogramDefaultBuckets(t *testing.T) {
+	r := NewRegistry()
+	r.NewHistogram("h", "help", nil).Observe(0.003)
+
+	var out strings.Builder
+	r.WriteTo(&out)
+
+	if n := strings.Count(out.String(), "h_bucket{"); n != len(DefaultBuckets)+1 {
+		t.Errorf("got %d buckets, want %d", n, len(DefaultBuckets)+1)
+	}
+	if !strings.Contains(out.String(), `h_bucket{le="0.005"} 1`) {
+		t.Errorf("0.003 should land in the first bucket:\n%s", out.String())
+	}
+}
+
+func TestDuplicateNamePanics(t *testing.T) {
+	r := NewRegistry()
+	r.NewCounter("dup", "first")
+
+	defer func() {
+		if recover() == nil {
+			t.Error("registering a name twice should panic")
+		}
+	}()
+	r.NewGauge("dup", "second")
+}
+
+func TestConcurrentUpdates(t *testing.T) {
+	r := NewRegistry()
+	counter := r.NewCounterVec("c_total", "help", "worker")
+	histogram := r.NewHistogramVec("h_seconds", "help", nil, "worker")
+
+	var wg sync.WaitGroup
+	for w := 0; w < 8; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 1000; i++ {
+				label := string(rune('a' + i%4))
+				counter.With(label).Inc()
+				histogram.With(label).Observe(0.01)
+			}
+		}()
+	}
+
+	// scrapes run next to the updates
+	for i := 0; i < 10; i++ {
+		r.WriteTo(new(strings.Builder))
+	}
+	wg.Wait()
+
+	var total uint64
+	for _, label := range []string{"a", "b", "c", "d"} {
+		total += counter.With(label).Value()
+		if n := histogram.With(label).Count(); n != 2000 {
+			t.Errorf("histogram %s has %d observations, want 2000", label, n)
+		}
+	}
+	if total != 8000 {
+		t.Errorf("counter total = %d, want 8000", total)
+	}
+}
@@ -1,18 +1,63 @@
 package models
 
-import "gorm.io/gorm"
+import (
+	"gorm.io/gorm"
+	"time"
+)
 
+// CrawlPage is one row per URL, its status fields describe the latest crawl
 type CrawlPage struct {
 	gorm.Model
-	URL      string    `gorm:"uniqueIndex"`
-	Elements []Element `gorm:"foreignKey:PageID"`
+	URL           string `gorm:"uniqueIndex"`
+	Status        string
+	StatusCode    int
+	Error         string
+	Attempts      int
+	LastError     string
+	ContentType   string
+	Bytes         int64
+	LastCrawledAt time.Time
+	LatestVersion int
+	// ExtractionHash identifies the rules the latest version was extracted with
+	ExtractionHash string
+
+	// validators of the last response, re-crawls send them to get a 304 instead of the body
+	ETag         string `gorm:"column:etag"`
+	LastModified string
+
+	// as listed by the sitemap the page was discovered in, zero for pages crawled from plain seeds
+	SitemapLastMod  time.Time
+	SitemapPriority float64
+
+	// SimHash fingerprints the text of the last successful crawl, stored as its bit pattern since Postgres has no unsigned bigint.
+	// DuplicateOf is the first page of the near-duplicate cluster the last detection put the page in, zero for none.
+	SimHash     int64
+	DuplicateOf uint `gorm:"index"`
+
+	Versions []PageVersion `gorm:"foreignKey:PageID"`
+	Elements []Element     `gorm:"foreignKey:PageID"`
+}
+
+// PageVersion is a snapshot of a page, a new one is kept whenever the content hash changes
+type PageVersion struct {
+	gorm.Model
+	PageID      uint `gorm:"uniqueIndex:idx_page_version"`
+	Version     int  `gorm:"uniqueIndex:idx_page_version"`
+	FetchedAt   time.Time
+	ContentHash string    `gorm:"index"`
+	IsLatest    bool      `gorm:"index"`
+	Elements    []Element `gorm:"foreignKey:VersionID"`
 }
 
 type Element struct {
 	gorm.Model
 	PageID      uint
+	VersionID   uint `gorm:"index"`
+	Field       string
 	ElementType string
 	Content     string
 	Attribute   string
+	ResolvedURL string  `gorm:"index"`
+	Attributes  JSONMap `gorm:"type:jsonb"`
 	Position    int
 }
@@ -0,0 +1,43 @@
+package models
+
+// ElementDiff lists the elements that appear in one version but not the other
+type ElementDiff struct {
+	Added     []Element `json:"added"`
+	Removed   []Element `json:"removed"`
+	Unchanged int       `json:"unchanged"`
+}
+
+// DiffElements compares two element lists as multisets of field, type, content and attribute,
+// so an element that only moved to another position counts as unchanged
+func DiffElements(from, to []Element) ElementDiff {
+	diff := ElementDiff{Added: []Element{}, Removed: []Element{}}
+
+	counts := make(map[string]int, len(from))
+	for _, e := range from {
+		counts[elementKey(e)]++
+	}
+
+	for _, e := range to {
+		key := elementKey(e)
+		if counts[key] > 0 {
+			counts[key]--
+			diff.Unchanged++
+		} else {
+			diff.Added = append(diff.Added, e)
+		}
+	}
+
+	for _, e := range from {
+		key := elementKey(e)
+		if counts[key] > 0 {
+			counts[key]--
+			diff.Removed = append(diff.Removed, e)
+		}
+	}
+
+	return diff
+}
+
+func elementKey(e Element) string {
+	return e.Field + "\x00" + e.ElementType + "\x00" + e.Content + "\x00" + e.Attribute
+}
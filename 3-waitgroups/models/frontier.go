@@ -0,0 +1,46 @@
+package models
+
+import "time"
+
+// State of a frontier URL
+const (
+	FrontierPending = "pending"
+	FrontierLeased  = "leased"
+	FrontierDone    = "done"
+	// the URL was leased too often without being stored, whatever it does takes its workers down or fails them
+	FrontierAbandoned = "abandoned"
+)
+
+// FrontierJob is a crawl shared by the workers of every process on the database,
+// Options is the options part of the crawl request
+type FrontierJob struct {
+	ID        string `gorm:"primaryKey"`
+	CreatedAt time.Time
+	Options   JSONDoc `gorm:"type:jsonb"`
+	Total     int
+}
+
+// FrontierURL is a URL of a FrontierJob. A worker leases it until LeaseExpiresAt and keeps the lease
+// alive with heartbeats, a lease that runs out goes to the next worker asking. Every lease bumps Token,
+// a worker only gets to store the page while the token is still the one it leased with.
+type FrontierURL struct {
+	ID        uint `gorm:"primaryKey"`
+	CreatedAt time.Time
+	UpdatedAt time.Time
+
+	JobID string `gorm:"uniqueIndex:idx_frontier_job_url"`
+	URL   string `gorm:"uniqueIndex:idx_frontier_job_url"`
+	State string `gorm:"index"`
+
+	LeaseOwner     string
+	LeaseExpiresAt time.Time
+	Token          int
+	// Leases counts how often the URL was handed out
+	Leases int
+
+	// outcome of the crawl, set together with the done state
+	Status string
+	Error  string
+	PageID uint
+	DoneAt *time.Time
+}
@@ -0,0 +1,25 @@
+package models
+
+import (
+	"gorm.io/gorm"
+	"time"
+)
+
+// Link is an edge of the link graph, ToURL is the normalized target whether it was crawled or not
+type Link struct {
+	gorm.Model
+	FromPageID uint   `gorm:"index"`
+	ToURL      string `gorm:"index"`
+	AnchorText string
+}
+
+// PageRank is the result of the last link graph computation for a crawled page
+type PageRank struct {
+	gorm.Model
+	PageID     uint `gorm:"uniqueIndex"`
+	URL        string
+	Rank       float64 `gorm:"index"`
+	InDegree   int
+	OutDegree  int
+	ComputedAt time.Time
+}
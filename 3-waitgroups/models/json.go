@@ -0,0 +1,123 @@
+package models
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+)
+
+// JSONMap is a string map stored as a jsonb column
+type JSONMap map[string]string
+
+func (m JSONMap) Value() (driver.Value, error) {
+	if m == nil {
+		return nil, nil
+	}
+
+	b, err := json.Marshal(m)
+	return string(b), err
+}
+
+func (m *JSONMap) Scan(value any) error {
+	switch v := value.(type) {
+	case nil:
+		*m = nil
+		return nil
+	case []byte:
+		return json.Unmarshal(v, m)
+	case string:
+		return json.Unmarshal([]byte(v), m)
+	default:
+		return fmt.Errorf("can not scan %T into JSONMap", value)
+	}
+}
+
+// JSONList is a list of raw JSON documents stored as a jsonb array
+type JSONList []json.RawMessage
+
+func (l JSONList) Value() (driver.Value, error) {
+	if l == nil {
+		return nil, nil
+	}
+
+	b, err := json.Marshal(l)
+	return string(b), err
+}
+
+func (l *JSONList) Scan(value any) error {
+	switch v := value.(type) {
+	case nil:
+		*l = nil
+		return nil
+	case []byte:
+		return json.Unmarshal(v, l)
+	case string:
+		return json.Unmarshal([]byte(v), l)
+	default:
+		return fmt.Errorf("can not scan %T into JSONList", value)
+	}
+}
+
+// StringList is a list of strings stored as a jsonb array
+type StringList []string
+
+func (l StringList) Value() (driver.Value, error) {
+	if l == nil {
+		return nil, nil
+	}
+
+	b, err := json.Marshal(l)
+	return string(b), err
+}
+
+func (l *StringList) Scan(value any) error {
+	switch v := value.(type) {
+	case nil:
+		*l = nil
+		return nil
+	case []byte:
+		return json.Unmarshal(v, l)
+	case string:
+		return json.Unmarshal([]byte(v), l)
+	default:
+		return fmt.Errorf("can not scan %T into StringList", value)
+	}
+}
+
+// JSONDoc is a raw JSON document stored as jsonb and returned as is by the API
+type JSONDoc json.RawMessage
+
+func (d JSONDoc) Value() (driver.Value, error) {
+	if len(d) == 0 {
+		return nil, nil
+	}
+	return string(d), nil
+}
+
+func (d *JSONDoc) Scan(value any) error {
+	switch v := value.(type) {
+	case nil:
+		*d = nil
+		return nil
+	case []byte:
+		*d = append(JSONDoc(nil), v...)
+		return nil
+	case string:
+		*d = JSONDoc(v)
+		return nil
+	default:
+		return fmt.Errorf("can not scan %T into JSONDoc", value)
+	}
+}
+
+func (d JSONDoc) MarshalJSON() ([]byte, error) {
+	if len(d) == 0 {
+		return []byte("null"), nil
+	}
+	return d, nil
+}
+
+func (d *JSONDoc) UnmarshalJSON(b []byte) error {
+	*d = append(JSONDoc(nil), b...)
+	return nil
+}
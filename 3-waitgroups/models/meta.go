@@ -0,0 +1,21 @@
+package models
+
+import "gorm.io/gorm"
+
+// PageMeta is the page-level metadata of the latest successful crawl of a page
+type PageMeta struct {
+	gorm.Model
+	PageID      uint `gorm:"uniqueIndex"`
+	Title       string
+	Description string
+	Keywords    string
+	Canonical   string
+	Language    string   `gorm:"index"`
+	OpenGraph   JSONMap  `gorm:"type:jsonb"`
+	Twitter     JSONMap  `gorm:"type:jsonb"`
+	JSONLD      JSONList `gorm:"column:json_ld;type:jsonb"`
+}
+
+func (PageMeta) TableName() string {
+	return "page_meta"
+}
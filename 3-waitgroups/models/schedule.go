@@ -0,0 +1,38 @@
+package models
+
+import (
+	"gorm.io/gorm"
+	"time"
+)
+
+// CrawlSchedule crawls URLs with the stored options whenever its five-field cron expression matches
+type CrawlSchedule struct {
+	gorm.Model
+	Name    string
+	Cron    string
+	URLs    StringList `gorm:"column:urls;type:jsonb"`
+	Options JSONDoc    `gorm:"type:jsonb"`
+	Enabled bool       `gorm:"index"`
+}
+
+// Outcome of a scheduled run
+const (
+	RunRunning   = "running"
+	RunCompleted = "completed"
+	RunFailed    = "failed"
+	RunSkipped   = "skipped"
+)
+
+// CrawlRun is one entry of a schedule's run history
+type CrawlRun struct {
+	gorm.Model
+	ScheduleID  uint `gorm:"index"`
+	JobID       string
+	Status      string
+	ScheduledAt time.Time
+	StartedAt   time.Time
+	FinishedAt  *time.Time
+	Total       int
+	Failed      int
+	Error       string
+}
@@ -0,0 +1,20 @@
+package scheduler
+
+import "time"
+
+// Clock is the scheduler's source of time, tests swap in one they can move by hand
+type Clock interface {
+	Now() time.Time
+	After(d time.Duration) <-chan time.Time
+}
+
+// RealClock is the wall clock
+type RealClock struct{}
+
+func (RealClock) Now() time.Time {
+	return time.Now()
+}
+
+func (RealClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
@@ -0,0 +1,132 @@
+package scheduler
+
+import (
+	"context"
+	"exercise3/models"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Scheduler launches the crawl schedules whenever their cron expression comes due.
+// Schedules are reloaded on every tick, so edits apply without a restart.
+// A schedule whose previous run is still going has the new run skipped rather than stacked.
+type Scheduler struct {
+	Clock Clock
+
+	// Load returns the enabled schedules
+	Load func(ctx context.Context) ([]models.CrawlSchedule, error)
+	// Run crawls one schedule and returns once the crawl is over
+	Run func(ctx context.Context, schedule models.CrawlSchedule, scheduledAt time.Time)
+	// Skip records a run that was dropped because the previous one hadn't finished
+	Skip func(ctx context.Context, schedule models.CrawlSchedule, scheduledAt time.Time)
+
+	// schedules aren't fired for minutes that passed before the scheduler was created
+	started time.Time
+
+	mu      sync.Mutex
+	entries map[uint]*entry
+	runs    sync.WaitGroup
+}
+
+type entry struct {
+	expr    string
+	cron    *Cron
+	next    time.Time
+	running bool
+}
+
+func New(clock Clock) *Scheduler {
+	return &Scheduler{Clock: clock, started: clock.Now(), entries: make(map[uint]*entry)}
+}
+
+// Start ticks at the top of every minute until ctx is done, then waits for the runs it started
+func (s *Scheduler) Start(ctx context.Context) {
+	defer s.runs.Wait()
+
+	for {
+		now := s.Clock.Now()
+		wait := now.Truncate(time.Minute).Add(time.Minute).Sub(now)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-s.Clock.After(wait):
+			s.Tick(ctx)
+		}
+	}
+}
+
+// Tick starts every schedule that came due since its last run, missed minutes fold into one run.
+// A new or edited schedule is planned from its last update, or from the scheduler's start when that is later.
+func (s *Scheduler) Tick(ctx context.Context) {
+	schedules, err := s.Load(ctx)
+	if err != nil {
+		fmt.Println("Scheduler error:", err)
+		return
+	}
+
+	now := s.Clock.Now()
+	seen := make(map[uint]bool, len(schedules))
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, schedule := range schedules {
+		seen[schedule.ID] = true
+
+		e, ok := s.entries[schedule.ID]
+		if !ok || e.expr != schedule.Cron {
+			cron, err := ParseCron(schedule.Cron)
+			if err != nil {
+				fmt.Println("Scheduler error:", schedule.ID, err)
+				continue
+			}
+			if !ok {
+				e = &entry{}
+				s.entries[schedule.ID] = e
+			}
+			from := schedule.UpdatedAt
+			if from.Before(s.started) {
+				from = s.started
+			}
+			e.expr, e.cron, e.next = schedule.Cron, cron, cron.Next(from)
+		}
+
+		if e.next.IsZero() || now.Before(e.next) {
+			continue
+		}
+		scheduledAt := e.next
+		e.next = e.cron.Next(now)
+
+		if e.running {
+			s.runs.Add(1)
+			go func(schedule models.CrawlSchedule) {
+				defer s.runs.Done()
+				if s.Skip != nil {
+					s.Skip(ctx, schedule, scheduledAt)
+				}
+			}(schedule)
+			continue
+		}
+
+		e.running = true
+		s.runs.Add(1)
+		go func(schedule models.CrawlSchedule, e *entry) {
+			defer s.runs.Done()
+			defer func() {
+				s.mu.Lock()
+				e.running = false
+				s.mu.Unlock()
+			}()
+			s.Run(ctx, schedule, scheduledAt)
+		}(schedule, e)
+	}
+
+	// deleted or disabled schedules are forgotten once their last run is over
+	for id, e := range s.entries {
+		if !seen[id] && !e.running {
+			delete(s.entries, id)
+		}
+	}
+}
@@ -0,0 +1,178 @@
+package scheduler
+
+import (
+	"context"
+	"exercise3/models"
+	"sync"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// fakeClock only moves when the test advances it
+type fakeClock struct {
+	mu      sync.Mutex
+	now     time.Time
+	waiters []fakeWaiter
+}
+
+type fakeWaiter struct {
+	at time.Time
+	ch chan time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) After(d time.Duration) <-chan time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	ch := make(chan time.Time, 1)
+	c.waiters = append(c.waiters, fakeWaiter{at: c.now.Add(d), ch: ch})
+	return ch
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.now = c.now.Add(d)
+	pending := c.waiters[:0]
+	for _, w := range c.waiters {
+		if w.at.After(c.now) {
+			pending = append(pending, w)
+			continue
+		}
+		w.ch <- c.now
+	}
+	c.waiters = pending
+}
+
+func (c *fakeClock) Waiting() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.waiters)
+}
+
+// recorder collects the runs and skips, each run blocks until release is closed
+type recorder struct {
+	mu      sync.Mutex
+	runs    []time.Time
+	skips   []time.Time
+	release chan struct{}
+}
+
+func (r *recorder) run(_ context.Context, _ models.CrawlSchedule, at time.Time) {
+	r.mu.Lock()
+	r.runs = append(r.runs, at)
+	r.mu.Unlock()
+	<-r.release
+}
+
+func (r *recorder) skip(_ context.Context, _ models.CrawlSchedule, at time.Time) {
+	r.mu.Lock()
+	r.skips = append(r.skips, at)
+	r.mu.Unlock()
+}
+
+func (r *recorder) counts() (int, int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.runs), len(r.skips)
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func newTestScheduler(clock *fakeClock, rec *recorder, schedules ...models.CrawlSchedule) *Scheduler {
+	s := New(clock)
+	s.Load = func(context.Context) ([]models.CrawlSchedule, error) {
+		return schedules, nil
+	}
+	s.Run = rec.run
+	s.Skip = rec.skip
+	return s
+}
+
+func TestSchedulerSkipsOverlappingRuns(t *testing.T) {
+	start := time.Date(2024, 1, 1, 9, 59, 30, 0, time.UTC)
+	clock := &fakeClock{now: start}
+	rec := &recorder{release: make(chan struct{})}
+	schedule := models.CrawlSchedule{Model: gorm.Model{ID: 1, UpdatedAt: start.Add(-time.Hour)}, Cron: "*/5 * * * *"}
+	s := newTestScheduler(clock, rec, schedule)
+	ctx := context.Background()
+
+	// the schedule was created an hour ago, the runs it missed before the start are not made up
+	s.Tick(ctx)
+	if runs, _ := rec.counts(); runs != 0 {
+		t.Fatalf("runs before the first due minute: %d", runs)
+	}
+
+	clock.Advance(30 * time.Second)
+	s.Tick(ctx)
+	waitFor(t, "first run", func() bool { runs, _ := rec.counts(); return runs == 1 })
+
+	// the first run is still going at 10:05, that run is skipped
+	clock.Advance(5 * time.Minute)
+	s.Tick(ctx)
+	waitFor(t, "skip", func() bool { _, skips := rec.counts(); return skips == 1 })
+	if runs, _ := rec.counts(); runs != 1 {
+		t.Fatalf("overlapping run started, runs = %d", runs)
+	}
+
+	close(rec.release)
+	s.runs.Wait()
+
+	clock.Advance(5 * time.Minute)
+	s.Tick(ctx)
+	waitFor(t, "second run", func() bool { runs, _ := rec.counts(); return runs == 2 })
+	s.runs.Wait()
+
+	want := []time.Time{start.Add(30 * time.Second), start.Add(10*time.Minute + 30*time.Second)}
+	for i, at := range rec.runs {
+		if !at.Equal(want[i]) {
+			t.Errorf("run %d scheduled at %v, want %v", i, at, want[i])
+		}
+	}
+	if !rec.skips[0].Equal(start.Add(5*time.Minute + 30*time.Second)) {
+		t.Errorf("skip scheduled at %v", rec.skips[0])
+	}
+}
+
+func TestSchedulerStartTicksEveryMinute(t *testing.T) {
+	start := time.Date(2024, 1, 1, 10, 0, 15, 0, time.UTC)
+	clock := &fakeClock{now: start}
+	rec := &recorder{release: make(chan struct{})}
+	close(rec.release)
+	schedule := models.CrawlSchedule{Model: gorm.Model{ID: 1, UpdatedAt: start}, Cron: "* * * * *"}
+	s := newTestScheduler(clock, rec, schedule)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		s.Start(ctx)
+		close(done)
+	}()
+
+	for i := 1; i <= 3; i++ {
+		waitFor(t, "scheduler to wait for the next minute", func() bool { return clock.Waiting() == 1 })
+		clock.Advance(time.Minute)
+		waitFor(t, "run", func() bool { runs, _ := rec.counts(); return runs == i })
+	}
+
+	cancel()
+	<-done
+}
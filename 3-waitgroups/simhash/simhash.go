@@ -0,0 +1,114 @@
+// Package simhash fingerprints text so that similar texts get fingerprints a few bits apart,
+// and groups fingerprints into clusters of near-duplicates.
+package simhash
+
+import (
+	"hash/fnv"
+	"math/bits"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// shingleSize words make up one feature, so word order counts and not just the vocabulary
+const shingleSize = 3
+
+// Fingerprint is the 64 bit SimHash of text, 0 when the text has no words
+func Fingerprint(text string) uint64 {
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+	if len(words) == 0 {
+		return 0
+	}
+
+	size := min(shingleSize, len(words))
+	var weights [64]int
+	for i := 0; i+size <= len(words); i++ {
+		h := fnv.New64a()
+		h.Write([]byte(strings.Join(words[i:i+size], " ")))
+		feature := h.Sum64()
+
+		for bit := 0; bit < 64; bit++ {
+			if feature&(1<<bit) != 0 {
+				weights[bit]++
+			} else {
+				weights[bit]--
+			}
+		}
+	}
+
+	var fingerprint uint64
+	for bit, w := range weights {
+		if w > 0 {
+			fingerprint |= 1 << bit
+		}
+	}
+	return fingerprint
+}
+
+// Distance is the Hamming distance of two fingerprints
+func Distance(a, b uint64) int {
+	return bits.OnesCount64(a ^ b)
+}
+
+// Cluster groups the fingerprints that are at most maxDistance apart, directly or through other members.
+// It returns the clusters of two or more as sorted index lists, ordered by their first index.
+//
+// Fingerprints within maxDistance agree exactly on at least one of maxDistance+1 bands of bits,
+// so only fingerprints sharing a band are compared.
+func Cluster(fingerprints []uint64, maxDistance int) [][]int {
+	maxDistance = min(max(maxDistance, 0), 63)
+
+	parent := make([]int, len(fingerprints))
+	for i := range parent {
+		parent[i] = i
+	}
+	var find func(int) int
+	find = func(i int) int {
+		if parent[i] != i {
+			parent[i] = find(parent[i])
+		}
+		return parent[i]
+	}
+
+	bands := maxDistance + 1
+	for band := 0; band < bands; band++ {
+		low, high := band*64/bands, (band+1)*64/bands
+		mask := uint64(1)<<(high-low) - 1
+
+		buckets := make(map[uint64][]int)
+		for i, f := range fingerprints {
+			key := f >> low & mask
+			buckets[key] = append(buckets[key], i)
+		}
+
+		for _, members := range buckets {
+			for x := 0; x < len(members); x++ {
+				for y := x + 1; y < len(members); y++ {
+					a, b := find(members[x]), find(members[y])
+					if a != b && Distance(fingerprints[members[x]], fingerprints[members[y]]) <= maxDistance {
+						parent[max(a, b)] = min(a, b)
+					}
+				}
+			}
+		}
+	}
+
+	groups := make(map[int][]int)
+	for i := range fingerprints {
+		root := find(i)
+		groups[root] = append(groups[root], i)
+	}
+
+	var clusters [][]int
+	for _, members := range groups {
+		if len(members) > 1 {
+			clusters = append(clusters, members)
+		}
+	}
+	sort.Slice(clusters, func(i, j int) bool {
+		return clusters[i][0] < clusters[j][0]
+	})
+	return clusters
+}
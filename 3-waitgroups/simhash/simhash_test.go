@@ -0,0 +1,83 @@
+package simhash
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDistance(t *testing.T) {
+	tests := []struct {
+		a, b uint64
+		want int
+	}{
+		{0, 0, 0},
+		{0xff, 0xff, 0},
+		{0, 1, 1},
+		{0b1010, 0b0101, 4},
+		{0, ^uint64(0), 64},
+		{1 << 63, 1, 2},
+	}
+
+	for _, tt := range tests {
+		if got := Distance(tt.a, tt.b); got != tt.want {
+			t.Errorf("Distance(%#x, %#x) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+		if got := Distance(tt.b, tt.a); got != tt.want {
+			t.Errorf("Distance(%#x, %#x) = %d, want %d", tt.b, tt.a, got, tt.want)
+		}
+	}
+}
+
+func TestFingerprint(t *testing.T) {
+	article := "Crawlers visit pages by following the links they find on pages they already fetched. " +
+		"A polite crawler keeps to the robots file of every host and spaces out its requests. " +
+		"Pages that changed since the last visit are stored as a new version with their elements."
+
+	if Fingerprint("") != 0 || Fingerprint(" ,.! ") != 0 {
+		t.Error("text without words has a fingerprint")
+	}
+	if Fingerprint(article) != Fingerprint("  "+article+"\n") {
+		t.Error("whitespace changed the fingerprint")
+	}
+	if Fingerprint("Hello World") != Fingerprint("hello, world!") {
+		t.Error("case or punctuation changed the fingerprint")
+	}
+
+	near := Distance(Fingerprint(article), Fingerprint(article+" Print this page."))
+	far := Distance(Fingerprint(article), Fingerprint("The weather is sunny with a light breeze from the west all afternoon."))
+	if near > 8 || far < 16 {
+		t.Errorf("near-duplicate distance %d, unrelated text distance %d", near, far)
+	}
+}
+
+func TestCluster(t *testing.T) {
+	fingerprints := []uint64{
+		0b0000,           // 0
+		0b1111 << 60,     // 1
+		0b0001,           // 2, 1 bit from 0
+		0b0011,           // 3, 1 bit from 2, 2 bits from 0
+		0b0111 << 60,     // 4, 1 bit from 1
+		^uint64(0) >> 32, // 5, alone
+	}
+
+	tests := []struct {
+		maxDistance int
+		want        [][]int
+	}{
+		{0, nil},
+		{1, [][]int{{0, 2, 3}, {1, 4}}},
+		{2, [][]int{{0, 2, 3}, {1, 4}}},
+		{-1, nil},
+	}
+
+	for _, tt := range tests {
+		if got := Cluster(fingerprints, tt.maxDistance); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Cluster(%d) = %v, want %v", tt.maxDistance, got, tt.want)
+		}
+	}
+
+	// exact copies are clustered at distance 0
+	if got := Cluster([]uint64{7, 9, 7}, 0); !reflect.DeepEqual(got, [][]int{{0, 2}}) {
+		t.Errorf("Cluster of copies = %v", got)
+	}
+}
@@ -0,0 +1,837 @@
+package storage
+
+import (
+	"context"
+	"exercise3/crawler"
+	"exercise3/models"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+// Memory is a Store that keeps everything in process, safe for concurrent use and gone on exit.
+// It runs the service and the tests without a Postgres, with the same results as the Postgres store
+// except for search ranking, which only counts matching words.
+type Memory struct {
+	mu sync.RWMutex
+
+	pages     map[uint]*models.CrawlPage
+	urls      map[string]uint
+	versions  map[uint][]models.PageVersion
+	metas     map[uint]models.PageMeta
+	links     map[uint][]models.Link
+	ranks     []models.PageRank
+	schedules map[uint]models.CrawlSchedule
+	runs      map[uint]models.CrawlRun
+
+	frontierJobs map[string]models.FrontierJob
+	// frontier holds the URL with id i at index i-1, so it is in lease order
+	frontier []*models.FrontierURL
+
+	// last ids handed out per table
+	ids struct {
+		page, version, element, meta, link, rank, schedule, run uint
+	}
+}
+
+var _ Store = (*Memory)(nil)
+
+func NewMemory() *Memory {
+	return &Memory{
+		pages:     make(map[uint]*models.CrawlPage),
+		urls:      make(map[string]uint),
+		versions:  make(map[uint][]models.PageVersion),
+		metas:     make(map[uint]models.PageMeta),
+		links:     make(map[uint][]models.Link),
+		schedules: make(map[uint]models.CrawlSchedule),
+		runs:      make(map[uint]models.CrawlRun),
+
+		frontierJobs: make(map[string]models.FrontierJob),
+	}
+}
+
+func nextID(id *uint) uint {
+	*id++
+	return *id
+}
+
+// window cuts one page out of a sorted listing
+func window[T any](items []T, w Window) []T {
+	start := min(max(w.Offset, 0), len(items))
+	end := len(items)
+	if w.Limit > 0 {
+		end = min(start+w.Limit, end)
+	}
+	return append([]T{}, items[start:end]...)
+}
+
+func (m *Memory) SavePages(ctx context.Context, batch []*crawler.PageResult) error {
+	if err := ctx.Err(); err != nil {
+		for _, r := range batch {
+			r.PageID, r.Version = 0, 0
+		}
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.savePages(batch, time.Now())
+	return nil
+}
+
+// savePages writes the batch, the caller holds the write lock
+func (m *Memory) savePages(batch []*crawler.PageResult, now time.Time) {
+	for _, row := range pageRows(batch) {
+		id, ok := m.urls[row.URL]
+		if !ok {
+			page := row
+			page.ID = nextID(&m.ids.page)
+			page.CreatedAt, page.UpdatedAt = now, now
+			m.urls[page.URL] = page.ID
+			m.pages[page.ID] = &page
+			continue
+		}
+
+		// the columns the Postgres upsert overwrites, the version counter and sitemap fields stay
+		page := m.pages[id]
+		page.UpdatedAt = now
+		page.Status, page.StatusCode, page.Error = row.Status, row.StatusCode, row.Error
+		page.Attempts, page.LastError = row.Attempts, row.LastError
+		if fetched(row.Status) {
+			page.ContentType, page.Bytes, page.LastCrawledAt = row.ContentType, row.Bytes, row.LastCrawledAt
+			page.ETag, page.LastModified = row.ETag, row.LastModified
+		}
+		if row.Status == crawler.StatusSuccess {
+			page.SimHash = row.SimHash
+		}
+	}
+
+	for _, r := range batch {
+		page := m.pages[m.urls[r.URL]]
+		r.PageID = page.ID
+		if r.Status == crawler.StatusNotModified {
+			r.Version = page.LatestVersion
+		}
+		if r.Status != crawler.StatusSuccess {
+			continue
+		}
+
+		if r.Meta != nil {
+			m.saveMeta(page.ID, *r.Meta, now)
+		}
+
+		versions := m.versions[page.ID]
+		if n := len(versions); n > 0 && versions[n-1].ContentHash == r.ContentHash {
+			r.Version = page.LatestVersion
+			continue
+		}
+
+		page.LatestVersion++
+		page.ExtractionHash = r.ExtractionHash
+		r.Version = page.LatestVersion
+
+		snapshot := models.PageVersion{
+			PageID:      page.ID,
+			Version:     r.Version,
+			FetchedAt:   r.FetchedAt,
+			ContentHash: r.ContentHash,
+			IsLatest:    true,
+		}
+		snapshot.ID = nextID(&m.ids.version)
+		snapshot.CreatedAt, snapshot.UpdatedAt = now, now
+
+		snapshot.Elements = make([]models.Element, len(r.Elements))
+		for i, e := range r.Elements {
+			e.ID = nextID(&m.ids.element)
+			e.CreatedAt, e.UpdatedAt = now, now
+			e.PageID, e.VersionID = page.ID, snapshot.ID
+			snapshot.Elements[i] = e
+		}
+
+		for i := range versions {
+			versions[i].IsLatest = false
+		}
+		m.versions[page.ID] = append(versions, snapshot)
+
+		links := pageLinks(page.ID, snapshot.Elements)
+		for i := range links {
+			links[i].ID = nextID(&m.ids.link)
+			links[i].CreatedAt, links[i].UpdatedAt = now, now
+		}
+		m.links[page.ID] = links
+	}
+}
+
+func (m *Memory) saveMeta(pageID uint, meta models.PageMeta, now time.Time) {
+	meta.PageID = pageID
+	if stored, ok := m.metas[pageID]; ok {
+		meta.ID, meta.CreatedAt = stored.ID, stored.CreatedAt
+	} else {
+		meta.ID = nextID(&m.ids.meta)
+		meta.CreatedAt = now
+	}
+	meta.UpdatedAt = now
+	m.metas[pageID] = meta
+}
+
+// latest returns the current version of a page
+func (m *Memory) latest(pageID uint) (models.PageVersion, bool) {
+	versions := m.versions[pageID]
+	if len(versions) == 0 {
+		return models.PageVersion{}, false
+	}
+	return versions[len(versions)-1], true
+}
+
+func (m *Memory) PagesByURL(_ context.Context, urls []string) (map[string]models.CrawlPage, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	pages := make(map[string]models.CrawlPage)
+	for _, url := range urls {
+		if id, ok := m.urls[url]; ok {
+			pages[url] = *m.pages[id]
+		}
+	}
+	return pages, nil
+}
+
+func (m *Memory) SetSitemapEntries(_ context.Context, entries map[uint]crawler.SitemapURL) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for id, entry := range entries {
+		if page, ok := m.pages[id]; ok {
+			page.SitemapLastMod = entry.LastMod
+			page.SitemapPriority = entry.Priority
+		}
+	}
+	return nil
+}
+
+func (m *Memory) ListPages(_ context.Context, filter PageFilter) ([]models.CrawlPage, int64, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	pages := []models.CrawlPage{}
+	for _, page := range m.pages {
+		if filter.Status != "" && page.Status != filter.Status {
+			continue
+		}
+		if !filter.Crawled.contains(page.LastCrawledAt) {
+			continue
+		}
+		pages = append(pages, *page)
+	}
+	sort.Slice(pages, func(i, j int) bool {
+		if !pages[i].LastCrawledAt.Equal(pages[j].LastCrawledAt) {
+			return pages[i].LastCrawledAt.After(pages[j].LastCrawledAt)
+		}
+		return pages[i].ID < pages[j].ID
+	})
+	return window(pages, filter.Window), int64(len(pages)), nil
+}
+
+func (m *Memory) GetPage(_ context.Context, id uint) (models.CrawlPage, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	page, ok := m.pages[id]
+	if !ok {
+		return models.CrawlPage{}, ErrNotFound
+	}
+	return *page, nil
+}
+
+func (m *Memory) ListVersions(_ context.Context, pageID uint) ([]models.PageVersion, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	stored := m.versions[pageID]
+	versions := make([]models.PageVersion, len(stored))
+	for i, v := range stored {
+		v.Elements = nil
+		versions[len(stored)-1-i] = v
+	}
+	return versions, nil
+}
+
+func (m *Memory) VersionElements(_ context.Context, pageID uint, version int) ([]models.Element, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for _, v := range m.versions[pageID] {
+		if v.Version != version {
+			continue
+		}
+		elements := append([]models.Element{}, v.Elements...)
+		sort.SliceStable(elements, func(i, j int) bool {
+			return elements[i].Position < elements[j].Position
+		})
+		return elements, nil
+	}
+	return nil, ErrNotFound
+}
+
+func (m *Memory) GetMeta(_ context.Context, pageID uint) (models.PageMeta, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	meta, ok := m.metas[pageID]
+	if !ok {
+		return models.PageMeta{}, ErrNotFound
+	}
+	return meta, nil
+}
+
+func (m *Memory) ListMeta(_ context.Context, filter MetaFilter) ([]MetaRow, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	rows := []MetaRow{}
+	for pageID, meta := range m.metas {
+		page, ok := m.pages[pageID]
+		if !ok {
+			continue
+		}
+		if filter.Language != "" && meta.Language != filter.Language {
+			continue
+		}
+		if !strings.HasPrefix(page.URL, filter.URLPrefix) {
+			continue
+		}
+
+		missing := true
+		switch filter.Missing {
+		case MissingTitle:
+			missing = meta.Title == ""
+		case MissingDescription:
+			missing = meta.Description == ""
+		case MissingCanonical:
+			missing = meta.Canonical == ""
+		case MissingOpenGraph:
+			missing = meta.OpenGraph == nil
+		}
+		if !missing {
+			continue
+		}
+
+		rows = append(rows, MetaRow{PageMeta: meta, URL: page.URL})
+	}
+	sort.Slice(rows, func(i, j int) bool {
+		return rows[i].PageID < rows[j].PageID
+	})
+	return window(rows, filter.Window), nil
+}
+
+func (m *Memory) ListElements(_ context.Context, filter ElementFilter) ([]models.Element, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	elements := []models.Element{}
+	for pageID := range m.versions {
+		if filter.PageID > 0 && pageID != filter.PageID {
+			continue
+		}
+		v, _ := m.latest(pageID)
+		if !filter.Fetched.contains(v.FetchedAt) {
+			continue
+		}
+		for _, e := range v.Elements {
+			if (filter.Type == "" || e.ElementType == filter.Type) && (filter.Field == "" || e.Field == filter.Field) {
+				elements = append(elements, e)
+			}
+		}
+	}
+	sort.SliceStable(elements, func(i, j int) bool {
+		if elements[i].PageID != elements[j].PageID {
+			return elements[i].PageID < elements[j].PageID
+		}
+		return elements[i].Position < elements[j].Position
+	})
+	return window(elements, filter.Window), nil
+}
+
+// searchWords splits text into lowercase words, roughly what the simple text search configuration does
+func searchWords(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// Search matches elements containing every word of the query, ranked by the share of their words that match
+func (m *Memory) Search(_ context.Context, q SearchQuery) ([]SearchHit, error) {
+	terms := searchWords(q.Text)
+	if len(terms) == 0 {
+		return []SearchHit{}, nil
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	hits := []SearchHit{}
+	for pageID := range m.versions {
+		v, _ := m.latest(pageID)
+		if !q.Fetched.contains(v.FetchedAt) {
+			continue
+		}
+
+		for _, e := range v.Elements {
+			if q.Type != "" && e.ElementType != q.Type {
+				continue
+			}
+
+			words := searchWords(e.Content)
+			counts := make(map[string]int, len(words))
+			for _, w := range words {
+				counts[w]++
+			}
+
+			matched := 0
+			for _, term := range terms {
+				if counts[term] == 0 {
+					matched = -1
+					break
+				}
+				matched += counts[term]
+			}
+			if matched < 0 {
+				continue
+			}
+
+			hits = append(hits, SearchHit{
+				ElementID:   e.ID,
+				PageID:      pageID,
+				URL:         m.pages[pageID].URL,
+				Version:     v.Version,
+				FetchedAt:   v.FetchedAt,
+				ElementType: e.ElementType,
+				Field:       e.Field,
+				Content:     e.Content,
+				Attribute:   e.Attribute,
+				Rank:        float64(matched) / float64(len(words)),
+			})
+		}
+	}
+
+	sort.SliceStable(hits, func(i, j int) bool {
+		a, b := hits[i], hits[j]
+		if q.ByDate && !a.FetchedAt.Equal(b.FetchedAt) {
+			return a.FetchedAt.After(b.FetchedAt)
+		}
+		if a.Rank != b.Rank {
+			return a.Rank > b.Rank
+		}
+		if !a.FetchedAt.Equal(b.FetchedAt) {
+			return a.FetchedAt.After(b.FetchedAt)
+		}
+		return a.ElementID < b.ElementID
+	})
+	return window(hits, q.Window), nil
+}
+
+func (m *Memory) LinkGraph(_ context.Context) ([]models.CrawlPage, []models.Link, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var pages []models.CrawlPage
+	for _, page := range m.pages {
+		if page.LatestVersion > 0 {
+			pages = append(pages, models.CrawlPage{Model: page.Model, URL: page.URL})
+		}
+	}
+	sort.Slice(pages, func(i, j int) bool {
+		return pages[i].ID < pages[j].ID
+	})
+
+	var links []models.Link
+	for _, pageLinks := range m.links {
+		links = append(links, pageLinks...)
+	}
+	return pages, links, nil
+}
+
+func (m *Memory) ReplaceRanks(_ context.Context, ranks []models.PageRank) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	m.ranks = make([]models.PageRank, len(ranks))
+	for i, rank := range ranks {
+		rank.ID = nextID(&m.ids.rank)
+		rank.CreatedAt, rank.UpdatedAt = now, now
+		m.ranks[i] = rank
+	}
+	return nil
+}
+
+func (m *Memory) TopRanks(_ context.Context, w Window) ([]models.PageRank, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	ranks := append([]models.PageRank{}, m.ranks...)
+	sort.Slice(ranks, func(i, j int) bool {
+		if ranks[i].Rank != ranks[j].Rank {
+			return ranks[i].Rank > ranks[j].Rank
+		}
+		return ranks[i].PageID < ranks[j].PageID
+	})
+	return window(ranks, w), nil
+}
+
+func (m *Memory) InboundLinks(_ context.Context, url string, w Window) ([]InboundLink, int64, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	items := []InboundLink{}
+	for pageID, links := range m.links {
+		page, ok := m.pages[pageID]
+		if !ok {
+			continue
+		}
+		for _, link := range links {
+			if link.ToURL == url {
+				items = append(items, InboundLink{PageID: pageID, URL: page.URL, AnchorText: link.AnchorText})
+			}
+		}
+	}
+	sort.Slice(items, func(i, j int) bool {
+		return items[i].URL < items[j].URL
+	})
+	return window(items, w), int64(len(items)), nil
+}
+
+func (m *Memory) Fingerprints(_ context.Context) ([]models.CrawlPage, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var pages []models.CrawlPage
+	for _, page := range m.pages {
+		if page.SimHash != 0 {
+			pages = append(pages, models.CrawlPage{Model: page.Model, URL: page.URL, SimHash: page.SimHash})
+		}
+	}
+	sort.Slice(pages, func(i, j int) bool {
+		return pages[i].ID < pages[j].ID
+	})
+	return pages, nil
+}
+
+func (m *Memory) ReplaceDuplicates(_ context.Context, duplicateOf map[uint]uint) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for id, page := range m.pages {
+		page.DuplicateOf = duplicateOf[id]
+	}
+	return nil
+}
+
+func (m *Memory) DuplicateClusters(_ context.Context, w Window) ([]DuplicateCluster, int64, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	sizes := make(map[uint]int)
+	var pages []models.CrawlPage
+	for _, page := range m.pages {
+		if page.DuplicateOf != 0 {
+			sizes[page.DuplicateOf]++
+			pages = append(pages, *page)
+		}
+	}
+
+	firsts := make([]uint, 0, len(sizes))
+	for first := range sizes {
+		firsts = append(firsts, first)
+	}
+	sort.Slice(firsts, func(i, j int) bool {
+		if sizes[firsts[i]] != sizes[firsts[j]] {
+			return sizes[firsts[i]] > sizes[firsts[j]]
+		}
+		return firsts[i] < firsts[j]
+	})
+	firsts = window(firsts, w)
+
+	for _, id := range firsts {
+		if page, ok := m.pages[id]; ok {
+			pages = append(pages, *page)
+		}
+	}
+	return duplicateClusters(firsts, pages), int64(len(sizes)), nil
+}
+
+func (m *Memory) CreateSchedule(_ context.Context, schedule *models.CrawlSchedule) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	schedule.ID = nextID(&m.ids.schedule)
+	schedule.CreatedAt, schedule.UpdatedAt = now, now
+	m.schedules[schedule.ID] = *schedule
+	return nil
+}
+
+func (m *Memory) ListSchedules(_ context.Context, enabledOnly bool) ([]models.CrawlSchedule, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	schedules := []models.CrawlSchedule{}
+	for _, schedule := range m.schedules {
+		if !enabledOnly || schedule.Enabled {
+			schedules = append(schedules, schedule)
+		}
+	}
+	sort.Slice(schedules, func(i, j int) bool {
+		return schedules[i].ID < schedules[j].ID
+	})
+	return schedules, nil
+}
+
+func (m *Memory) GetSchedule(_ context.Context, id uint) (models.CrawlSchedule, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	schedule, ok := m.schedules[id]
+	if !ok {
+		return models.CrawlSchedule{}, ErrNotFound
+	}
+	return schedule, nil
+}
+
+func (m *Memory) SaveSchedule(ctx context.Context, schedule *models.CrawlSchedule) error {
+	if schedule.ID == 0 {
+		return m.CreateSchedule(ctx, schedule)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	schedule.UpdatedAt = time.Now()
+	m.schedules[schedule.ID] = *schedule
+	return nil
+}
+
+func (m *Memory) DeleteSchedule(_ context.Context, id uint) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.schedules[id]; !ok {
+		return ErrNotFound
+	}
+	delete(m.schedules, id)
+	return nil
+}
+
+func (m *Memory) SaveRun(_ context.Context, run *models.CrawlRun) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	if run.ID == 0 {
+		run.ID = nextID(&m.ids.run)
+		run.CreatedAt = now
+	}
+	run.UpdatedAt = now
+	m.runs[run.ID] = *run
+	return nil
+}
+
+func (m *Memory) ClaimRun(_ context.Context, run *models.CrawlRun) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.schedules[run.ScheduleID]; !ok {
+		return ErrNotFound
+	}
+	for _, claimed := range m.runs {
+		if claimed.ScheduleID == run.ScheduleID && claimed.ScheduledAt.Equal(run.ScheduledAt) {
+			return ErrConflict
+		}
+	}
+
+	now := time.Now()
+	run.ID = nextID(&m.ids.run)
+	run.CreatedAt, run.UpdatedAt = now, now
+	m.runs[run.ID] = *run
+	return nil
+}
+
+func (m *Memory) ListRuns(_ context.Context, scheduleID uint, w Window) ([]models.CrawlRun, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	runs := []models.CrawlRun{}
+	for _, run := range m.runs {
+		if run.ScheduleID == scheduleID {
+			runs = append(runs, run)
+		}
+	}
+	sort.Slice(runs, func(i, j int) bool {
+		if !runs[i].ScheduledAt.Equal(runs[j].ScheduledAt) {
+			return runs[i].ScheduledAt.After(runs[j].ScheduledAt)
+		}
+		return runs[i].ID > runs[j].ID
+	})
+	return window(runs, w), nil
+}
+
+func (m *Memory) CreateFrontierJob(_ context.Context, job *models.FrontierJob, urls []string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, exists := m.frontierJobs[job.ID]; exists {
+		return ErrConflict
+	}
+
+	now := time.Now()
+	rows := frontierRows(job.ID, urls)
+	job.Total = len(rows)
+	job.CreatedAt = now
+	m.frontierJobs[job.ID] = *job
+
+	for _, row := range rows {
+		u := row
+		u.ID = uint(len(m.frontier) + 1)
+		u.CreatedAt, u.UpdatedAt = now, now
+		m.frontier = append(m.frontier, &u)
+	}
+	return nil
+}
+
+func (m *Memory) GetFrontierJob(_ context.Context, id string) (models.FrontierJob, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	job, ok := m.frontierJobs[id]
+	if !ok {
+		return models.FrontierJob{}, ErrNotFound
+	}
+	return job, nil
+}
+
+func (m *Memory) FrontierProgress(_ context.Context, jobID string) (FrontierProgress, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	job, ok := m.frontierJobs[jobID]
+	if !ok {
+		return FrontierProgress{}, ErrNotFound
+	}
+
+	progress := FrontierProgress{JobID: job.ID, Total: job.Total, Statuses: make(map[string]int)}
+	for _, u := range m.frontier {
+		if u.JobID == jobID {
+			progress.add(u.State, u.Status, 1)
+		}
+	}
+	return progress, nil
+}
+
+func (m *Memory) LeaseURLs(_ context.Context, req LeaseRequest) ([]models.FrontierURL, error) {
+	if req.Limit <= 0 {
+		return nil, nil
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	var leased []models.FrontierURL
+	for _, u := range m.frontier {
+		expired := u.State == models.FrontierLeased && u.LeaseExpiresAt.Before(now)
+		if (expired || u.State == models.FrontierPending) && req.MaxLeases > 0 && u.Leases >= req.MaxLeases {
+			u.State, u.Error, u.DoneAt, u.UpdatedAt = models.FrontierAbandoned, errAbandoned, &now, now
+			continue
+		}
+		if len(leased) == req.Limit || (u.State != models.FrontierPending && !expired) {
+			continue
+		}
+
+		u.State = models.FrontierLeased
+		u.LeaseOwner, u.LeaseExpiresAt = req.Owner, now.Add(req.TTL)
+		u.Token++
+		u.Leases++
+		u.UpdatedAt = now
+		leased = append(leased, *u)
+	}
+	return leased, nil
+}
+
+// current returns the URL of the lease while the lease is still the current one, the caller holds the lock
+func (m *Memory) current(lease models.FrontierURL) *models.FrontierURL {
+	if lease.ID == 0 || int(lease.ID) > len(m.frontier) {
+		return nil
+	}
+	u := m.frontier[lease.ID-1]
+	if u.Token != lease.Token || u.State != models.FrontierLeased {
+		return nil
+	}
+	return u
+}
+
+func (m *Memory) RenewLeases(_ context.Context, leases []models.FrontierURL, ttl time.Duration) ([]uint, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	var lost []uint
+	for _, i := range leaseOrder(leases) {
+		u := m.current(leases[i])
+		if u == nil {
+			lost = append(lost, leases[i].ID)
+			continue
+		}
+		u.LeaseExpiresAt, u.UpdatedAt = now.Add(ttl), now
+	}
+	return lost, nil
+}
+
+func (m *Memory) ReleaseLeases(_ context.Context, leases []models.FrontierURL) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	for _, lease := range leases {
+		if u := m.current(lease); u != nil {
+			u.State, u.LeaseOwner, u.LeaseExpiresAt, u.UpdatedAt = models.FrontierPending, "", time.Time{}, now
+		}
+	}
+	return nil
+}
+
+func (m *Memory) SaveLeasedPages(ctx context.Context, leases []models.FrontierURL, batch []*crawler.PageResult) error {
+	if err := ctx.Err(); err != nil {
+		for _, r := range batch {
+			r.PageID, r.Version = 0, 0
+		}
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var kept []*crawler.PageResult
+	var urls []*models.FrontierURL
+	for i, lease := range leases {
+		u := m.current(lease)
+		if u == nil {
+			leaseLost(batch[i])
+			continue
+		}
+		kept = append(kept, batch[i])
+		urls = append(urls, u)
+	}
+
+	now := time.Now()
+	m.savePages(kept, now)
+
+	for i, u := range urls {
+		r := kept[i]
+		u.State, u.Status, u.Error, u.PageID = models.FrontierDone, r.Status, r.Error, r.PageID
+		u.DoneAt, u.UpdatedAt = &now, now
+	}
+	return nil
+}
@@ -0,0 +1,784 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"exercise3/crawler"
+	"exercise3/models"
+	"fmt"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"sort"
+	"strings"
+	"time"
+)
+
+// SearchConfig is the Postgres text search configuration of the element search index and its queries
+const SearchConfig = "simple"
+
+const (
+	// elementBatchSize caps the rows of one multi-row insert
+	elementBatchSize = 500
+
+	// lookupChunk keeps the IN lists of URL lookups well under the Postgres parameter limit
+	lookupChunk = 1000
+)
+
+// Postgres is the gorm backed Store
+type Postgres struct {
+	db *gorm.DB
+}
+
+var _ Store = (*Postgres)(nil)
+
+// NewPostgres migrates the schema and returns the store
+func NewPostgres(db *gorm.DB) (*Postgres, error) {
+	err := db.AutoMigrate(
+		&models.CrawlPage{}, &models.PageVersion{}, &models.Element{}, &models.PageMeta{},
+		&models.Link{}, &models.PageRank{}, &models.CrawlSchedule{}, &models.CrawlRun{},
+		&models.FrontierJob{}, &models.FrontierURL{},
+	)
+	if err != nil {
+		return nil, fmt.Errorf("migration: %w", err)
+	}
+
+	// AutoMigrate can't express an index on an expression, search needs this one to avoid full scans
+	err = db.Exec(fmt.Sprintf(
+		"CREATE INDEX IF NOT EXISTS idx_elements_content_fts ON elements USING GIN (to_tsvector('%s', content))", SearchConfig,
+	)).Error
+	if err != nil {
+		return nil, fmt.Errorf("search index: %w", err)
+	}
+
+	return &Postgres{db: db}, nil
+}
+
+// notFound maps gorm's missing row error onto ErrNotFound
+func notFound(err error) error {
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return ErrNotFound
+	}
+	return err
+}
+
+func (p *Postgres) SavePages(ctx context.Context, batch []*crawler.PageResult) error {
+	err := p.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		return writeBatch(tx, batch)
+	})
+	if err != nil {
+		// a rolled back batch stored nothing
+		for _, r := range batch {
+			r.PageID, r.Version = 0, 0
+		}
+	}
+	return err
+}
+
+// writeBatch upserts the page rows by URL, stores metadata and keeps a new version for every page whose content changed
+func writeBatch(tx *gorm.DB, batch []*crawler.PageResult) error {
+	pages := pageRows(batch)
+
+	updates := clause.AssignmentColumns([]string{
+		"updated_at", "status", "status_code", "error", "attempts", "last_error",
+	})
+	// a failed crawl keeps the validators and size of the last one that got the page, the next crawl stays conditional
+	for _, column := range fetchedColumns {
+		updates = append(updates, clause.Assignment{
+			Column: clause.Column{Name: column},
+			Value: gorm.Expr("CASE WHEN excluded.status IN (?, ?) THEN excluded."+column+" ELSE crawl_pages."+column+" END",
+				crawler.StatusSuccess, crawler.StatusNotModified),
+		})
+	}
+	// failed and not modified crawls have no text to fingerprint, the page keeps the one it had
+	updates = append(updates, clause.Assignment{
+		Column: clause.Column{Name: "sim_hash"},
+		Value:  gorm.Expr("CASE WHEN excluded.status = ? THEN excluded.sim_hash ELSE crawl_pages.sim_hash END", crawler.StatusSuccess),
+	})
+
+	// rows are upserted in URL order, so two batches sharing pages lock them in the same order
+	err := tx.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "url"}},
+		DoUpdates: updates,
+	}).Create(&pages).Error
+	if err != nil {
+		return err
+	}
+
+	ids := make(map[string]uint, len(pages))
+	pageIDs := make([]uint, len(pages))
+	for i, page := range pages {
+		ids[page.URL] = page.ID
+		pageIDs[i] = page.ID
+	}
+
+	// the upsert already holds the row locks, this reload only reads the version counters
+	var locked []models.CrawlPage
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("id IN ?", pageIDs).Order("id").Find(&locked).Error; err != nil {
+		return err
+	}
+	latestVersion := make(map[uint]int, len(locked))
+	for _, page := range locked {
+		latestVersion[page.ID] = page.LatestVersion
+	}
+
+	var current []models.PageVersion
+	if err := tx.Select("page_id", "version", "content_hash").
+		Where("page_id IN ? AND is_latest", pageIDs).Find(&current).Error; err != nil {
+		return err
+	}
+	latestHash := make(map[uint]string, len(current))
+	for _, v := range current {
+		latestHash[v.PageID] = v.ContentHash
+	}
+
+	metas := make(map[uint]models.PageMeta)
+	var versions []*models.PageVersion
+	newest := make(map[uint]*models.PageVersion)
+	extraction := make(map[uint]string)
+
+	for _, r := range batch {
+		r.PageID = ids[r.URL]
+		if r.Status == crawler.StatusNotModified {
+			r.Version = latestVersion[r.PageID]
+		}
+		if r.Status != crawler.StatusSuccess {
+			continue
+		}
+
+		if r.Meta != nil {
+			meta := *r.Meta
+			meta.PageID = r.PageID
+			metas[r.PageID] = meta
+		}
+
+		if hash, ok := latestHash[r.PageID]; ok && hash == r.ContentHash {
+			r.Version = latestVersion[r.PageID]
+			continue
+		}
+
+		elements := make([]models.Element, len(r.Elements))
+		for i, e := range r.Elements {
+			e.PageID = r.PageID
+			elements[i] = e
+		}
+
+		latestVersion[r.PageID]++
+		latestHash[r.PageID] = r.ContentHash
+		r.Version = latestVersion[r.PageID]
+
+		snapshot := &models.PageVersion{
+			PageID:      r.PageID,
+			Version:     r.Version,
+			FetchedAt:   r.FetchedAt,
+			ContentHash: r.ContentHash,
+			Elements:    elements,
+		}
+		versions = append(versions, snapshot)
+		newest[r.PageID] = snapshot
+		extraction[r.PageID] = r.ExtractionHash
+	}
+
+	if err := saveMetas(tx, metas); err != nil {
+		return err
+	}
+	if len(versions) == 0 {
+		return nil
+	}
+
+	changed := make([]uint, 0, len(newest))
+	for id, snapshot := range newest {
+		snapshot.IsLatest = true
+		changed = append(changed, id)
+	}
+
+	if err := tx.Model(&models.PageVersion{}).Where("page_id IN ? AND is_latest", changed).
+		Update("is_latest", false).Error; err != nil {
+		return err
+	}
+	if err := tx.Session(&gorm.Session{CreateBatchSize: elementBatchSize}).Create(&versions).Error; err != nil {
+		return err
+	}
+
+	for _, id := range changed {
+		if err := tx.Model(&models.CrawlPage{}).Where("id = ?", id).
+			Updates(map[string]any{"latest_version": newest[id].Version, "extraction_hash": extraction[id]}).Error; err != nil {
+			return err
+		}
+	}
+	return saveLinks(tx, changed, newest)
+}
+
+// saveLinks replaces the outgoing edges of the pages whose content changed with the links of their new version
+func saveLinks(tx *gorm.DB, changed []uint, newest map[uint]*models.PageVersion) error {
+	if err := tx.Unscoped().Where("from_page_id IN ?", changed).Delete(&models.Link{}).Error; err != nil {
+		return err
+	}
+
+	var links []models.Link
+	for _, id := range changed {
+		links = append(links, pageLinks(id, newest[id].Elements)...)
+	}
+	if len(links) == 0 {
+		return nil
+	}
+	return tx.Session(&gorm.Session{CreateBatchSize: elementBatchSize}).Create(&links).Error
+}
+
+func saveMetas(tx *gorm.DB, metas map[uint]models.PageMeta) error {
+	if len(metas) == 0 {
+		return nil
+	}
+
+	rows := make([]models.PageMeta, 0, len(metas))
+	for _, meta := range metas {
+		rows = append(rows, meta)
+	}
+	sort.Slice(rows, func(i, j int) bool {
+		return rows[i].PageID < rows[j].PageID
+	})
+
+	return tx.Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "page_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{
+			"updated_at", "title", "description", "keywords", "canonical", "language", "open_graph", "twitter", "json_ld",
+		}),
+	}).Create(&rows).Error
+}
+
+func (p *Postgres) PagesByURL(ctx context.Context, urls []string) (map[string]models.CrawlPage, error) {
+	pages := make(map[string]models.CrawlPage)
+	for start := 0; start < len(urls); start += lookupChunk {
+		var chunk []models.CrawlPage
+		if err := p.db.WithContext(ctx).Where("url IN ?", urls[start:min(start+lookupChunk, len(urls))]).
+			Find(&chunk).Error; err != nil {
+			return nil, err
+		}
+		for _, page := range chunk {
+			pages[page.URL] = page
+		}
+	}
+	return pages, nil
+}
+
+func (p *Postgres) SetSitemapEntries(ctx context.Context, entries map[uint]crawler.SitemapURL) error {
+	if len(entries) == 0 {
+		return nil
+	}
+
+	ids := make([]uint, 0, len(entries))
+	for id := range entries {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	return p.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		for _, id := range ids {
+			if err := tx.Model(&models.CrawlPage{}).Where("id = ?", id).Updates(map[string]any{
+				"sitemap_last_mod": entries[id].LastMod,
+				"sitemap_priority": entries[id].Priority,
+			}).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// timeRange applies the range to column
+func timeRange(query *gorm.DB, column string, r TimeRange) *gorm.DB {
+	if !r.Since.IsZero() {
+		query = query.Where(column+" >= ?", r.Since)
+	}
+	if !r.Until.IsZero() {
+		query = query.Where(column+" < ?", r.Until)
+	}
+	return query
+}
+
+func (p *Postgres) ListPages(ctx context.Context, filter PageFilter) ([]models.CrawlPage, int64, error) {
+	query := p.db.WithContext(ctx).Model(&models.CrawlPage{})
+	if filter.Status != "" {
+		query = query.Where("status = ?", filter.Status)
+	}
+	query = timeRange(query, "last_crawled_at", filter.Crawled)
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	pages := []models.CrawlPage{}
+	err := query.Order("last_crawled_at DESC, id").Limit(filter.Limit).Offset(filter.Offset).Find(&pages).Error
+	return pages, total, err
+}
+
+func (p *Postgres) GetPage(ctx context.Context, id uint) (models.CrawlPage, error) {
+	var page models.CrawlPage
+	err := p.db.WithContext(ctx).First(&page, id).Error
+	return page, notFound(err)
+}
+
+func (p *Postgres) ListVersions(ctx context.Context, pageID uint) ([]models.PageVersion, error) {
+	versions := []models.PageVersion{}
+	err := p.db.WithContext(ctx).Where("page_id = ?", pageID).Order("version DESC").Find(&versions).Error
+	return versions, err
+}
+
+func (p *Postgres) VersionElements(ctx context.Context, pageID uint, version int) ([]models.Element, error) {
+	var snapshot models.PageVersion
+	err := p.db.WithContext(ctx).Preload("Elements", func(db *gorm.DB) *gorm.DB {
+		return db.Order("position")
+	}).Where("page_id = ? AND version = ?", pageID, version).First(&snapshot).Error
+	if err != nil {
+		return nil, notFound(err)
+	}
+	return snapshot.Elements, nil
+}
+
+func (p *Postgres) GetMeta(ctx context.Context, pageID uint) (models.PageMeta, error) {
+	var meta models.PageMeta
+	err := p.db.WithContext(ctx).Where("page_id = ?", pageID).First(&meta).Error
+	return meta, notFound(err)
+}
+
+// likeEscaper makes the wildcards of a LIKE pattern match themselves, with a backslash as the escape character
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+func (p *Postgres) ListMeta(ctx context.Context, filter MetaFilter) ([]MetaRow, error) {
+	query := p.db.WithContext(ctx).Model(&models.PageMeta{}).
+		Select("page_meta.*, crawl_pages.url").
+		Joins("JOIN crawl_pages ON crawl_pages.id = page_meta.page_id AND crawl_pages.deleted_at IS NULL")
+
+	if filter.Language != "" {
+		query = query.Where("page_meta.language = ?", filter.Language)
+	}
+	if filter.URLPrefix != "" {
+		query = query.Where(`crawl_pages.url LIKE ? ESCAPE '\'`, likeEscaper.Replace(filter.URLPrefix)+"%")
+	}
+
+	switch filter.Missing {
+	case MissingTitle:
+		query = query.Where("page_meta.title = ''")
+	case MissingDescription:
+		query = query.Where("page_meta.description = ''")
+	case MissingCanonical:
+		query = query.Where("page_meta.canonical = ''")
+	case MissingOpenGraph:
+		query = query.Where("page_meta.open_graph IS NULL")
+	}
+
+	rows := []MetaRow{}
+	err := query.Order("page_meta.page_id").Limit(filter.Limit).Offset(filter.Offset).Scan(&rows).Error
+	return rows, err
+}
+
+func (p *Postgres) ListElements(ctx context.Context, filter ElementFilter) ([]models.Element, error) {
+	query := p.db.WithContext(ctx).Model(&models.Element{}).
+		Joins("JOIN page_versions ON page_versions.id = elements.version_id AND page_versions.is_latest AND page_versions.deleted_at IS NULL")
+
+	if filter.Type != "" {
+		query = query.Where("elements.element_type = ?", filter.Type)
+	}
+	if filter.Field != "" {
+		query = query.Where("elements.field = ?", filter.Field)
+	}
+	if filter.PageID > 0 {
+		query = query.Where("elements.page_id = ?", filter.PageID)
+	}
+	query = timeRange(query, "page_versions.fetched_at", filter.Fetched)
+
+	elements := []models.Element{}
+	err := query.Order("elements.page_id, elements.position").Limit(filter.Limit).Offset(filter.Offset).Find(&elements).Error
+	return elements, err
+}
+
+func (p *Postgres) Search(ctx context.Context, q SearchQuery) ([]SearchHit, error) {
+	vector := "to_tsvector('" + SearchConfig + "', elements.content)"
+	tsQuery := "plainto_tsquery('" + SearchConfig + "', ?)"
+
+	query := p.db.WithContext(ctx).Table("elements").
+		Select(`elements.id AS element_id, elements.page_id, crawl_pages.url, page_versions.version,
+			page_versions.fetched_at, elements.element_type, elements.field, elements.content, elements.attribute,
+			ts_rank(`+vector+`, `+tsQuery+`) AS rank`, q.Text).
+		Joins("JOIN page_versions ON page_versions.id = elements.version_id AND page_versions.is_latest AND page_versions.deleted_at IS NULL").
+		Joins("JOIN crawl_pages ON crawl_pages.id = elements.page_id AND crawl_pages.deleted_at IS NULL").
+		Where("elements.deleted_at IS NULL").
+		Where(vector+" @@ "+tsQuery, q.Text)
+
+	if q.Type != "" {
+		query = query.Where("elements.element_type = ?", q.Type)
+	}
+	query = timeRange(query, "page_versions.fetched_at", q.Fetched)
+
+	if q.ByDate {
+		query = query.Order("page_versions.fetched_at DESC, rank DESC")
+	} else {
+		query = query.Order("rank DESC, page_versions.fetched_at DESC")
+	}
+
+	hits := []SearchHit{}
+	err := query.Limit(q.Limit).Offset(q.Offset).Scan(&hits).Error
+	return hits, err
+}
+
+func (p *Postgres) LinkGraph(ctx context.Context) ([]models.CrawlPage, []models.Link, error) {
+	var pages []models.CrawlPage
+	if err := p.db.WithContext(ctx).Select("id", "url").Where("latest_version > 0").Order("id").Find(&pages).Error; err != nil {
+		return nil, nil, err
+	}
+
+	var links []models.Link
+	if err := p.db.WithContext(ctx).Select("from_page_id", "to_url").Find(&links).Error; err != nil {
+		return nil, nil, err
+	}
+	return pages, links, nil
+}
+
+func (p *Postgres) ReplaceRanks(ctx context.Context, ranks []models.PageRank) error {
+	return p.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Session(&gorm.Session{AllowGlobalUpdate: true}).Unscoped().Delete(&models.PageRank{}).Error; err != nil {
+			return err
+		}
+		if len(ranks) == 0 {
+			return nil
+		}
+		return tx.Session(&gorm.Session{CreateBatchSize: elementBatchSize}).Create(&ranks).Error
+	})
+}
+
+func (p *Postgres) TopRanks(ctx context.Context, window Window) ([]models.PageRank, error) {
+	ranks := []models.PageRank{}
+	err := p.db.WithContext(ctx).Order("rank DESC, page_id").Limit(window.Limit).Offset(window.Offset).Find(&ranks).Error
+	return ranks, err
+}
+
+func (p *Postgres) InboundLinks(ctx context.Context, url string, window Window) ([]InboundLink, int64, error) {
+	query := p.db.WithContext(ctx).Model(&models.Link{}).
+		Joins("JOIN crawl_pages ON crawl_pages.id = links.from_page_id AND crawl_pages.deleted_at IS NULL").
+		Where("links.to_url = ?", url)
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	items := []InboundLink{}
+	err := query.Select("links.from_page_id AS page_id, crawl_pages.url, links.anchor_text").
+		Order("crawl_pages.url").Limit(window.Limit).Offset(window.Offset).Scan(&items).Error
+	return items, total, err
+}
+
+func (p *Postgres) Fingerprints(ctx context.Context) ([]models.CrawlPage, error) {
+	var pages []models.CrawlPage
+	err := p.db.WithContext(ctx).Select("id", "url", "sim_hash").Where("sim_hash <> 0").Order("id").Find(&pages).Error
+	return pages, err
+}
+
+func (p *Postgres) ReplaceDuplicates(ctx context.Context, duplicateOf map[uint]uint) error {
+	members := make(map[uint][]uint)
+	for id, first := range duplicateOf {
+		members[first] = append(members[first], id)
+	}
+	firsts := make([]uint, 0, len(members))
+	for first := range members {
+		firsts = append(firsts, first)
+	}
+	sort.Slice(firsts, func(i, j int) bool { return firsts[i] < firsts[j] })
+
+	// the flags are derived, rewriting them leaves updated_at alone
+	return p.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&models.CrawlPage{}).Where("duplicate_of <> 0").UpdateColumn("duplicate_of", 0).Error; err != nil {
+			return err
+		}
+		for _, first := range firsts {
+			ids := members[first]
+			for start := 0; start < len(ids); start += lookupChunk {
+				chunk := ids[start:min(start+lookupChunk, len(ids))]
+				if err := tx.Model(&models.CrawlPage{}).Where("id IN ?", chunk).UpdateColumn("duplicate_of", first).Error; err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+}
+
+func (p *Postgres) DuplicateClusters(ctx context.Context, window Window) ([]DuplicateCluster, int64, error) {
+	query := p.db.WithContext(ctx).Model(&models.CrawlPage{}).Where("duplicate_of <> 0")
+
+	var total int64
+	if err := query.Distinct("duplicate_of").Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	var firsts []uint
+	err := p.db.WithContext(ctx).Model(&models.CrawlPage{}).Where("duplicate_of <> 0").
+		Select("duplicate_of").Group("duplicate_of").Order("COUNT(*) DESC, duplicate_of").
+		Limit(window.Limit).Offset(window.Offset).Scan(&firsts).Error
+	if err != nil || len(firsts) == 0 {
+		return []DuplicateCluster{}, total, err
+	}
+
+	var pages []models.CrawlPage
+	err = p.db.WithContext(ctx).Select("id", "url", "sim_hash", "duplicate_of").
+		Where("id IN ? OR duplicate_of IN ?", firsts, firsts).Find(&pages).Error
+	if err != nil {
+		return nil, 0, err
+	}
+	return duplicateClusters(firsts, pages), total, nil
+}
+
+func (p *Postgres) CreateSchedule(ctx context.Context, schedule *models.CrawlSchedule) error {
+	return p.db.WithContext(ctx).Create(schedule).Error
+}
+
+func (p *Postgres) ListSchedules(ctx context.Context, enabledOnly bool) ([]models.CrawlSchedule, error) {
+	query := p.db.WithContext(ctx).Order("id")
+	if enabledOnly {
+		query = query.Where("enabled")
+	}
+
+	schedules := []models.CrawlSchedule{}
+	err := query.Find(&schedules).Error
+	return schedules, err
+}
+
+func (p *Postgres) GetSchedule(ctx context.Context, id uint) (models.CrawlSchedule, error) {
+	var schedule models.CrawlSchedule
+	err := p.db.WithContext(ctx).First(&schedule, id).Error
+	return schedule, notFound(err)
+}
+
+func (p *Postgres) SaveSchedule(ctx context.Context, schedule *models.CrawlSchedule) error {
+	return p.db.WithContext(ctx).Save(schedule).Error
+}
+
+func (p *Postgres) DeleteSchedule(ctx context.Context, id uint) error {
+	result := p.db.WithContext(ctx).Delete(&models.CrawlSchedule{}, id)
+	if result.Error == nil && result.RowsAffected == 0 {
+		return ErrNotFound
+	}
+	return result.Error
+}
+
+func (p *Postgres) SaveRun(ctx context.Context, run *models.CrawlRun) error {
+	return p.db.WithContext(ctx).Save(run).Error
+}
+
+// ClaimRun locks the schedule row, so processes claiming the same time check for each other's run one at a time
+func (p *Postgres) ClaimRun(ctx context.Context, run *models.CrawlRun) error {
+	return p.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var schedule models.CrawlSchedule
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Select("id").First(&schedule, run.ScheduleID).Error; err != nil {
+			return notFound(err)
+		}
+
+		var claimed int64
+		if err := tx.Model(&models.CrawlRun{}).Where("schedule_id = ? AND scheduled_at = ?", run.ScheduleID, run.ScheduledAt).
+			Count(&claimed).Error; err != nil {
+			return err
+		}
+		if claimed > 0 {
+			return ErrConflict
+		}
+		return tx.Create(run).Error
+	})
+}
+
+func (p *Postgres) ListRuns(ctx context.Context, scheduleID uint, window Window) ([]models.CrawlRun, error) {
+	runs := []models.CrawlRun{}
+	err := p.db.WithContext(ctx).Where("schedule_id = ?", scheduleID).
+		Order("scheduled_at DESC, id DESC").Limit(window.Limit).Offset(window.Offset).Find(&runs).Error
+	return runs, err
+}
+
+func (p *Postgres) CreateFrontierJob(ctx context.Context, job *models.FrontierJob, urls []string) error {
+	rows := frontierRows(job.ID, urls)
+	job.Total = len(rows)
+
+	return p.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		result := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(job)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrConflict
+		}
+		if len(rows) == 0 {
+			return nil
+		}
+		return tx.Session(&gorm.Session{CreateBatchSize: elementBatchSize}).Create(&rows).Error
+	})
+}
+
+func (p *Postgres) GetFrontierJob(ctx context.Context, id string) (models.FrontierJob, error) {
+	var job models.FrontierJob
+	err := p.db.WithContext(ctx).Where("id = ?", id).First(&job).Error
+	return job, notFound(err)
+}
+
+func (p *Postgres) FrontierProgress(ctx context.Context, jobID string) (FrontierProgress, error) {
+	job, err := p.GetFrontierJob(ctx, jobID)
+	if err != nil {
+		return FrontierProgress{}, err
+	}
+
+	var counts []struct {
+		State  string
+		Status string
+		N      int
+	}
+	err = p.db.WithContext(ctx).Model(&models.FrontierURL{}).Select("state, status, count(*) AS n").
+		Where("job_id = ?", jobID).Group("state, status").Scan(&counts).Error
+	if err != nil {
+		return FrontierProgress{}, err
+	}
+
+	progress := FrontierProgress{JobID: job.ID, Total: job.Total, Statuses: make(map[string]int)}
+	for _, c := range counts {
+		progress.add(c.State, c.Status, c.N)
+	}
+	return progress, nil
+}
+
+// LeaseURLs locks the URLs it hands out with SKIP LOCKED, workers leasing at the same time
+// pass over each other's rows instead of waiting for them
+func (p *Postgres) LeaseURLs(ctx context.Context, req LeaseRequest) ([]models.FrontierURL, error) {
+	if req.Limit <= 0 {
+		return nil, nil
+	}
+
+	var leased []models.FrontierURL
+	err := p.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+
+		if req.MaxLeases > 0 {
+			if err := tx.Model(&models.FrontierURL{}).
+				Where("(state = ? OR (state = ? AND lease_expires_at < ?)) AND leases >= ?",
+					models.FrontierPending, models.FrontierLeased, now, req.MaxLeases).
+				Updates(map[string]any{"state": models.FrontierAbandoned, "error": errAbandoned, "done_at": now}).Error; err != nil {
+				return err
+			}
+		}
+
+		var ids []uint
+		if err := tx.Model(&models.FrontierURL{}).Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+			Where("state = ? OR (state = ? AND lease_expires_at < ?)", models.FrontierPending, models.FrontierLeased, now).
+			Order("id").Limit(req.Limit).Pluck("id", &ids).Error; err != nil {
+			return err
+		}
+		if len(ids) == 0 {
+			return nil
+		}
+
+		if err := tx.Model(&models.FrontierURL{}).Where("id IN ?", ids).Updates(map[string]any{
+			"state":            models.FrontierLeased,
+			"lease_owner":      req.Owner,
+			"lease_expires_at": now.Add(req.TTL),
+			"token":            gorm.Expr("token + 1"),
+			"leases":           gorm.Expr("leases + 1"),
+		}).Error; err != nil {
+			return err
+		}
+		return tx.Where("id IN ?", ids).Order("id").Find(&leased).Error
+	})
+	return leased, err
+}
+
+func (p *Postgres) RenewLeases(ctx context.Context, leases []models.FrontierURL, ttl time.Duration) ([]uint, error) {
+	var lost []uint
+	err := p.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		lost = nil
+		expires := time.Now().Add(ttl)
+
+		for _, i := range leaseOrder(leases) {
+			result := fenced(tx, leases[i]).Update("lease_expires_at", expires)
+			if result.Error != nil {
+				return result.Error
+			}
+			if result.RowsAffected == 0 {
+				lost = append(lost, leases[i].ID)
+			}
+		}
+		return nil
+	})
+	return lost, err
+}
+
+func (p *Postgres) ReleaseLeases(ctx context.Context, leases []models.FrontierURL) error {
+	return p.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		for _, i := range leaseOrder(leases) {
+			err := fenced(tx, leases[i]).Updates(map[string]any{
+				"state":            models.FrontierPending,
+				"lease_owner":      "",
+				"lease_expires_at": time.Time{},
+			}).Error
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// fenced selects the URL of the lease, as long as the lease is still the current one
+func fenced(tx *gorm.DB, lease models.FrontierURL) *gorm.DB {
+	return tx.Model(&models.FrontierURL{}).
+		Where("id = ? AND token = ? AND state = ?", lease.ID, lease.Token, models.FrontierLeased)
+}
+
+func (p *Postgres) SaveLeasedPages(ctx context.Context, leases []models.FrontierURL, batch []*crawler.PageResult) error {
+	var lost []*crawler.PageResult
+	err := p.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		lost = nil
+		now := time.Now()
+
+		// the URLs are marked done first, their row locks keep the lease from changing hands until the commit
+		var kept []*crawler.PageResult
+		var keptIDs []uint
+		for _, i := range leaseOrder(leases) {
+			r := batch[i]
+			result := fenced(tx, leases[i]).Updates(map[string]any{
+				"state":   models.FrontierDone,
+				"status":  r.Status,
+				"error":   r.Error,
+				"done_at": now,
+			})
+			if result.Error != nil {
+				return result.Error
+			}
+			if result.RowsAffected == 0 {
+				lost = append(lost, r)
+				continue
+			}
+			kept = append(kept, r)
+			keptIDs = append(keptIDs, leases[i].ID)
+		}
+		if len(kept) == 0 {
+			return nil
+		}
+
+		if err := writeBatch(tx, kept); err != nil {
+			return err
+		}
+		for i, r := range kept {
+			if err := tx.Model(&models.FrontierURL{}).Where("id = ?", keptIDs[i]).
+				Update("page_id", r.PageID).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		for _, r := range batch {
+			r.PageID, r.Version = 0, 0
+		}
+		return err
+	}
+
+	for _, r := range lost {
+		leaseLost(r)
+	}
+	return nil
+}
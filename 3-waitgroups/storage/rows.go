@@ -0,0 +1,136 @@
+package storage
+
+import (
+	"exercise3/crawler"
+	"exercise3/models"
+	"exercise3/simhash"
+	"sort"
+	"time"
+)
+
+// pageRows turns the batch into one page row per URL, the last result of a URL wins
+func pageRows(batch []*crawler.PageResult) []models.CrawlPage {
+	byURL := make(map[string]models.CrawlPage, len(batch))
+	for _, r := range batch {
+		page := models.CrawlPage{
+			URL:           r.URL,
+			Status:        r.Status,
+			StatusCode:    r.StatusCode,
+			Error:         r.Error,
+			Attempts:      r.Attempts,
+			LastError:     r.LastError,
+			ContentType:   r.ContentType,
+			Bytes:         r.Bytes,
+			LastCrawledAt: r.FetchedAt,
+			ETag:          r.ETag,
+			LastModified:  r.LastModified,
+			SimHash:       int64(r.SimHash),
+		}
+		// the stored size is the page's, not what a 304 transferred
+		if r.Status == crawler.StatusNotModified {
+			page.Bytes = r.BytesSaved
+		}
+		if page.LastCrawledAt.IsZero() {
+			page.LastCrawledAt = time.Now()
+		}
+		byURL[r.URL] = page
+	}
+
+	pages := make([]models.CrawlPage, 0, len(byURL))
+	for _, page := range byURL {
+		pages = append(pages, page)
+	}
+	sort.Slice(pages, func(i, j int) bool {
+		return pages[i].URL < pages[j].URL
+	})
+	return pages
+}
+
+// fetchedColumns are the columns only a crawl that got the page writes, a failed one keeps what was stored
+var fetchedColumns = []string{"content_type", "bytes", "last_crawled_at", "etag", "last_modified"}
+
+// fetched reports whether the crawl got the page, either its body or a 304 confirming the stored one
+func fetched(status string) bool {
+	return status == crawler.StatusSuccess || status == crawler.StatusNotModified
+}
+
+// pageLinks keeps one edge per target, with the first anchor text that pointed at it
+func pageLinks(pageID uint, elements []models.Element) []models.Link {
+	seen := make(map[string]bool)
+	var links []models.Link
+	for _, e := range elements {
+		if e.ElementType != "a" || e.ResolvedURL == "" || seen[e.ResolvedURL] {
+			continue
+		}
+		seen[e.ResolvedURL] = true
+		links = append(links, models.Link{FromPageID: pageID, ToURL: e.ResolvedURL, AnchorText: e.Content})
+	}
+	return links
+}
+
+// duplicateClusters builds the clusters starting at the firsts, in that order, out of the pages they are made of
+func duplicateClusters(firsts []uint, pages []models.CrawlPage) []DuplicateCluster {
+	byID := make(map[uint]models.CrawlPage, len(pages))
+	for _, page := range pages {
+		byID[page.ID] = page
+	}
+
+	index := make(map[uint]int, len(firsts))
+	clusters := make([]DuplicateCluster, len(firsts))
+	for i, id := range firsts {
+		index[id] = i
+		clusters[i] = DuplicateCluster{PageID: id, URL: byID[id].URL, Duplicates: []DuplicatePage{}}
+	}
+
+	sort.Slice(pages, func(i, j int) bool {
+		return pages[i].ID < pages[j].ID
+	})
+	for _, page := range pages {
+		i, ok := index[page.DuplicateOf]
+		if page.DuplicateOf == 0 || !ok {
+			continue
+		}
+		clusters[i].Duplicates = append(clusters[i].Duplicates, DuplicatePage{
+			PageID:   page.ID,
+			URL:      page.URL,
+			Distance: simhash.Distance(uint64(page.SimHash), uint64(byID[page.DuplicateOf].SimHash)),
+		})
+	}
+	return clusters
+}
+
+// errAbandoned is the error of a frontier URL given up on
+const errAbandoned = "leased too many times without being crawled"
+
+// frontierRows puts the urls of a job on the frontier, each URL once
+func frontierRows(jobID string, urls []string) []models.FrontierURL {
+	seen := make(map[string]bool, len(urls))
+	rows := make([]models.FrontierURL, 0, len(urls))
+	for _, url := range urls {
+		if seen[url] {
+			continue
+		}
+		seen[url] = true
+		rows = append(rows, models.FrontierURL{JobID: jobID, URL: url, State: models.FrontierPending})
+	}
+	return rows
+}
+
+// leaseOrder returns the indexes of leases ordered by URL id, so transactions touching the same URLs lock them in the same order
+func leaseOrder(leases []models.FrontierURL) []int {
+	order := make([]int, len(leases))
+	for i := range order {
+		order[i] = i
+	}
+	sort.Slice(order, func(a, b int) bool {
+		return leases[order[a]].ID < leases[order[b]].ID
+	})
+	return order
+}
+
+// leaseLost marks a page the worker may not store anymore, the worker holding the lease now does
+func leaseLost(r *crawler.PageResult) {
+	r.PageID, r.Version = 0, 0
+	r.Status = crawler.StatusLeaseLost
+	r.Error = "lease taken over by another worker"
+}
@@ -0,0 +1,217 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"exercise3/crawler"
+	"exercise3/models"
+	"time"
+)
+
+var (
+	ErrNotFound = errors.New("record not found")
+	ErrConflict = errors.New("record already exists")
+)
+
+// Store is everything the API and the crawl jobs persist. Postgres is the production backend,
+// Memory keeps the same behaviour in process for local runs and tests.
+type Store interface {
+	// SavePages writes a persisted batch of the pipeline in one go: page rows by URL, metadata,
+	// a new version with its elements and links for every page whose content hash changed.
+	// It fills PageID and Version of the results, nothing is written when it fails.
+	SavePages(ctx context.Context, batch []*crawler.PageResult) error
+	// PagesByURL returns the stored pages among urls, keyed by URL
+	PagesByURL(ctx context.Context, urls []string) (map[string]models.CrawlPage, error)
+	// SetSitemapEntries records the lastmod and priority a sitemap gave the pages, keyed by page id
+	SetSitemapEntries(ctx context.Context, entries map[uint]crawler.SitemapURL) error
+
+	ListPages(ctx context.Context, filter PageFilter) ([]models.CrawlPage, int64, error)
+	GetPage(ctx context.Context, id uint) (models.CrawlPage, error)
+	ListVersions(ctx context.Context, pageID uint) ([]models.PageVersion, error)
+	VersionElements(ctx context.Context, pageID uint, version int) ([]models.Element, error)
+	GetMeta(ctx context.Context, pageID uint) (models.PageMeta, error)
+	ListMeta(ctx context.Context, filter MetaFilter) ([]MetaRow, error)
+	ListElements(ctx context.Context, filter ElementFilter) ([]models.Element, error)
+	Search(ctx context.Context, query SearchQuery) ([]SearchHit, error)
+
+	// LinkGraph returns the pages with a stored version and every stored link
+	LinkGraph(ctx context.Context) ([]models.CrawlPage, []models.Link, error)
+	// ReplaceRanks swaps the stored PageRank results for ranks
+	ReplaceRanks(ctx context.Context, ranks []models.PageRank) error
+	TopRanks(ctx context.Context, window Window) ([]models.PageRank, error)
+	InboundLinks(ctx context.Context, url string, window Window) ([]InboundLink, int64, error)
+
+	// Fingerprints returns id, URL and SimHash of the pages with a fingerprint, by id
+	Fingerprints(ctx context.Context) ([]models.CrawlPage, error)
+	// ReplaceDuplicates swaps the stored clusters for duplicateOf, which maps the id of every duplicate
+	// to the id of the first page of its cluster. Pages not in it are no longer duplicates.
+	ReplaceDuplicates(ctx context.Context, duplicateOf map[uint]uint) error
+	// DuplicateClusters lists the stored clusters, largest first, and counts them
+	DuplicateClusters(ctx context.Context, window Window) ([]DuplicateCluster, int64, error)
+
+	CreateSchedule(ctx context.Context, schedule *models.CrawlSchedule) error
+	ListSchedules(ctx context.Context, enabledOnly bool) ([]models.CrawlSchedule, error)
+	GetSchedule(ctx context.Context, id uint) (models.CrawlSchedule, error)
+	SaveSchedule(ctx context.Context, schedule *models.CrawlSchedule) error
+	DeleteSchedule(ctx context.Context, id uint) error
+	// SaveRun creates the run when it has no id yet and updates it otherwise
+	SaveRun(ctx context.Context, run *models.CrawlRun) error
+	// ClaimRun creates the run unless its schedule already has one for the same ScheduledAt, then it returns ErrConflict.
+	// The scheduler of every process fires the same times, the first to claim one runs it.
+	ClaimRun(ctx context.Context, run *models.CrawlRun) error
+	ListRuns(ctx context.Context, scheduleID uint, window Window) ([]models.CrawlRun, error)
+
+	// CreateFrontierJob stores a distributed job and puts its urls on the frontier, a URL listed twice
+	// is crawled once. It fills job.Total and returns ErrConflict when the job id is taken.
+	CreateFrontierJob(ctx context.Context, job *models.FrontierJob, urls []string) error
+	GetFrontierJob(ctx context.Context, id string) (models.FrontierJob, error)
+	FrontierProgress(ctx context.Context, jobID string) (FrontierProgress, error)
+	// LeaseURLs hands the owner pending URLs and URLs whose lease expired, oldest first.
+	// Concurrent callers never get the same URL.
+	LeaseURLs(ctx context.Context, req LeaseRequest) ([]models.FrontierURL, error)
+	// RenewLeases extends the leases by ttl and returns the ids of those that were taken over meanwhile
+	RenewLeases(ctx context.Context, leases []models.FrontierURL, ttl time.Duration) ([]uint, error)
+	// ReleaseLeases puts URLs that couldn't be crawled back on the frontier for the next worker
+	ReleaseLeases(ctx context.Context, leases []models.FrontierURL) error
+	// SaveLeasedPages is SavePages fenced by the leases, leases[i] being the lease of batch[i]:
+	// in the same transaction every URL is marked done, as long as its lease token is unchanged.
+	// Pages whose lease was taken over aren't written and get StatusLeaseLost, so a URL is stored once per job.
+	SaveLeasedPages(ctx context.Context, leases []models.FrontierURL, batch []*crawler.PageResult) error
+}
+
+// Window is one page of a listing
+type Window struct {
+	Limit  int
+	Offset int
+}
+
+// TimeRange bounds a timestamp, Since is inclusive and Until exclusive, zero values are open ends
+type TimeRange struct {
+	Since time.Time
+	Until time.Time
+}
+
+func (r TimeRange) contains(t time.Time) bool {
+	return (r.Since.IsZero() || !t.Before(r.Since)) && (r.Until.IsZero() || t.Before(r.Until))
+}
+
+// PageFilter narrows ListPages, Crawled applies to the last crawl time
+type PageFilter struct {
+	Status  string
+	Crawled TimeRange
+	Window
+}
+
+// ElementFilter narrows ListElements to the latest versions, Fetched applies to their fetch time
+type ElementFilter struct {
+	Type    string
+	Field   string
+	PageID  uint
+	Fetched TimeRange
+	Window
+}
+
+// Values of MetaFilter.Missing
+const (
+	MissingTitle       = "title"
+	MissingDescription = "description"
+	MissingCanonical   = "canonical"
+	MissingOpenGraph   = "og"
+)
+
+// MetaFilter narrows ListMeta, Missing keeps the pages that lack that field
+type MetaFilter struct {
+	Language  string
+	URLPrefix string
+	Missing   string
+	Window
+}
+
+// MetaRow is page metadata together with the page URL
+type MetaRow struct {
+	models.PageMeta
+	URL string `json:"url"`
+}
+
+// SearchQuery is a full-text search over the latest versions, ranked by relevance unless ByDate is set
+type SearchQuery struct {
+	Text    string
+	Type    string
+	Fetched TimeRange
+	ByDate  bool
+	Window
+}
+
+type SearchHit struct {
+	ElementID   uint      `json:"element_id"`
+	PageID      uint      `json:"page_id"`
+	URL         string    `json:"url"`
+	Version     int       `json:"version"`
+	FetchedAt   time.Time `json:"fetched_at"`
+	ElementType string    `json:"element_type"`
+	Field       string    `json:"field,omitempty"`
+	Content     string    `json:"content"`
+	Attribute   string    `json:"attribute,omitempty"`
+	Rank        float64   `json:"rank"`
+}
+
+// LeaseRequest asks for up to Limit URLs for TTL. A URL already leased MaxLeases times,
+// whether the leases ran out or were released, is abandoned instead of handed out again, zero never gives up.
+type LeaseRequest struct {
+	Owner     string
+	Limit     int
+	TTL       time.Duration
+	MaxLeases int
+}
+
+// FrontierProgress counts the URLs of a distributed job by state, and the done ones by crawl status
+type FrontierProgress struct {
+	JobID     string         `json:"job_id"`
+	Total     int            `json:"total"`
+	Pending   int            `json:"pending"`
+	Leased    int            `json:"leased"`
+	Done      int            `json:"done"`
+	Abandoned int            `json:"abandoned"`
+	Statuses  map[string]int `json:"statuses"`
+}
+
+// Finished reports whether every URL of the job is done or abandoned
+func (p FrontierProgress) Finished() bool {
+	return p.Done+p.Abandoned == p.Total
+}
+
+// add counts n URLs in state with the crawl status
+func (p *FrontierProgress) add(state, status string, n int) {
+	switch state {
+	case models.FrontierPending:
+		p.Pending += n
+	case models.FrontierLeased:
+		p.Leased += n
+	case models.FrontierDone:
+		p.Done += n
+		p.Statuses[status] += n
+	case models.FrontierAbandoned:
+		p.Abandoned += n
+	}
+}
+
+// InboundLink is a crawled page linking to a URL
+type InboundLink struct {
+	PageID     uint   `json:"page_id"`
+	URL        string `json:"url"`
+	AnchorText string `json:"anchor_text"`
+}
+
+// DuplicateCluster is a page and the pages the last detection found to be near-duplicates of it
+type DuplicateCluster struct {
+	PageID     uint            `json:"page_id"`
+	URL        string          `json:"url"`
+	Duplicates []DuplicatePage `json:"duplicates"`
+}
+
+// DuplicatePage is a member of a cluster, Distance is the Hamming distance of its SimHash to the first page's
+type DuplicatePage struct {
+	PageID   uint   `json:"page_id"`
+	URL      string `json:"url"`
+	Distance int    `json:"distance"`
+}
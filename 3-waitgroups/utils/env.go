@@ -1,15 +1,26 @@
 package utils
 
 import (
+	"errors"
 	"github.com/joho/godotenv"
+	"io/fs"
 	"log"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 )
 
+// LoadEnv reads .env into the environment. Without the file the process environment is used as it is,
+// config.ConnectDatabase reports what's missing; a .env that can't be read stops the process.
 func LoadEnv() {
 	err := godotenv.Load()
+	if errors.Is(err, fs.ErrNotExist) {
+		log.Println("No .env file, using the process environment")
+		return
+	}
 	if err != nil {
-		log.Fatal("FAIL: .env file can not load")
+		log.Fatal("FAIL: .env file can not load: ", err)
 	}
 }
 
@@ -21,3 +32,43 @@ func GetEnv(key, fallback string) string {
 	}
 	return val
 }
+
+func GetEnvInt(key string, fallback int) int {
+	val, err := strconv.Atoi(os.Getenv(key))
+
+	if err != nil {
+		return fallback
+	}
+	return val
+}
+
+func GetEnvFloat(key string, fallback float64) float64 {
+	val, err := strconv.ParseFloat(os.Getenv(key), 64)
+
+	if err != nil {
+		return fallback
+	}
+	return val
+}
+
+func GetEnvDuration(key string, fallback time.Duration) time.Duration {
+	val, err := time.ParseDuration(os.Getenv(key))
+
+	if err != nil {
+		return fallback
+	}
+	return val
+}
+
+// ParseIntList reads a comma separated list like "429,500,503"
+func ParseIntList(s string) ([]int, error) {
+	var list []int
+	for _, part := range strings.Split(s, ",") {
+		n, err := strconv.Atoi(strings.TrimSpace(part))
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, n)
+	}
+	return list, nil
+}